@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the access log has a line for every request, for operators to see what the wiki is serving.
+// it's off by default. -access-log turns it on with one of the formats.
+//
+//	combined  the combined log format of apache and nginx, with the duration in seconds and the request id at the end
+//	json      a json object per line
+//
+// user is the logged in user who the handler saw, or "-".
+// it's not written with slog (see logging.go), as analyzers of access logs
+// expect a line per request in their format, and nothing else.
+
+// accessLog writes lines of the access log.
+type accessLog struct {
+	format string
+
+	mu sync.Mutex
+	w  io.Writer
+	// f is the file of w, or nil for stdout.
+	f *os.File
+}
+
+// openAccessLog opens the access log in the format.
+// the log is written to the file, or to stdout when the file is empty.
+func openAccessLog(format, file string) (*accessLog, error) {
+	if format != "combined" && format != "json" {
+		return nil, fmt.Errorf("unknown access log format: %s. combined or json", format)
+	}
+	l := &accessLog{format: format}
+	err := l.reopen(file)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reopen opens the file again, or another one, and closes the old one.
+// it's for logrotate, which moves the file and lets the wiki create a new one.
+func (l *accessLog) reopen(file string) error {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if file != "" {
+		var err error
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	l.mu.Lock()
+	old := l.f
+	l.w, l.f = w, f
+	l.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// accessEntry is a request in the access log.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	User      string    `json:"user"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+type accessEntryKey struct{}
+
+// noteAccessUser records the user of the request for the access log.
+func noteAccessUser(r *http.Request, name string) {
+	if e, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		e.User = name
+	}
+}
+
+// handler logs requests to h.
+func (l *accessLog) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &accessEntry{
+			Time:      time.Now(),
+			Remote:    clientAddr(r),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: requestID(r),
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, e)))
+		e.Status = sw.status
+		e.Bytes = sw.n
+		e.Duration = time.Since(e.Time).Seconds()
+		l.write(e)
+	})
+}
+
+func (l *accessLog) write(e *accessEntry) {
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(e)
+	} else {
+		line = combinedLine(e)
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// combinedLine formats the entry in the combined log format.
+func combinedLine(e *accessEntry) []byte {
+	field := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	// quotes and control characters from users shouldn't break the line.
+	quote := strconv.Quote
+	return []byte(fmt.Sprintf("%s - %s [%s] %s %d %d %s %s %.3f %s",
+		field(e.Remote),
+		field(strings.ReplaceAll(e.User, " ", "_")),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(e.Method+" "+e.URI+" "+e.Proto),
+		e.Status,
+		e.Bytes,
+		quote(field(e.Referer)),
+		quote(field(e.UserAgent)),
+		e.Duration,
+		field(e.RequestID),
+	))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// links to a section of a page (ex. /view/Whisky#install) are broken silently
+// when the heading is renamed or removed. anchor checks find such links.
+
+type BrokenAnchor struct {
+	// Page has the Link which points to the Anchor of the Target page.
+	Page   string
+	Link   string
+	Target string
+	Anchor string
+}
+
+// pageAnchors returns anchors which are made from headings of the page.
+// they should be same with the ids that the html renderer makes.
+func pageAnchors(body []byte) map[string]bool {
+	anchors := make(map[string]bool)
+	count := make(map[string]int)
+	md := blackfriday.New(blackfriday.WithExtensions(markdownExtensions))
+	md.Parse(body).Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || n.Type != blackfriday.Heading || n.HeadingID == "" {
+			return blackfriday.GoToNext
+		}
+		// same as blackfriday.HTMLRenderer.ensureUniqueHeadingID
+		id := n.HeadingID
+		for c, found := count[id]; found; c, found = count[id] {
+			tmp := fmt.Sprintf("%s-%d", id, c+1)
+			if _, tmpFound := count[tmp]; !tmpFound {
+				count[id] = c + 1
+				id = tmp
+			} else {
+				id = id + "-1"
+			}
+		}
+		count[id] = 0
+		anchors[id] = true
+		return blackfriday.GoToNext
+	})
+	return anchors
+}
+
+// pageLinks returns destinations of links in the page.
+func pageLinks(body []byte) []string {
+	links := []string{}
+	md := blackfriday.New(blackfriday.WithExtensions(markdownExtensions))
+	md.Parse(body).Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && n.Type == blackfriday.Link {
+			links = append(links, string(n.LinkData.Destination))
+		}
+		return blackfriday.GoToNext
+	})
+	return links
+}
+
+// anchorTarget returns the page title and the anchor that the link points to.
+// It returns false if the link is not a link to a section of a wiki page.
+func anchorTarget(from, link string) (title, anchor string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Fragment == "" {
+		return "", "", false
+	}
+	title, ok = linkTarget(from, u)
+	if !ok {
+		return "", "", false
+	}
+	return title, u.Fragment, true
+}
+
+// brokenAnchors checks links of the pages to sections.
+// pages have the latest pages of the wiki, those are looked up for targets.
+func brokenAnchors(check []*Page, pages map[string]*Page) []BrokenAnchor {
+	anchors := make(map[string]map[string]bool)
+	broken := []BrokenAnchor{}
+	for _, p := range check {
+		for _, link := range pageLinks(p.Body) {
+			title, anchor, ok := anchorTarget(p.Title, link)
+			if !ok {
+				continue
+			}
+			target, ok := pages[title]
+			if !ok {
+				// missing pages are not our business here.
+				continue
+			}
+			if anchors[title] == nil {
+				anchors[title] = pageAnchors(target.Body)
+			}
+			if !anchors[title][anchor] {
+				broken = append(broken, BrokenAnchor{Page: p.Title, Link: link, Target: title, Anchor: anchor})
+			}
+		}
+	}
+	return broken
+}
+
+func loadLatestPages(ctx context.Context) (map[string]*Page, error) {
+	titles, err := listTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*Page)
+	for _, t := range titles {
+		p, err := loadPage(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		pages[t] = p
+	}
+	return pages, nil
+}
+
+// checkPageAnchors checks anchors would be broken when the page is saved.
+// It checks links in the page, and links to the page from other pages.
+func checkPageAnchors(ctx context.Context, p *Page) ([]BrokenAnchor, error) {
+	pages, err := loadLatestPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages[p.Title] = p
+	check := []*Page{p}
+	for t, o := range pages {
+		if t != p.Title {
+			check = append(check, o)
+		}
+	}
+	all := brokenAnchors(check, pages)
+	broken := []BrokenAnchor{}
+	for _, b := range all {
+		if b.Page == p.Title || b.Target == p.Title {
+			broken = append(broken, b)
+		}
+	}
+	return broken, nil
+}
+
+// anchor report is made periodically in background, as it reads every page.
+
+type AnchorReport struct {
+	Title   string
+	Created time.Time
+	Broken  []BrokenAnchor
+}
+
+var (
+	anchorReportMu sync.Mutex
+	anchorReport   = &AnchorReport{}
+)
+
+func makeAnchorReport(ctx context.Context) (*AnchorReport, error) {
+	pages, err := loadLatestPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	check := []*Page{}
+	for _, p := range pages {
+		check = append(check, p)
+	}
+	broken := brokenAnchors(check, pages)
+	sort.Slice(broken, func(i, j int) bool {
+		if broken[i].Page != broken[j].Page {
+			return broken[i].Page < broken[j].Page
+		}
+		return broken[i].Link < broken[j].Link
+	})
+	return &AnchorReport{Created: time.Now(), Broken: broken}, nil
+}
+
+func reportAnchors(interval time.Duration) {
+	for {
+		start := time.Now()
+		var rep *AnchorReport
+		err := usingDB(func() (err error) {
+			rep, err = makeAnchorReport(context.Background())
+			return err
+		})
+		observeJob("anchor-report", start, err)
+		if err != nil {
+			slog.Error("could not make anchor report", "err", err)
+		} else {
+			anchorReportMu.Lock()
+			anchorReport = rep
+			anchorReportMu.Unlock()
+		}
+		time.Sleep(interval)
+	}
+}
+
+func anchorsHandler(w http.ResponseWriter, r *http.Request) {
+	anchorReportMu.Lock()
+	rep := anchorReport
+	anchorReportMu.Unlock()
+	renderTemplate(w, r, "anchors", rep)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// api is a json interface of the wiki for scripts and external tools.
+//
+// reading is open to anyone like the html pages.
+// writing needs an api token of a user. (Authorization: Bearer <token>)
+// tokens are made in /tokens page.
+//
+//	GET    /api/v1/pages/<title>            page (?rev=N for a revision)
+//	PUT    /api/v1/pages/<title>            save a new revision
+//	DELETE /api/v1/pages/<title>            delete the page (admins only)
+//	POST   /api/v1/pages/<title>/append     append a snippet to the page
+//	GET    /api/v1/pages/<title>/revisions  history (?from=N&limit=N)
+//	GET    /api/v1/pages/<title>/diff       diff of revisions (?from=N&to=N)
+//	GET    /api/v1/search                   search pages (?q=words)
+//	GET    /api/v1/sync                     latest revisions of all pages
+//	POST   /api/v1/sync/<title>             add revisions to the page (admins only)
+//	GET    /api/v1/backup                   snapshot of whisky.db (admins only)
+
+type APIPage struct {
+	Title       string      `json:"title"`
+	Revision    uint64      `json:"revision"`
+	Body        string      `json:"body"`
+	Created     time.Time   `json:"created"`
+	Author      string      `json:"author"`
+	Summary     string      `json:"summary,omitempty"`
+	Attribution string      `json:"attribution,omitempty"`
+	Provenance  *Provenance `json:"provenance,omitempty"`
+	License     *APILicense `json:"license,omitempty"`
+	// Words and ReadingMinutes are only in the page got by GET.
+	Words          int `json:"words,omitempty"`
+	ReadingMinutes int `json:"reading_minutes,omitempty"`
+}
+
+type APILicense struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type APIError struct {
+	Error string `json:"error"`
+	// Reference is the id of the request, to report the error.
+	Reference string `json:"reference,omitempty"`
+}
+
+// APIEdit is a request body for saving a page.
+type APIEdit struct {
+	Body        string `json:"body"`
+	Summary     string `json:"summary,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// apiError replies the error as json with it's status code.
+func apiError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	ref := logServerError(w, status, err)
+	writeJSON(w, status, &APIError{Error: err.Error(), Reference: ref})
+}
+
+func siteLicense() *APILicense {
+	s := siteSettings()
+	if s.License == "" {
+		return nil
+	}
+	return &APILicense{Name: s.License, URL: s.LicenseURL}
+}
+
+func toAPIPage(p *Page, rev uint64) *APIPage {
+	return &APIPage{
+		Title:       p.Title,
+		Revision:    rev,
+		Body:        string(p.Body),
+		Created:     p.Created,
+		Author:      p.Author,
+		Summary:     p.Summary,
+		Attribution: p.Attribution,
+		Provenance:  p.Provenance,
+		License:     siteLicense(),
+	}
+}
+
+func apiPageHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/api/v1/pages/")
+	if title == "" {
+		apiError(w, newError(ErrNotFound, "page title is missing"))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		// a page which has the name like "Page/diff" cannot be get by the api,
+		// but there is the html page for them.
+		if t := strings.TrimSuffix(title, "/revisions"); t != title && t != "" {
+			apiRevisions(w, r, t)
+			return
+		}
+		if t := strings.TrimSuffix(title, "/diff"); t != title && t != "" {
+			apiDiff(w, r, t)
+			return
+		}
+		apiGetPage(w, r, title)
+	case "PUT":
+		apiPutPage(w, r, title)
+	case "POST":
+		if t := strings.TrimSuffix(title, "/append"); t != title && t != "" {
+			apiAppendPage(w, r, t)
+			return
+		}
+		apiError(w, newError(ErrNotFound, "unknown api: %s", r.URL.Path))
+	case "DELETE":
+		apiDeletePage(w, r, title)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, &APIError{Error: "method not allowed"})
+	}
+}
+
+func apiGetPage(w http.ResponseWriter, r *http.Request, title string) {
+	var id uint64
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		var err error
+		id, err = strconv.ParseUint(rev, 10, 64)
+		if err != nil || id == 0 {
+			apiError(w, newError(ErrInvalid, "invalid revision: %s", rev))
+			return
+		}
+	}
+	p, rev, err := loadRevision(r.Context(), title, id)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	ap := toAPIPage(p, rev)
+	reading := p.Reading()
+	ap.Words, ap.ReadingMinutes = reading.Words, reading.Minutes
+	writeJSON(w, http.StatusOK, ap)
+}
+
+// apiUser returns the user of the api token.
+// When the request doesn't have a valid token, it writes an error and returns nil.
+func apiUser(w http.ResponseWriter, r *http.Request) *User {
+	u, ok := tokenUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="whisky"`)
+		writeJSON(w, http.StatusUnauthorized, &APIError{Error: "api token is needed"})
+		return nil
+	}
+	if u == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="whisky", error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, &APIError{Error: "invalid api token"})
+		return nil
+	}
+	return u
+}
+
+// apiEditor returns the user of the api token who can edit the page.
+// When the user cannot, it writes an error and returns nil.
+func apiEditor(w http.ResponseWriter, r *http.Request, title string) *User {
+	u := apiUser(w, r)
+	if u == nil {
+		return nil
+	}
+	ok, err := canEdit(r, title)
+	if err != nil {
+		apiError(w, err)
+		return nil
+	}
+	if !ok {
+		apiError(w, errProtected)
+		return nil
+	}
+	return u
+}
+
+// readAPIBody reads a request body, which has a page at most.
+func readAPIBody(r *http.Request) ([]byte, error) {
+	return readBody(r, maxPageSize+maxFormOverhead)
+}
+
+// readBody reads a request body up to max bytes.
+func readBody(r *http.Request, max int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(max)+1))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, newError(ErrTooLarge, "request body should be smaller than %s", formatSize(max))
+		}
+		return nil, newError(ErrInvalid, "%v", err)
+	}
+	if len(data) > max {
+		return nil, newError(ErrTooLarge, "request body should be smaller than %s", formatSize(max))
+	}
+	return data, nil
+}
+
+func apiPutPage(w http.ResponseWriter, r *http.Request, title string) {
+	u := apiEditor(w, r, title)
+	if u == nil {
+		return
+	}
+	data, err := readAPIBody(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	edit := &APIEdit{}
+	err = json.Unmarshal(data, edit)
+	if err != nil {
+		apiError(w, newError(ErrInvalid, "invalid json: %v", err))
+		return
+	}
+	_, prevErr := loadPage(r.Context(), title)
+	if prevErr != nil && !errors.Is(prevErr, ErrNotFound) {
+		apiError(w, prevErr)
+		return
+	}
+	p := &Page{
+		Title:       title,
+		Body:        []byte(strings.Replace(edit.Body, "\r\n", "\n", -1)),
+		Created:     time.Now(),
+		Author:      u.Name,
+		Summary:     strings.TrimSpace(edit.Summary),
+		Attribution: strings.TrimSpace(edit.Attribution),
+	}
+	apiSubmitEdit(w, r, p, prevErr != nil)
+}
+
+// APIAppend is a request body for appending to a page.
+type APIAppend struct {
+	Text    string `json:"text"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// apiAppendPage appends a snippet to the page as a new revision.
+// It is for bots like ci or monitoring which leave logs in the wiki.
+// The page is created when it does not exist.
+//
+// The request body is json of APIAppend, or the text itself if it is not json.
+func apiAppendPage(w http.ResponseWriter, r *http.Request, title string) {
+	u := apiEditor(w, r, title)
+	if u == nil {
+		return
+	}
+	data, err := readAPIBody(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	app := &APIAppend{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err = json.Unmarshal(data, app)
+		if err != nil {
+			apiError(w, newError(ErrInvalid, "invalid json: %v", err))
+			return
+		}
+	} else {
+		app.Text = string(data)
+	}
+	text := strings.Trim(strings.Replace(app.Text, "\r\n", "\n", -1), "\n")
+	if strings.TrimSpace(text) == "" {
+		apiError(w, newError(ErrInvalid, "nothing to append"))
+		return
+	}
+	// bots append to the same page at once. the snippet is appended to the latest revision,
+	// and appended again to the new one when another is saved in the meantime.
+	for i := 0; ; i++ {
+		prev, base, err := loadRevision(r.Context(), title, 0)
+		isNew := errors.Is(err, ErrNotFound)
+		if err != nil && !isNew {
+			apiError(w, err)
+			return
+		}
+		p := &Page{Title: title, Created: time.Now(), Author: u.Name, Summary: strings.TrimSpace(app.Summary)}
+		if isNew {
+			p.Body = []byte(text + "\n")
+		} else {
+			body := strings.TrimRight(string(prev.Body), "\n")
+			if body != "" {
+				body += "\n\n"
+			}
+			p.Body = []byte(body + text + "\n")
+			p.Attribution = prev.Attribution
+		}
+		queued, err := submitEditOn(r.Context(), u, p, base)
+		if errors.Is(err, ErrConflict) && i < maxAppendRetries && r.Context().Err() == nil {
+			continue
+		}
+		if err != nil {
+			apiError(w, err)
+			return
+		}
+		apiReplyEdit(w, r, p, queued, isNew)
+		return
+	}
+}
+
+// maxAppendRetries is how many times an append is tried again on a page changed while appending.
+// only one of the appends at once is saved in each try, so it's as many as bots appending at once.
+const maxAppendRetries = 100
+
+// apiSubmitEdit submits the edit and replies the result.
+func apiSubmitEdit(w http.ResponseWriter, r *http.Request, p *Page, isNew bool) {
+	queued, err := submitEdit(r.Context(), currentUser(r), p)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	apiReplyEdit(w, r, p, queued, isNew)
+}
+
+// apiReplyEdit replies the submitted edit, the saved revision or the edit waiting for review.
+func apiReplyEdit(w http.ResponseWriter, r *http.Request, p *Page, queued, isNew bool) {
+	if queued {
+		writeJSON(w, http.StatusAccepted, toAPIPage(p, 0))
+		return
+	}
+	p, rev, err := loadRevision(r.Context(), p.Title, 0)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if isNew {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, toAPIPage(p, rev))
+}
+
+func apiDeletePage(w http.ResponseWriter, r *http.Request, title string) {
+	u := apiUser(w, r)
+	if u == nil {
+		return
+	}
+	if !u.Admin {
+		apiError(w, newError(ErrForbidden, "only admins can delete pages"))
+		return
+	}
+	err := deletePage(r.Context(), title)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIRevisions is a page of the history, from the latest revision.
+type APIRevisions struct {
+	Title     string     `json:"title"`
+	Revisions []Revision `json:"revisions"`
+	// Next is the revision number to get the next page with ?from=.
+	// It is 0 when there are no more revisions.
+	Next int `json:"next,omitempty"`
+}
+
+const maxAPIRevisions = 100
+
+func apiRevisions(w http.ResponseWriter, r *http.Request, title string) {
+	q := r.URL.Query()
+	from := -1
+	if s := q.Get("from"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			apiError(w, newError(ErrInvalid, "invalid revision: %s", s))
+			return
+		}
+		from = n
+	}
+	limit := 20
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			apiError(w, newError(ErrInvalid, "invalid limit: %s", s))
+			return
+		}
+		limit = n
+	}
+	if limit > maxAPIRevisions {
+		limit = maxAPIRevisions
+	}
+	// get one more to know there is the next page.
+	h, err := loadHistory(r.Context(), title, from, limit+1)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	revs := &APIRevisions{Title: title, Revisions: h.Revs}
+	if len(h.Revs) > limit {
+		revs.Revisions = h.Revs[:limit]
+		revs.Next = h.Revs[limit].Num
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+func apiDiff(w http.ResponseWriter, r *http.Request, title string) {
+	from, to, err := parseRevs(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	d, err := diffRevisions(r.Context(), title, from, to)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assets are static files (css, js, images) served under /static/.
+//
+// at startup, every asset gets a fingerprinted name which includes hash of
+// it's content. (ex. whisky.css -> whisky.1a2b3c4d5e6f.css)
+// templates refer to an asset with the asset helper like {{asset "whisky.css"}}
+// which returns the fingerprinted url, so browsers can cache them forever
+// and still get the new one after an upgrade.
+
+// assetDir is the directory of assets in tmplFS.
+var assetDir = "tmpl/static"
+
+type Asset struct {
+	Name    string
+	URL     string
+	Data    []byte
+	ModTime time.Time
+}
+
+var (
+	// assetsMu guards the maps, which are replaced when assets are loaded again.
+	assetsMu sync.RWMutex
+	// assets by it's original name.
+	assets = make(map[string]*Asset)
+	// assets by it's fingerprinted name.
+	fingerprinted = make(map[string]*Asset)
+)
+
+func loadAssets() error {
+	byName := make(map[string]*Asset)
+	byFingerprint := make(map[string]*Asset)
+	fsys := tmplFS()
+	err := fs.WalkDir(fsys, assetDir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, fpath)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		modTime := fi.ModTime()
+		if modTime.IsZero() {
+			// built-in ones are as new as the binary.
+			modTime = startTime
+		}
+		name := strings.TrimPrefix(fpath, assetDir+"/")
+		sum := sha256.Sum256(data)
+		ext := path.Ext(name)
+		fp := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+		a := &Asset{Name: name, URL: "/static/" + fp, Data: data, ModTime: modTime}
+		byName[name] = a
+		byFingerprint[fp] = a
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	// pages rendered before the reload still refer the old ones.
+	for fp, a := range fingerprinted {
+		if _, ok := byFingerprint[fp]; !ok {
+			byFingerprint[fp] = a
+		}
+	}
+	assets, fingerprinted = byName, byFingerprint
+	return nil
+}
+
+// findAsset finds the asset by it's original name.
+// in dev mode, it's read again to get the change.
+func findAsset(name string) (*Asset, bool) {
+	if !devMode {
+		assetsMu.RLock()
+		defer assetsMu.RUnlock()
+		a, ok := assets[name]
+		return a, ok
+	}
+	fsys := tmplFS()
+	fpath := assetDir + "/" + name
+	fi, err := fs.Stat(fsys, fpath)
+	if err != nil || fi.IsDir() {
+		return nil, false
+	}
+	data, err := fs.ReadFile(fsys, fpath)
+	if err != nil {
+		return nil, false
+	}
+	return &Asset{Name: name, URL: "/static/" + name, Data: data, ModTime: fi.ModTime()}, true
+}
+
+// assetURL returns url of the asset for templates.
+func assetURL(name string) string {
+	a, ok := findAsset(name)
+	if !ok {
+		// let it 404, rather than break the whole page.
+		return basePath + "/static/" + name
+	}
+	return basePath + a.URL
+}
+
+// staticAsset finds the asset of the name under /static/, which is fingerprinted or not.
+// it returns nil when it doesn't exist.
+func staticAsset(name string) (a *Asset, fingerprint bool) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	if a, ok := fingerprinted[name]; ok {
+		return a, true
+	}
+	return assets[name], false
+}
+
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	if devMode {
+		a, ok := findAsset(name)
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, a.Name, a.ModTime, bytes.NewReader(a.Data))
+		return
+	}
+	a, fp := staticAsset(name)
+	if a != nil && fp {
+		// the url changes when the content changes.
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeContent(w, r, a.Name, a.ModTime, bytes.NewReader(a.Data))
+		return
+	}
+	if a != nil {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, a.Name, a.ModTime, bytes.NewReader(a.Data))
+		return
+	}
+	notFound(w, r)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// attachments are files of a page, like images or documents.
+// attachments bucket has a bucket per page, which has attachments by their names.
+//
+// an attachment is served at /attachments/<title>/<name>,
+// so pages can refer it like ![diagram](/attachments/Page/diagram.png)
+//
+// the content of an attachment is kept in chunks in attachment-chunks bucket,
+// which has a bucket per page too, so a range of a video is served
+// without reading (and decrypting) the whole file.
+// chunks of an attachment are keyed by it's ID and their index,
+// and the ID changes when the attachment is replaced,
+// so a response being sent doesn't mix chunks of the old and new one.
+
+type Attachment struct {
+	Name        string
+	ContentType string
+	// Data is the content. It's only in the records saved by an older whisky,
+	// until they are chunked when the wiki starts.
+	// loadAttachment reads the chunks into it.
+	Data     []byte `json:",omitempty"`
+	Uploaded time.Time
+	By       string
+	// ID is of the chunks of the content. It's 0 for records with the content in them.
+	ID uint64 `json:",omitempty"`
+	// Length and Sum (sha256) are of the content.
+	Length int64  `json:",omitempty"`
+	Sum    string `json:",omitempty"`
+}
+
+// AttachmentChunk is a part of the content of an attachment.
+type AttachmentChunk struct {
+	Data []byte
+}
+
+const attachmentChunkSize = 256 << 10
+
+func (a *Attachment) Size() int {
+	if a.ID == 0 {
+		return len(a.Data)
+	}
+	return int(a.Length)
+}
+
+// ETag returns the strong ETag of the content.
+func (a *Attachment) ETag() string {
+	sum := a.Sum
+	if a.ID == 0 {
+		h := sha256.Sum256(a.Data)
+		sum = hex.EncodeToString(h[:])
+	}
+	return `"` + sum + `"`
+}
+
+// URL returns where the attachment of the page is served.
+func (a *Attachment) URL(title string) string {
+	return attachmentURL(title, a.Name)
+}
+
+func attachmentURL(title, name string) string {
+	return "/attachments/" + title + "/" + url.PathEscape(name)
+}
+
+const maxAttachmentSize = 10 << 20
+
+func checkAttachmentName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") || len(name) > 255 {
+		return newError(ErrInvalid, "invalid attachment name: %q", name)
+	}
+	return nil
+}
+
+func saveAttachment(ctx context.Context, title string, a *Attachment) error {
+	if err := checkAttachmentName(a.Name); err != nil {
+		return err
+	}
+	if len(a.Data) > maxAttachmentSize {
+		return newError(ErrTooLarge, "attachment should be smaller than %dMB", maxAttachmentSize>>20)
+	}
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		return putAttachment(tx, title, a)
+	})
+}
+
+// putAttachment saves the attachment with it's content in chunks,
+// and deletes chunks of the one it replaces.
+func putAttachment(tx *bolt.Tx, title string, a *Attachment) error {
+	b, err := tx.Bucket([]byte("attachments")).CreateBucketIfNotExists([]byte(title))
+	if err != nil {
+		return fmt.Errorf("could not create bucket: %s", err)
+	}
+	chunks, err := tx.Bucket([]byte("attachment-chunks")).CreateBucketIfNotExists([]byte(title))
+	if err != nil {
+		return fmt.Errorf("could not create bucket: %s", err)
+	}
+	if bs := b.Get([]byte(a.Name)); bs != nil {
+		old := &Attachment{}
+		// a broken record is replaced. it's chunks are left for check -repair.
+		if decodeRecord(bs, old) == nil {
+			if err := deleteChunks(chunks, old.ID); err != nil {
+				return err
+			}
+		}
+	}
+	id, _ := chunks.NextSequence()
+	for i := 0; i*attachmentChunkSize < len(a.Data); i++ {
+		end := min((i+1)*attachmentChunkSize, len(a.Data))
+		bs, err := encodeAttachment(&AttachmentChunk{Data: a.Data[i*attachmentChunkSize : end]})
+		if err != nil {
+			return err
+		}
+		err = chunks.Put(chunkKey(id, i), bs)
+		if err != nil {
+			return err
+		}
+	}
+	sum := sha256.Sum256(a.Data)
+	meta := *a
+	meta.Data = nil
+	meta.ID = id
+	meta.Length = int64(len(a.Data))
+	meta.Sum = hex.EncodeToString(sum[:])
+	bs, err := encodeAttachment(&meta)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(a.Name), bs)
+}
+
+func chunkKey(id uint64, i int) []byte {
+	return append(byteID(id), byteID(uint64(i))...)
+}
+
+// deleteChunks deletes chunks of the attachment ID in the chunks bucket of a page.
+func deleteChunks(chunks *bolt.Bucket, id uint64) error {
+	if id == 0 {
+		return nil
+	}
+	prefix := byteID(id)
+	var keys [][]byte
+	cur := chunks.Cursor()
+	for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := chunks.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAttachment returns the attachment without it's content.
+func getAttachment(tx *bolt.Tx, title, name string) (*Attachment, error) {
+	b := tx.Bucket([]byte("attachments")).Bucket([]byte(title))
+	if b == nil {
+		return nil, newError(ErrNotFound, "attachment not exists")
+	}
+	bs := b.Get([]byte(name))
+	if bs == nil {
+		return nil, newError(ErrNotFound, "attachment not exists")
+	}
+	a := &Attachment{}
+	if err := fromBytes(bs, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// getChunk returns the i-th chunk of the content of the attachment.
+func getChunk(tx *bolt.Tx, title string, a *Attachment, i int) ([]byte, error) {
+	var bs []byte
+	if b := tx.Bucket([]byte("attachment-chunks")).Bucket([]byte(title)); b != nil {
+		bs = b.Get(chunkKey(a.ID, i))
+	}
+	if bs == nil {
+		return nil, newError(ErrNotFound, "chunk %d of attachment %s not exists. it could be replaced", i, a.Name)
+	}
+	c := &AttachmentChunk{}
+	if err := fromBytes(bs, c); err != nil {
+		return nil, err
+	}
+	return c.Data, nil
+}
+
+// loadAttachment returns the attachment with it's content.
+func loadAttachment(ctx context.Context, title, name string) (*Attachment, error) {
+	var a *Attachment
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		var err error
+		a, err = getAttachment(tx, title, name)
+		if err != nil || a.ID == 0 {
+			return err
+		}
+		a.Data = make([]byte, 0, a.Length)
+		for i := 0; int64(len(a.Data)) < a.Length; i++ {
+			data, err := getChunk(tx, title, a, i)
+			if err != nil {
+				return err
+			}
+			a.Data = append(a.Data, data...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadAttachmentInfo returns the attachment without it's content,
+// which is read with an attachmentReader.
+func loadAttachmentInfo(ctx context.Context, title, name string) (*Attachment, error) {
+	var a *Attachment
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		var err error
+		a, err = getAttachment(tx, title, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// attachmentReader reads the content of an attachment a chunk at a time.
+// each chunk is read in it's own transaction, not to keep one open
+// while sending a large file to a slow client.
+type attachmentReader struct {
+	ctx   context.Context
+	title string
+	a     *Attachment
+	off   int64
+	// chunk is the last chunk read, the index-th one.
+	chunk []byte
+	index int
+}
+
+func newAttachmentReader(ctx context.Context, title string, a *Attachment) io.ReadSeeker {
+	if a.ID == 0 {
+		return bytes.NewReader(a.Data)
+	}
+	return &attachmentReader{ctx: ctx, title: title, a: a, index: -1}
+}
+
+func (r *attachmentReader) Read(p []byte) (int, error) {
+	if r.off >= r.a.Length {
+		return 0, io.EOF
+	}
+	i := int(r.off / attachmentChunkSize)
+	if i != r.index {
+		err := viewTx(r.ctx, func(tx *bolt.Tx) error {
+			var err error
+			r.chunk, err = getChunk(tx, r.title, r.a, i)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.index = i
+	}
+	n := copy(p, r.chunk[r.off-int64(i)*attachmentChunkSize:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *attachmentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.a.Length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// listAttachments returns attachments of the page sorted by their names.
+func listAttachments(ctx context.Context, title string) ([]*Attachment, error) {
+	as := []*Attachment{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("attachments")).Bucket([]byte(title))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			a := &Attachment{}
+			if err := fromBytes(v, a); err != nil {
+				return err
+			}
+			as = append(as, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Name < as[j].Name })
+	return as, nil
+}
+
+// attachmentNames returns names of attachments of the page in order,
+// without reading them.
+func attachmentNames(ctx context.Context, title string) ([]string, error) {
+	names := []string{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("attachments")).Bucket([]byte(title))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// attachmentTitles returns titles of pages those have attachments.
+func attachmentTitles(ctx context.Context) ([]string, error) {
+	titles := []string{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("attachments")).ForEach(func(k, v []byte) error {
+			if v == nil {
+				titles = append(titles, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+func deleteAttachment(ctx context.Context, title, name string) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("attachments")).Bucket([]byte(title))
+		if b == nil || b.Get([]byte(name)) == nil {
+			return newError(ErrNotFound, "attachment not exists")
+		}
+		a := &Attachment{}
+		chunks := tx.Bucket([]byte("attachment-chunks")).Bucket([]byte(title))
+		if decodeRecord(b.Get([]byte(name)), a) == nil && chunks != nil {
+			if err := deleteChunks(chunks, a.ID); err != nil {
+				return err
+			}
+		}
+		err := b.Delete([]byte(name))
+		if err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			return deleteAttachmentBuckets(tx, title)
+		}
+		return nil
+	})
+}
+
+// deleteAttachmentBuckets deletes attachments of the page.
+func deleteAttachmentBuckets(tx *bolt.Tx, title string) error {
+	for _, name := range []string{"attachments", "attachment-chunks"} {
+		err := tx.Bucket([]byte(name)).DeleteBucket([]byte(title))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkAttachments moves the content of attachments saved by an older whisky into chunks.
+// it saves an attachment at a time, as they could be large.
+func chunkAttachments() error {
+	var done bool
+	db.View(func(tx *bolt.Tx) error {
+		done = tx.Bucket([]byte("settings")).Get([]byte("attachments-chunked")) != nil
+		return nil
+	})
+	if done {
+		return nil
+	}
+	ctx := context.Background()
+	titles, err := attachmentTitles(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, t := range titles {
+		names, err := attachmentNames(ctx, t)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			err := db.Update(func(tx *bolt.Tx) error {
+				a, err := getAttachment(tx, t, name)
+				if err != nil {
+					// it's reported by check.
+					slog.Error("could not chunk the attachment", "page", t, "attachment", name, "err", err)
+					return nil
+				}
+				if a.ID != 0 {
+					return nil
+				}
+				n++
+				return putAttachment(tx, t, a)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if n != 0 {
+		slog.Info("moved attachments into chunks", "attachments", n)
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("settings")).Put([]byte("attachments-chunked"), []byte("1"))
+	})
+}
+
+// attachmentHandler serves an attachment at /attachments/<title>/<name>.
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/attachments/")
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		notFound(w, r)
+		return
+	}
+	title := p[:i]
+	a, err := loadAttachmentInfo(r.Context(), title, p[i+1:])
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	// ServeContent checks If-None-Match and If-Range with it,
+	// so a download or a video is resumed only when it's the same file.
+	w.Header().Set("ETag", a.ETag())
+	// don't let browsers run uploaded html or scripts as a part of the wiki.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	// a large file or a slow client takes longer than the timeouts of requests.
+	r, cancel := withoutTimeouts(w, r)
+	defer cancel()
+	// it serves ranges of the content too.
+	http.ServeContent(w, r, a.Name, a.Uploaded, newAttachmentReader(r.Context(), title, a))
+}
+
+type AttachPage struct {
+	Title       string
+	Attachments []*Attachment
+	CanEdit     bool
+}
+
+// attachHandler shows attachments of the page, and uploads or deletes them.
+func attachHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method == "POST" {
+		if !checkEditable(w, r, title) {
+			return
+		}
+		var err error
+		if name := r.URL.Query().Get("delete"); name != "" {
+			err = deleteAttachment(r.Context(), title, name)
+		} else {
+			err = uploadAttachment(w, r, title)
+		}
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/attach/"+title, http.StatusFound)
+		return
+	}
+	as, err := listAttachments(r.Context(), title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	ok, err := canEdit(r, title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "attach", &AttachPage{Title: title, Attachments: as, CanEdit: ok})
+}
+
+func uploadAttachment(w http.ResponseWriter, r *http.Request, title string) error {
+	// some more bytes for the other fields of the form.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	f, h, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		return newError(ErrInvalid, "no file to upload")
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newError(ErrTooLarge, "attachment should be smaller than %dMB", maxAttachmentSize>>20)
+	}
+	if err != nil {
+		return newError(ErrInvalid, "%v", err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = path.Base(strings.Replace(h.Filename, "\\", "/", -1))
+	}
+	a := &Attachment{
+		Name:        name,
+		ContentType: attachmentType(name, data),
+		Data:        data,
+		Uploaded:    time.Now(),
+		By:          authorName(r),
+	}
+	return saveAttachment(r.Context(), title, a)
+}
+
+// attachmentType guesses content type of the attachment from it's name and data.
+func attachmentType(name string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
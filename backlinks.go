@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// "backlinks" bucket has a bucket per page, which has titles of the pages linking to it as keys.
+// pages which are not created yet have backlinks too.
+// it's updated with the titles index, from the links of the latest revisions.
+
+// linkTarget returns the title of the wiki page the link points to.
+// It returns false if the link is not a link to a wiki page.
+func linkTarget(from string, u *url.URL) (string, bool) {
+	if u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+	switch {
+	case u.Path == "":
+		return from, true
+	case strings.HasPrefix(u.Path, "/view/"):
+		return strings.TrimPrefix(u.Path, "/view/"), true
+	case !strings.HasPrefix(u.Path, "/"):
+		// relative to /view/<from>
+		dir := ""
+		if i := strings.LastIndex(from, "/"); i != -1 {
+			dir = from[:i+1]
+		}
+		return dir + u.Path, true
+	}
+	return "", false
+}
+
+// pageLinkTargets returns titles of the other pages the page links to, sorted.
+func pageLinkTargets(title string, body []byte) []string {
+	seen := map[string]bool{}
+	var targets []string
+	for _, link := range pageLinks(body) {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		t, ok := linkTarget(title, u)
+		if !ok || t == "" || t == title || seen[t] {
+			continue
+		}
+		seen[t] = true
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// updateBacklinks moves the backlinks of the page from the old targets to the new ones.
+// links are sorted.
+func updateBacklinks(tx *bolt.Tx, title string, old, links []string) error {
+	backlinks := tx.Bucket([]byte("backlinks"))
+	from := []byte(title)
+	for _, t := range old {
+		i := sort.SearchStrings(links, t)
+		if i < len(links) && links[i] == t {
+			continue
+		}
+		if b := backlinks.Bucket([]byte(t)); b != nil {
+			if err := b.Delete(from); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range links {
+		b, err := backlinks.CreateBucketIfNotExists([]byte(t))
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		if err := b.Put(from, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backlinks returns titles of the pages linking to the page.
+func (boltStore) Backlinks(ctx context.Context, title string) ([]string, error) {
+	titles := []string{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("backlinks")).Bucket([]byte(title))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			titles = append(titles, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// Head returns the head of the page in the titles index.
+func (boltStore) Head(ctx context.Context, title string) (*PageHead, error) {
+	h := &PageHead{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("titles")).Get([]byte(title))
+		if bs == nil {
+			return errPageNotExists
+		}
+		return fromBytes(bs, h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// loadPageHead returns the head of the page.
+// Other stores than bolt don't have the index, so it's made from the first and last revisions.
+func loadPageHead(ctx context.Context, title string) (*PageHead, error) {
+	if s, ok := store.(interface {
+		Head(ctx context.Context, title string) (*PageHead, error)
+	}); ok {
+		return s.Head(ctx, title)
+	}
+	p, rev, err := loadRevision(ctx, title, 0)
+	if err != nil {
+		return nil, err
+	}
+	h := newPageHead(p, rev)
+	h.Created = p.Created
+	if rev > 1 {
+		first, err := loadPageRev(ctx, title, 1)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			h.Created = first.Created
+		}
+	}
+	return h, nil
+}
+
+// PageMeta is the info of a page shown under it.
+type PageMeta struct {
+	*PageHead
+	// Backlinks is the number of pages linking to the page.
+	// It's -1 with other stores than bolt, as they don't have the index
+	// and every page should be read for it.
+	Backlinks int
+	Watchers  int
+}
+
+// loadPageMeta returns the info of the page.
+func loadPageMeta(ctx context.Context, title string) (*PageMeta, error) {
+	h, err := loadPageHead(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	m := &PageMeta{PageHead: h, Backlinks: -1}
+	if s, ok := store.(interface {
+		Backlinks(ctx context.Context, title string) ([]string, error)
+	}); ok {
+		backlinks, err := s.Backlinks(ctx, title)
+		if err != nil {
+			return nil, err
+		}
+		m.Backlinks = len(backlinks)
+	}
+	ws, err := watchers(title)
+	if err != nil {
+		return nil, err
+	}
+	m.Watchers = len(ws)
+	return m, nil
+}
+
+// rebuildBacklinks makes the backlinks index again from links in the titles index.
+func rebuildBacklinks(tx *bolt.Tx) error {
+	err := tx.DeleteBucket([]byte("backlinks"))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	if _, err := tx.CreateBucket([]byte("backlinks")); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("titles")).ForEach(func(k, v []byte) error {
+		h := &PageHead{}
+		if err := fromBytes(v, h); err != nil {
+			// it's rebuilt with the titles.
+			return nil
+		}
+		return updateBacklinks(tx, string(k), nil, h.Links)
+	})
+}
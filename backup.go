@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// a backup is a copy of whisky.db taken in a read transaction,
+// so it is consistent while the wiki keeps running.
+// pages kept outside of the database (git, fs or postgres storage) are not in it.
+
+func backupFilename() string {
+	return "whisky-" + time.Now().Format("20060102-150405") + ".db"
+}
+
+// writeBackup writes a snapshot of the database as the response.
+func writeBackup(w http.ResponseWriter, r *http.Request) {
+	// a large database takes longer than the timeouts of requests.
+	_, cancel := withoutTimeouts(w, r)
+	defer cancel()
+	err := db.View(func(tx *bolt.Tx) error {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+backupFilename()+`"`)
+		w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		// the response is already started, the client will get a truncated file.
+		slog.Error("could not backup", "err", err)
+	}
+}
+
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	writeBackup(w, r)
+}
+
+func apiBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		apiError(w, newError(ErrNotFound, "unknown api: %s %s", r.Method, r.URL.Path))
+		return
+	}
+	u := apiUser(w, r)
+	if u == nil {
+		return
+	}
+	if !u.Admin {
+		apiError(w, newError(ErrForbidden, "only admins can backup the wiki"))
+		return
+	}
+	writeBackup(w, r)
+}
+
+// backupCommand downloads a backup of the running wiki.
+func backupCommand(c *apiClient, fs *flag.FlagSet, args []string) (err error) {
+	out := fs.String("o", "", "file to write the backup. whisky-<time>.db if empty")
+	parseArgs(fs, args, "[flags]", -1)
+	if *out == "" {
+		*out = backupFilename()
+	}
+	resp, err := c.send("GET", "/api/v1/backup", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// written to a temporary file first, not to leave a broken backup.
+	f, err := os.CreateTemp(filepath.Dir(*out), ".whisky-backup-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("backup is truncated: got %d of %d bytes", n, resp.ContentLength)
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), *out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d bytes\n", *out, n)
+	return nil
+}
+
+// compactCommand rewrites the database into a new file, as a bolt file never shrinks
+// by itself. It reclaims space of deleted pages, old deliveries and such.
+func compactCommand(fs *flag.FlagSet, args []string) (err error) {
+	parseArgs(fs, args, "", -1)
+	before, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	tmp := dbPath + ".compact"
+	defer func() {
+		if err != nil {
+			db.Close()
+			os.Remove(tmp)
+		}
+	}()
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+	// commits every 64MB, not to hold the whole database in memory.
+	err = bolt.Compact(dst, db, 64<<20)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, dbPath)
+	if err != nil {
+		return err
+	}
+	after, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d -> %d bytes\n", dbPath, before.Size(), after.Size())
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// the wiki can be served under a path of another site, like https://example.com/wiki/,
+// by a reverse proxy. -base-url tells where it is.
+//
+// handlers see paths without the base path, whether the proxy strips it or not,
+// and links in templates and pages, redirects and cookies get the base path back.
+// links in raw html of pages are not changed.
+
+var (
+	// basePath is the path the wiki is served under, without the trailing slash. ex) /wiki
+	basePath string
+	// baseSite is the scheme and the host of -base-url, when it has. ex) https://example.com
+	baseSite string
+)
+
+// setBaseURL sets basePath and baseSite by the base url.
+// it can be a url (https://example.com/wiki/) or a path (/wiki).
+func setBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "" || u.Host != "" {
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid base url: %s", s)
+		}
+		baseSite = u.Scheme + "://" + u.Host
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	if p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("invalid base url: %s. the path should be absolute", s)
+	}
+	basePath = p
+	return nil
+}
+
+// withBasePath serves requests to h with the base path stripped,
+// and puts it back to redirects of h.
+func withBasePath(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if p := strings.TrimPrefix(r.URL.Path, basePath); p != r.URL.Path && strings.HasPrefix(p, "/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = p
+			r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, basePath)
+			r = r2
+		}
+		h.ServeHTTP(&basePathWriter{ResponseWriter: w}, r)
+	})
+}
+
+// basePathWriter adds the base path to the redirect of the response.
+type basePathWriter struct {
+	http.ResponseWriter
+}
+
+func (w *basePathWriter) WriteHeader(status int) {
+	if loc := w.Header().Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		w.Header().Set("Location", basePath+loc)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *basePathWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *basePathWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// prefixLinks adds the base path to links and images of the markdown tree,
+// those point the wiki like /view/Page.
+func prefixLinks(ast *blackfriday.Node) {
+	ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || (n.Type != blackfriday.Link && n.Type != blackfriday.Image) {
+			return blackfriday.GoToNext
+		}
+		dest := string(n.LinkData.Destination)
+		if strings.HasPrefix(dest, "/") && !strings.HasPrefix(dest, "//") {
+			n.LinkData.Destination = []byte(basePath + dest)
+		}
+		return blackfriday.GoToNext
+	})
+}
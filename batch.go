@@ -0,0 +1,93 @@
+package main
+
+import "context"
+
+// importers save thousands of revisions. saving them one by one takes a transaction
+// (and a write of the database) for each, so they are saved in batches instead.
+
+// batchSaver is a page store which can save many revisions at once.
+type batchSaver interface {
+	// SaveBatch adds the revisions in order, and returns their numbers.
+	SaveBatch(ctx context.Context, ps []*Page) ([]uint64, error)
+}
+
+// saveBatch saves the revisions with the store, and adds them to the recent changes.
+// Hooks (webhooks, the mirror, ...) are not called, as they are not for imports.
+func saveBatch(ctx context.Context, ps []*Page) ([]uint64, error) {
+	var revs []uint64
+	if s, ok := store.(batchSaver); ok {
+		var err error
+		revs, err = s.SaveBatch(ctx, ps)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, p := range ps {
+			rev, err := store.Save(ctx, p)
+			if err != nil {
+				return nil, err
+			}
+			revs = append(revs, rev)
+		}
+	}
+	if storeKeepsChanges() {
+		return revs, nil
+	}
+	return revs, addChanges(ctx, ps, revs)
+}
+
+// defaultBatchSize is the number of revisions saved in a batch.
+const defaultBatchSize = 500
+
+// pageBatch collects revisions to save, and saves them when it's full.
+type pageBatch struct {
+	ctx   context.Context
+	size  int
+	pages []*Page
+	// titles are titles of the pages not saved yet.
+	titles map[string]bool
+	// saved is the number of revisions saved.
+	saved int
+	// progress is called after a batch is saved, when it isn't nil.
+	progress func(saved int)
+}
+
+func newPageBatch(ctx context.Context, size int) *pageBatch {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	return &pageBatch{ctx: ctx, size: size, titles: make(map[string]bool)}
+}
+
+// add adds a revision to the batch. the batch is saved when it's full.
+func (b *pageBatch) add(p *Page) error {
+	b.pages = append(b.pages, p)
+	b.titles[p.Title] = true
+	if len(b.pages) < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+// has reports whether the batch has a revision of the page not saved yet.
+func (b *pageBatch) has(title string) bool {
+	return b.titles[title]
+}
+
+// flush saves the revisions in the batch.
+func (b *pageBatch) flush() error {
+	if len(b.pages) == 0 {
+		return nil
+	}
+	_, err := saveBatch(b.ctx, b.pages)
+	if err != nil {
+		return err
+	}
+	b.saved += len(b.pages)
+	b.pages = b.pages[:0]
+	b.titles = make(map[string]bool)
+	if b.progress != nil {
+		b.progress(b.saved)
+	}
+	return nil
+}
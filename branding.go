@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Image is an uploaded image for branding the wiki. (logo, favicon)
+// They are saved in the settings bucket with their name as a key.
+type Image struct {
+	ContentType string
+	Data        []byte
+	Updated     time.Time
+}
+
+const maxImageSize = 1 << 20
+
+func loadImage(name string) (*Image, error) {
+	img := &Image{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("settings")).Get([]byte(name))
+		if bs == nil {
+			return newError(ErrNotFound, "image not exists")
+		}
+		return fromBytes(bs, img)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func saveImage(name string, img *Image) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("settings"))
+		if img == nil {
+			return b.Delete([]byte(name))
+		}
+		return putRecord(b, []byte(name), img)
+	})
+}
+
+// uploadedImage reads an image from the multipart form field.
+// It returns nil image, when the field is empty.
+func uploadedImage(r *http.Request, field string) (*Image, error) {
+	f, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, newError(ErrTooLarge, "%s is too big. it should be smaller than %dKB", field, maxImageSize>>10)
+	}
+	ctype := http.DetectContentType(data)
+	if !strings.HasPrefix(ctype, "image/") {
+		return nil, newError(ErrInvalid, "%s is not an image: %s", field, ctype)
+	}
+	return &Image{ContentType: ctype, Data: data, Updated: time.Now()}, nil
+}
+
+// imageURL returns url of the image (logo, favicon.ico) for templates,
+// which changes with a new image.
+func imageURL(name string) string {
+	return basePath + "/" + name + "?v=" + strconv.FormatInt(siteSettings().ImageVersion, 10)
+}
+
+func makeImageHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		img, err := loadImage(name)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if v := r.URL.Query().Get("v"); v != "" && v == strconv.FormatInt(siteSettings().ImageVersion, 10) {
+			// the url of imageURL changes when an admin uploads a new one.
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			// let browsers check for a new one.
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Set("Content-Type", img.ContentType)
+		http.ServeContent(w, r, name, img.Updated, bytes.NewReader(img.Data))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a revision of a page never changes, so responses made only from a revision
+// (text, raw markdown) are validated by the revision number and it's time.
+// numbers of revisions start from 1 again when a page is deleted and made again,
+// so the time and the title are in the ETag too.
+// rendered views have the user's header and such too, so their ETag is
+// the revision with a hash of the rendered page.
+
+// revisionETag returns the ETag of a response made from the revision.
+func revisionETag(p *Page, rev uint64) string {
+	h := fnv.New64a()
+	h.Write([]byte(p.Title))
+	return `"` + strconv.FormatUint(rev, 10) + "-" + strconv.FormatInt(p.Created.UnixNano(), 36) + "-" + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+// checkRevision is checkModified for a response made only from the revision.
+// browsers should ask again, as the latest revision changes at the same url,
+// and so does an old one when the page is made again.
+func checkRevision(w http.ResponseWriter, r *http.Request, p *Page, rev uint64) bool {
+	w.Header().Set("Cache-Control", "no-cache")
+	return checkModified(w, r, revisionETag(p, rev), p.Created)
+}
+
+// renderedETag returns the weak ETag of a rendered page of the revision.
+func renderedETag(rev uint64, data []byte) string {
+	h := fnv.New64a()
+	h.Write(data)
+	return `W/"` + strconv.FormatUint(rev, 10) + "-" + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+// checkModified sets the validators of the response, and responds 304 Not Modified
+// when the client has the same one. It returns false then, and the response should not be written.
+// modified is ignored when it's zero.
+func checkModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if devMode {
+		// a change of templates doesn't change the validators.
+		w.Header().Set("Cache-Control", "no-store")
+		return true
+	}
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag) {
+			countCache("conditional", true)
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		// If-Modified-Since is ignored with If-None-Match.
+		countCache("conditional", false)
+		return true
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// the header has seconds only.
+		if err == nil && !modified.Truncate(time.Second).After(t) {
+			countCache("conditional", true)
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		countCache("conditional", false)
+	}
+	return true
+}
+
+// etagMatch reports whether one of the ETags in If-None-Match header is the etag.
+// it compares them weakly, as the spec says for If-None-Match.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// recent changes of the wiki are kept in "changes" bucket in the order of saving,
+// so the recent changes page and it's feed don't look into the history of every page.
+// only the last maxChanges changes are kept, and those of a deleted page are removed.
+//
+// with bolt storage, a change is added in the transaction saving the revision.
+//
+// they are encrypted like revisions, as they have summaries of the changes.
+
+const maxChanges = 1000
+
+// Change is a revision in the recent changes.
+type Change struct {
+	Title   string    `json:"title"`
+	Rev     uint64    `json:"rev"`
+	Created time.Time `json:"created"`
+	Author  string    `json:"author"`
+	Summary string    `json:"summary,omitempty"`
+}
+
+type ChangesPage struct {
+	// Title is empty, as it's not a page.
+	Title   string
+	Changes []Change
+}
+
+// changeKeeper is a page store which adds revisions to the recent changes itself.
+// bolt storage adds them in the transactions saving the revisions,
+// so a save is a write of the database, and they are in the order of the revisions.
+type changeKeeper interface {
+	KeepsChanges() bool
+}
+
+// storeKeepsChanges reports whether the store adds revisions to the recent changes.
+func storeKeepsChanges() bool {
+	s, ok := store.(changeKeeper)
+	return ok && s.KeepsChanges()
+}
+
+func newChange(p *Page, rev uint64) *Change {
+	return &Change{Title: p.Title, Rev: rev, Created: p.Created, Author: p.Author, Summary: p.Summary}
+}
+
+// addChange adds the revision of the page to the recent changes.
+func addChange(ctx context.Context, p *Page, rev uint64) error {
+	return addChanges(ctx, []*Page{p}, []uint64{rev})
+}
+
+// addChanges adds the revisions of the pages to the recent changes in a transaction.
+func addChanges(ctx context.Context, ps []*Page, revs []uint64) error {
+	return batchTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("changes"))
+		for i, p := range ps {
+			err := putChange(b, newChange(p, revs[i]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// putChange appends the change, and removes old changes over maxChanges.
+func putChange(b *bolt.Bucket, c *Change) error {
+	bs, err := encodePrivate(c)
+	if err != nil {
+		return err
+	}
+	id, _ := b.NextSequence()
+	err = b.Put(byteID(id), bs)
+	if err != nil {
+		return err
+	}
+	if id <= maxChanges {
+		return nil
+	}
+	oldest := byteID(id - maxChanges)
+	var keys [][]byte
+	cur := b.Cursor()
+	for k, _ := cur.First(); k != nil && bytes.Compare(k, oldest) <= 0; k, _ = cur.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeChanges removes changes of the deleted page.
+// numbers of the page made again start from 1, so they would point wrong revisions.
+func removeChanges(tx *bolt.Tx, title string) error {
+	b := tx.Bucket([]byte("changes"))
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		c := &Change{}
+		if err := fromBytes(v, c); err != nil {
+			return err
+		}
+		if c.Title == title {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadChanges returns at most n recent changes, latest first.
+func loadChanges(ctx context.Context, n int) ([]Change, error) {
+	changes := []Change{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		cur := tx.Bucket([]byte("changes")).Cursor()
+		for k, v := cur.Last(); k != nil && len(changes) < n; k, v = cur.Prev() {
+			c := Change{}
+			if err := fromBytes(v, &c); err != nil {
+				return err
+			}
+			changes = append(changes, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// indexChanges builds the recent changes from the history,
+// for a database written before the index.
+func indexChanges() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		settings := tx.Bucket([]byte("settings"))
+		if settings.Get([]byte("changes-indexed")) != nil {
+			return nil
+		}
+		var changes []*Change
+		hist := tx.Bucket([]byte("history"))
+		err := hist.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			cur := hist.Bucket(k).Cursor()
+			n := 0
+			for id, bs := cur.Last(); id != nil && n < maxChanges; id, bs = cur.Prev() {
+				p := &Page{}
+				if err := decodeRecord(bs, p); err != nil {
+					// it's not worth to stop the wiki for the recent changes.
+					slog.Error("corrupted revision", "page", string(k), "rev", binary.BigEndian.Uint64(id), "err", err)
+					continue
+				}
+				changes = append(changes, &Change{Title: string(k), Rev: binary.BigEndian.Uint64(id), Created: p.Created, Author: p.Author, Summary: p.Summary})
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Created.Before(changes[j].Created)
+		})
+		if len(changes) > maxChanges {
+			changes = changes[len(changes)-maxChanges:]
+		}
+		b := tx.Bucket([]byte("changes"))
+		for _, c := range changes {
+			if err := putChange(b, c); err != nil {
+				return err
+			}
+		}
+		if len(changes) != 0 {
+			slog.Info("indexed recent changes", "changes", len(changes))
+		}
+		return settings.Put([]byte("changes-indexed"), []byte("1"))
+	})
+}
+
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := loadChanges(r.Context(), 100)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "changes", &ChangesPage{Changes: changes})
+}
+
+func changesFeed(changes []Change, site string) *AtomFeed {
+	f := &AtomFeed{
+		ID:    site + "/changes",
+		Title: "recent changes",
+		Links: []AtomLink{
+			{Rel: "self", Href: site + "/changes.atom"},
+			{Rel: "alternate", Href: site + "/changes"},
+		},
+	}
+	for _, c := range changes {
+		rev := strconv.FormatUint(c.Rev, 10)
+		revURL := site + "/view/" + url.PathEscape(c.Title) + "?rev=" + rev
+		f.Entries = append(f.Entries, AtomEntry{
+			ID:      revURL,
+			Title:   c.Title + " (rev " + rev + ")",
+			Updated: c.Created.UTC().Format(time.RFC3339),
+			Author:  AtomAuthor{Name: c.Author},
+			Link:    AtomLink{Href: revURL},
+			Summary: c.Summary,
+		})
+	}
+	// changes are sorted from the latest one.
+	if len(changes) != 0 {
+		f.Updated = f.Entries[0].Updated
+	} else {
+		f.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return f
+}
+
+func changesFeedHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := loadChanges(r.Context(), 50)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(changesFeed(changes, siteURL(r)))
+	if err != nil {
+		httpError(w, r, err)
+	}
+}
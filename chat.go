@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// chat hooks post a short message to a slack or discord channel,
+// when a page they watch is saved.
+//
+// they are incoming webhooks of slack or discord. unlike webhooks,
+// the message is for people, and it is sent once without retries.
+
+type ChatHook struct {
+	ID uint64
+	// Kind is slack or discord.
+	Kind string
+	URL  string
+	// Pages are titles or namespaces (ending with /) of pages to notify.
+	// All pages are notified when it is empty.
+	Pages   []string
+	By      string
+	Created time.Time
+}
+
+// Matches reports whether the hook should be notified of the page.
+func (h *ChatHook) Matches(title string) bool {
+	if len(h.Pages) == 0 {
+		return true
+	}
+	for _, p := range h.Pages {
+		if p == title || (strings.HasSuffix(p, "/") && strings.HasPrefix(title, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadChatHooks() ([]*ChatHook, error) {
+	hooks := []*ChatHook{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("chathooks")).ForEach(func(k, v []byte) error {
+			h := &ChatHook{}
+			if err := fromBytes(v, h); err != nil {
+				return err
+			}
+			hooks = append(hooks, h)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func addChatHook(h *ChatHook) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("chathooks"))
+		h.ID, _ = b.NextSequence()
+		return putRecord(b, byteID(h.ID), h)
+	})
+}
+
+func removeChatHook(id uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("chathooks"))
+		if b.Get(byteID(id)) == nil {
+			return newError(ErrNotFound, "chat hook not exists")
+		}
+		return b.Delete(byteID(id))
+	})
+}
+
+// chatMessage returns the json body of the message for the kind of chat.
+// the page is linked when the site url is set in settings.
+func chatMessage(kind string, p *Page, rev uint64) ([]byte, error) {
+	site := strings.TrimSuffix(siteSettings().URL, "/")
+	pageURL := site + "/view/" + url.PathEscape(p.Title)
+	diffURL := site + "/diff/" + url.PathEscape(p.Title) + "?to=" + strconv.FormatUint(rev, 10)
+	summary := ""
+	if p.Summary != "" {
+		summary = ": " + p.Summary
+	}
+	switch kind {
+	case "slack":
+		// slack escapes only these in messages.
+		esc := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+		title := esc.Replace(p.Title)
+		if site != "" {
+			title = "<" + pageURL + "|" + title + "> (<" + diffURL + "|diff>)"
+		}
+		return json.Marshal(map[string]string{"text": fmt.Sprintf("%s edited by %s%s", title, esc.Replace(p.Author), esc.Replace(summary))})
+	case "discord":
+		title := "**" + p.Title + "**"
+		if site != "" {
+			title = "**[" + p.Title + "](<" + pageURL + ">)** ([diff](<" + diffURL + ">))"
+		}
+		return json.Marshal(map[string]interface{}{
+			"content": fmt.Sprintf("%s edited by %s%s", title, p.Author, summary),
+			// don't ping anyone by names in the summary.
+			"allowed_mentions": map[string][]string{"parse": {}},
+		})
+	}
+	return nil, fmt.Errorf("unknown chat: %s", kind)
+}
+
+// notifyChats posts the saved revision to chat hooks those watch the page.
+func notifyChats(p *Page, rev uint64) {
+	var hooks []*ChatHook
+	err := usingDB(func() (err error) {
+		hooks, err = loadChatHooks()
+		return err
+	})
+	if err != nil {
+		slog.Error("could not load chat hooks", "err", err)
+		return
+	}
+	for _, h := range hooks {
+		if !h.Matches(p.Title) {
+			continue
+		}
+		body, err := chatMessage(h.Kind, p, rev)
+		if err != nil {
+			slog.Error("could not make chat message", "err", err)
+			continue
+		}
+		go postChat(h, body)
+	}
+}
+
+func postChat(h *ChatHook, body []byte) {
+	start := time.Now()
+	resp, err := webhookClient.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		observeJob("chat", start, err)
+		slog.Warn("could not post to chat", "kind", h.Kind, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		observeJob("chat", start, errors.New(resp.Status))
+		slog.Warn("could not post to chat", "kind", h.Kind, "status", resp.Status)
+		return
+	}
+	observeJob("chat", start, nil)
+}
+
+// chatHooksHandler adds or removes chat hooks. they are listed in the webhooks page.
+func chatHooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/webhooks", http.StatusFound)
+		return
+	}
+	var err error
+	if id := r.FormValue("remove"); id != "" {
+		n, perr := strconv.ParseUint(id, 10, 64)
+		if perr != nil {
+			httpError(w, r, newError(ErrInvalid, "invalid chat hook: %s", id))
+			return
+		}
+		err = removeChatHook(n)
+	} else {
+		err = addChatHookFromForm(r)
+	}
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/webhooks", http.StatusFound)
+}
+
+func addChatHookFromForm(r *http.Request) error {
+	kind := r.FormValue("kind")
+	if kind != "slack" && kind != "discord" {
+		return newError(ErrInvalid, "unknown chat: %s", kind)
+	}
+	u, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newError(ErrInvalid, "chat hook url should be a http or https url")
+	}
+	h := &ChatHook{
+		Kind:    kind,
+		URL:     u.String(),
+		Pages:   parseGroups(r.FormValue("pages")),
+		By:      authorName(r),
+		Created: time.Now(),
+	}
+	return addChatHook(h)
+}
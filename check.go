@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// checkCommand checks the database for problems, which could be made by bugs,
+// crashes with -db-nosync, or editing the database by hand.
+//
+//   - consistency of the bolt file itself
+//   - records those could not be decoded
+//   - revisions of a page not numbered from 1 without a gap
+//   - the titles index not matching the pages
+//   - attachments, protection and views of pages those don't exist
+//   - chunks of attachments those don't exist
+//   - sessions, tokens, drafts and notifications of users those don't exist
+//
+// with -repair, it deletes the broken and orphaned records.
+// broken revisions are only reported, as they should be restored from a backup.
+//
+// pages are checked only with bolt storage. the database doesn't have
+// other indexes (links, search, ...) to check, they are made from the pages.
+func checkCommand(fs *flag.FlagSet, args []string) (err error) {
+	repair := fs.Bool("repair", false, "delete broken and orphaned records")
+	parseArgs(fs, args, "[flags]", -1)
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+	c := &dbChecker{repair: *repair}
+	txFn := db.View
+	if *repair {
+		txFn = db.Update
+	}
+	err = txFn(c.check)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d problems, %d repaired\n", c.problems, c.repaired)
+	if c.problems > c.repaired {
+		return fmt.Errorf("whisky.db has problems")
+	}
+	return nil
+}
+
+type dbChecker struct {
+	repair   bool
+	problems int
+	repaired int
+}
+
+// report reports a problem of the record. fixable problems are repaired with -repair.
+func (c *dbChecker) report(path string, key []byte, fixable bool, format string, a ...interface{}) {
+	c.problems++
+	msg := fmt.Sprintf(format, a...)
+	if fixable && c.repair {
+		c.repaired++
+		msg += " (repaired)"
+	}
+	fmt.Printf("%s/%s: %s\n", path, keyString(key), msg)
+}
+
+// keyString returns the key readable. ids are big endian numbers.
+func keyString(k []byte) string {
+	if len(k) == 8 && k[0] == 0 {
+		return strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+	}
+	q := strconv.Quote(string(k))
+	return q[1 : len(q)-1]
+}
+
+func (c *dbChecker) check(tx *bolt.Tx) error {
+	for err := range tx.Check() {
+		c.problems++
+		fmt.Printf("whisky.db: %v\n", err)
+	}
+	names := make([]string, 0, len(recordTypes))
+	for name := range recordTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// broken revisions are kept for restoring.
+		err := c.checkRecords(tx.Bucket([]byte(name)), name, recordTypes[name], name != "history")
+		if err != nil {
+			return err
+		}
+	}
+	err := c.checkHistory(tx.Bucket([]byte("history")))
+	if err != nil {
+		return err
+	}
+	err = c.checkTitles(tx)
+	if err != nil {
+		return err
+	}
+	return c.checkOrphans(tx)
+}
+
+// checkRecords checks records of the bucket and it's nested buckets could be decoded.
+func (c *dbChecker) checkRecords(b *bolt.Bucket, path string, typ func(key []byte) interface{}, deletable bool) error {
+	var broken, nested [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested = append(nested, k)
+			return nil
+		}
+		x := typ(k)
+		if x == nil {
+			return nil
+		}
+		if err := decodeRecord(v, x); err != nil {
+			c.report(path, k, deletable, "could not decode: %v", err)
+			broken = append(broken, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deletable && c.repair {
+		for _, k := range broken {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range nested {
+		err := c.checkRecords(b.Bucket(k), path+"/"+keyString(k), typ, deletable)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHistory checks revisions of pages are numbered from 1 without a gap,
+// and the sequence of a page is not behind it's revisions, which would overwrite them.
+func (c *dbChecker) checkHistory(hist *bolt.Bucket) error {
+	var empty [][]byte
+	behind := make(map[string]uint64)
+	err := hist.ForEach(func(title, v []byte) error {
+		if v != nil {
+			c.report("history", title, false, "not a page")
+			return nil
+		}
+		b := hist.Bucket(title)
+		var n uint64
+		err := b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 {
+				c.report("history/"+string(title), k, false, "not a revision")
+				return nil
+			}
+			n++
+			if id := binary.BigEndian.Uint64(k); id != n {
+				c.report("history/"+string(title), k, false, "expected revision %d", n)
+				n = id
+			}
+			p := &Page{}
+			if decodeRecord(v, p) == nil && p.Title != string(title) {
+				c.report("history/"+string(title), k, false, "revision of another page %q", p.Title)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			c.report("history", title, true, "page without revisions")
+			empty = append(empty, append([]byte{}, title...))
+			return nil
+		}
+		if b.Sequence() < n {
+			c.report("history", title, true, "sequence %d is behind revision %d", b.Sequence(), n)
+			behind[string(title)] = n
+		}
+		return nil
+	})
+	if err != nil || !c.repair {
+		return err
+	}
+	for _, t := range empty {
+		if err := hist.DeleteBucket(t); err != nil {
+			return err
+		}
+	}
+	for t, n := range behind {
+		if err := hist.Bucket([]byte(t)).SetSequence(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTitles checks the titles index has every page with it's latest revision, and nothing else.
+// with -repair, the index is built again.
+func (c *dbChecker) checkTitles(tx *bolt.Tx) error {
+	hist := tx.Bucket([]byte("history"))
+	titles := tx.Bucket([]byte("titles"))
+	wrong := false
+	hist.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		last, _ := hist.Bucket(k).Cursor().Last()
+		if last == nil {
+			// reported already.
+			return nil
+		}
+		bs := titles.Get(k)
+		if bs == nil {
+			c.report("titles", k, true, "page is not in the index")
+			wrong = true
+			return nil
+		}
+		h := &PageHead{}
+		if decodeRecord(bs, h) != nil {
+			// reported already.
+			wrong = true
+			return nil
+		}
+		if rev := binary.BigEndian.Uint64(last); h.Rev != rev {
+			c.report("titles", k, true, "index has revision %d, not %d", h.Rev, rev)
+			wrong = true
+		}
+		return nil
+	})
+	titles.ForEach(func(k, v []byte) error {
+		if hist.Bucket(k) == nil {
+			c.report("titles", k, true, "index of a page not exists")
+			wrong = true
+		}
+		return nil
+	})
+	if !wrong || !c.repair {
+		return nil
+	}
+	_, err := rebuildTitles(tx)
+	return err
+}
+
+// checkOrphans checks records of pages and users those don't exist.
+func (c *dbChecker) checkOrphans(tx *bolt.Tx) error {
+	pages := make(map[string]bool)
+	tx.Bucket([]byte("history")).ForEach(func(k, v []byte) error {
+		if v == nil {
+			pages[string(k)] = true
+		}
+		return nil
+	})
+	users := make(map[string]bool)
+	tx.Bucket([]byte("users")).ForEach(func(k, v []byte) error {
+		users[string(k)] = true
+		return nil
+	})
+	// pages are in other storage, when there is no page in the database.
+	if len(pages) != 0 {
+		err := c.deleteOrphans(tx.Bucket([]byte("attachments")), "attachments", "attachments of a page not exists", func(k, v []byte) bool {
+			return pages[string(k)]
+		})
+		if err != nil {
+			return err
+		}
+		err = c.deleteOrphans(tx.Bucket([]byte("protection")), "protection", "protection of a page not exists", func(k, v []byte) bool {
+			return pages[string(k)]
+		})
+		if err != nil {
+			return err
+		}
+		err = c.deleteOrphans(tx.Bucket([]byte("views")), "views", "views of a page not exists", func(k, v []byte) bool {
+			return pages[string(k)]
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := c.checkChunks(tx)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"drafts", "notifications"} {
+		err := c.deleteOrphans(tx.Bucket([]byte(name)), name, name+" of a user not exists", func(k, v []byte) bool {
+			return users[string(k)]
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = c.deleteOrphans(tx.Bucket([]byte("sessions")), "sessions", "session of a user not exists", func(k, v []byte) bool {
+		s := &Session{}
+		// undecodable records are reported already.
+		return decodeRecord(v, s) != nil || users[s.User]
+	})
+	if err != nil {
+		return err
+	}
+	return c.deleteOrphans(tx.Bucket([]byte("tokens")), "tokens", "token of a user not exists", func(k, v []byte) bool {
+		t := &Token{}
+		return decodeRecord(v, t) != nil || users[t.User]
+	})
+}
+
+// checkChunks checks chunks of attachments those don't exist,
+// like ones left by a crash while replacing an attachment.
+func (c *dbChecker) checkChunks(tx *bolt.Tx) error {
+	attachments := tx.Bucket([]byte("attachments"))
+	chunks := tx.Bucket([]byte("attachment-chunks"))
+	err := c.deleteOrphans(chunks, "attachment-chunks", "chunks of a page without attachments", func(k, v []byte) bool {
+		return attachments.Bucket(k) != nil
+	})
+	if err != nil {
+		return err
+	}
+	var titles [][]byte
+	chunks.ForEach(func(k, v []byte) error {
+		if v == nil && attachments.Bucket(k) != nil {
+			titles = append(titles, k)
+		}
+		return nil
+	})
+	for _, t := range titles {
+		ids := make(map[uint64]bool)
+		attachments.Bucket(t).ForEach(func(k, v []byte) error {
+			a := &Attachment{}
+			// undecodable records are reported already.
+			if decodeRecord(v, a) == nil {
+				ids[a.ID] = true
+			}
+			return nil
+		})
+		err := c.deleteOrphans(chunks.Bucket(t), "attachment-chunks/"+keyString(t), "chunk of an attachment not exists", func(k, v []byte) bool {
+			return len(k) == 16 && ids[binary.BigEndian.Uint64(k)]
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphans reports records and nested buckets of the bucket those are not ok,
+// and deletes them with -repair.
+func (c *dbChecker) deleteOrphans(b *bolt.Bucket, path, problem string, ok func(k, v []byte) bool) error {
+	var orphans [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if !ok(k, v) {
+			c.report(path, k, true, "%s", problem)
+			orphans = append(orphans, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil || !c.repair {
+		return err
+	}
+	for _, k := range orphans {
+		var err error
+		if b.Bucket(k) != nil {
+			err = b.DeleteBucket(k)
+		} else {
+			err = b.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
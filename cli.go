@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// client commands talk to a running wiki through the json api.
+//
+//	whisky get [-rev N] <title>
+//	whisky put [-summary text] <title> <file>
+//	whisky history [-n N] <title>
+//	whisky search <query>
+//	whisky backup [-o file]
+//
+// the wiki address and api token are taken from -url and -token flags,
+// or WHISKY_URL and WHISKY_TOKEN environment variables.
+
+var clientCommands = map[string]func(c *apiClient, fs *flag.FlagSet, args []string) error{
+	"get":     getCommand,
+	"put":     putCommand,
+	"history": historyCommand,
+	"search":  searchCommand,
+	"backup":  backupCommand,
+}
+
+// runClientCommand runs the client command when args starts with one.
+// It returns false when args is not a client command.
+func runClientCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := clientCommands[args[0]]
+	if !ok {
+		return false
+	}
+	fs := flag.NewFlagSet("whisky "+args[0], flag.ExitOnError)
+	c := &apiClient{}
+	fs.StringVar(&c.url, "url", envOr("WHISKY_URL", "http://localhost:8080"), "address of the wiki")
+	fs.StringVar(&c.token, "token", os.Getenv("WHISKY_TOKEN"), "api token for writing")
+	err := cmd(c, fs, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+type apiClient struct {
+	url   string
+	token string
+}
+
+// pagePath returns path of the page's api, escaping each part of the title.
+func pagePath(title string) string {
+	parts := strings.Split(title, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return "/api/v1/pages/" + strings.Join(parts, "/")
+}
+
+// do sends the request and decodes the json response to v.
+func (c *apiClient) do(method, path string, body interface{}, v interface{}) error {
+	resp, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// send sends the request with body as json, and returns the response.
+// An error response of the api is returned as an error.
+func (c *apiClient) send(method, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		e := &APIError{}
+		if json.Unmarshal(data, e) == nil && e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+// parseArgs parses flags and checks number of the arguments.
+// n is 0 when the command needs one or more arguments, and -1 when it takes none.
+func parseArgs(fs *flag.FlagSet, args []string, usage string, n int) []string {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s\n", fs.Name(), usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if (n == 0 && fs.NArg() == 0) || (n > 0 && fs.NArg() != n) || (n < 0 && fs.NArg() != 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+func getCommand(c *apiClient, fs *flag.FlagSet, args []string) error {
+	rev := fs.Uint64("rev", 0, "revision to get. the latest revision if 0")
+	args = parseArgs(fs, args, "[flags] <title>", 1)
+	path := pagePath(args[0])
+	if *rev != 0 {
+		path += "?rev=" + strconv.FormatUint(*rev, 10)
+	}
+	p := &APIPage{}
+	err := c.do("GET", path, nil, p)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.WriteString(p.Body)
+	return err
+}
+
+func putCommand(c *apiClient, fs *flag.FlagSet, args []string) error {
+	summary := fs.String("summary", "", "summary of the change")
+	args = parseArgs(fs, args, "[flags] <title> <file>  (- for stdin)", 2)
+	var (
+		data []byte
+		err  error
+	)
+	if args[1] == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(args[1])
+	}
+	if err != nil {
+		return err
+	}
+	p := &APIPage{}
+	err = c.do("PUT", pagePath(args[0]), &APIEdit{Body: string(data), Summary: *summary}, p)
+	if err != nil {
+		return err
+	}
+	if p.Revision == 0 {
+		fmt.Fprintf(os.Stderr, "%s: the edit is waiting for review\n", args[0])
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: saved revision %d\n", args[0], p.Revision)
+	}
+	return nil
+}
+
+func historyCommand(c *apiClient, fs *flag.FlagSet, args []string) error {
+	n := fs.Int("n", 20, "number of revisions")
+	args = parseArgs(fs, args, "[flags] <title>", 1)
+	revs := &APIRevisions{}
+	err := c.do("GET", pagePath(args[0])+"/revisions?limit="+strconv.Itoa(*n), nil, revs)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range revs.Revisions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.Num, r.Created.Local().Format(time.RFC3339), r.Author, r.Summary)
+	}
+	return tw.Flush()
+}
+
+func searchCommand(c *apiClient, fs *flag.FlagSet, args []string) error {
+	args = parseArgs(fs, args, "[flags] <query>", 0)
+	res := &APISearch{}
+	err := c.do("GET", "/api/v1/search?q="+url.QueryEscape(strings.Join(args, " ")), nil, res)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range res.Results {
+		fmt.Fprintf(tw, "%s\t%s\n", r.Title, r.Snippet)
+	}
+	return tw.Flush()
+}
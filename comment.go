@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+type Comment struct {
+	ID uint64
+	// Parent is id of the comment this comment replies to.
+	// 0 means it is a top level comment.
+	Parent  uint64
+	Body    []byte
+	Created time.Time
+	Author  string
+	// Hidden comment is hidden by a moderator.
+	// It is not deleted to keep the thread.
+	Hidden bool
+}
+
+func (c *Comment) HTML() template.HTML {
+	return template.HTML(renderComment(c.Body))
+}
+
+// renderComment renders markdown of a comment. any signed in user can comment without a review,
+// so raw html in it is shown as text, and links to unsafe protocols like javascript: aren't made.
+func renderComment(body []byte) []byte {
+	html, _ := renderMarkdownFlags(body, blackfriday.CommonHTMLFlags|blackfriday.Safelink, func(ast *blackfriday.Node) {
+		escapeRawHTML(ast)
+		fixLinks(ast)
+	})
+	return html
+}
+
+// escapeRawHTML changes html blocks and spans of the tree into text, which is escaped when rendered.
+func escapeRawHTML(ast *blackfriday.Node) {
+	var blocks []*blackfriday.Node
+	ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch n.Type {
+		case blackfriday.HTMLSpan:
+			n.Type = blackfriday.Text
+		case blackfriday.HTMLBlock:
+			blocks = append(blocks, n)
+		}
+		return blackfriday.GoToNext
+	})
+	// a block is changed after the walk, as it gets a child.
+	for _, n := range blocks {
+		text := blackfriday.NewNode(blackfriday.Text)
+		text.Literal = n.Literal
+		n.Type = blackfriday.Paragraph
+		n.Literal = nil
+		n.AppendChild(text)
+	}
+}
+
+type CommentThread struct {
+	*Comment
+	Replies []*CommentThread
+}
+
+type TalkPage struct {
+	Title       string
+	Page        *Page
+	Threads     []*CommentThread
+	NumComments int
+}
+
+func saveComment(ctx context.Context, title string, c *Comment) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("comments")).CreateBucketIfNotExists([]byte(title))
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		if c.Parent != 0 && b.Get(byteID(c.Parent)) == nil {
+			return errCommentNotExists
+		}
+		c.ID, _ = b.NextSequence()
+		return putRecord(b, byteID(c.ID), c)
+	})
+}
+
+func loadComments(ctx context.Context, title string) ([]*Comment, error) {
+	cs := []*Comment{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("comments")).Bucket([]byte(title))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			c := &Comment{}
+			if err := fromBytes(v, c); err != nil {
+				return err
+			}
+			cs = append(cs, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// updateComment calls fn with the comment and saves the comment after that.
+func updateComment(ctx context.Context, title string, id uint64, fn func(c *Comment)) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("comments")).Bucket([]byte(title))
+		if b == nil {
+			return errCommentNotExists
+		}
+		v := b.Get(byteID(id))
+		if v == nil {
+			return errCommentNotExists
+		}
+		c := &Comment{}
+		if err := fromBytes(v, c); err != nil {
+			return err
+		}
+		fn(c)
+		return putRecord(b, byteID(id), c)
+	})
+}
+
+// deleteComment deletes the comment and it's replies.
+func deleteComment(ctx context.Context, title string, id uint64) error {
+	cs, err := loadComments(ctx, title)
+	if err != nil {
+		return err
+	}
+	del := map[uint64]bool{id: true}
+	// comments are sorted by id, and replies always have bigger ids than it's parent.
+	for _, c := range cs {
+		if del[c.Parent] {
+			del[c.ID] = true
+		}
+	}
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("comments")).Bucket([]byte(title))
+		if b == nil {
+			return errCommentNotExists
+		}
+		for id := range del {
+			if err := b.Delete(byteID(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func commentThreads(cs []*Comment) []*CommentThread {
+	threads := []*CommentThread{}
+	byID := make(map[uint64]*CommentThread)
+	for _, c := range cs {
+		t := &CommentThread{Comment: c}
+		byID[c.ID] = t
+		if p, ok := byID[c.Parent]; ok {
+			p.Replies = append(p.Replies, t)
+		} else {
+			threads = append(threads, t)
+		}
+	}
+	return threads
+}
+
+func talkHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method == "POST" {
+		postCommentHandler(w, r, title)
+		return
+	}
+	cs, err := loadComments(r.Context(), title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	p, err := loadPage(r.Context(), title)
+	if errors.Is(err, ErrNotFound) {
+		p = nil
+	} else if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "talk", &TalkPage{Title: title, Page: p, Threads: commentThreads(cs), NumComments: len(cs)})
+}
+
+func postCommentHandler(w http.ResponseWriter, r *http.Request, title string) {
+	q := r.URL.Query()
+	if (q.Get("hide") != "" || q.Get("delete") != "") && !isAdmin(r) {
+		httpError(w, r, newError(ErrForbidden, "only admins can moderate comments"))
+		return
+	}
+	if hide := q.Get("hide"); hide != "" {
+		moderateComment(w, r, title, hide, func(id uint64) error {
+			return updateComment(r.Context(), title, id, func(c *Comment) { c.Hidden = !c.Hidden })
+		})
+		return
+	}
+	if del := q.Get("delete"); del != "" {
+		moderateComment(w, r, title, del, func(id uint64) error {
+			return deleteComment(r.Context(), title, id)
+		})
+		return
+	}
+	body := strings.TrimSpace(strings.Replace(r.FormValue("body"), "\r\n", "\n", -1))
+	if body == "" {
+		http.Redirect(w, r, "/talk/"+title, http.StatusFound)
+		return
+	}
+	parent, err := strconv.ParseUint(r.FormValue("parent"), 10, 64)
+	if err != nil {
+		parent = 0
+	}
+	c := &Comment{Parent: parent, Body: []byte(body), Created: time.Now(), Author: authorName(r)}
+	err = saveComment(r.Context(), title, c)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	notifyCommentPosted(title, c)
+	http.Redirect(w, r, "/talk/"+title+"#comment-"+strconv.FormatUint(c.ID, 10), http.StatusFound)
+}
+
+func moderateComment(w http.ResponseWriter, r *http.Request, title, ids string, fn func(id uint64) error) {
+	id, err := strconv.ParseUint(ids, 10, 64)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	err = fn(id)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/talk/"+title, http.StatusFound)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCommentEscapesHTML(t *testing.T) {
+	for _, body := range []string{
+		"<script>alert(1)</script>",
+		"hi <img src=x onerror=alert(1)> there",
+		"<div onclick=\"alert(1)\">\n\nclick\n\n</div>",
+		"[click](javascript:alert(1))",
+		"[click](JavaScript:alert(1))",
+		"<a href=\"javascript:alert(1)\">click</a>",
+	} {
+		html := string(renderComment([]byte(body)))
+		for _, bad := range []string{"<script", "<img", "<div", "<a href=\"javascript", "<a href=\"JavaScript"} {
+			if strings.Contains(html, bad) {
+				t.Errorf("%q is rendered as %q, which has %q", body, html, bad)
+			}
+		}
+	}
+}
+
+func TestRenderCommentMarkdown(t *testing.T) {
+	html := string(renderComment([]byte("**bold** [link](/view/Home) [site](https://example.com) `<b>`")))
+	for _, want := range []string{
+		"<strong>bold</strong>",
+		`<a href="/view/Home">link</a>`,
+		`<a href="https://example.com">site</a>`,
+		"<code>&lt;b&gt;</code>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered %q, want %q in it", html, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// responses are compressed with gzip or deflate when the client accepts it,
+// as rendered pages and long histories are mostly text.
+// types those are compressed already (images, archives, ...) are sent as is.
+
+// compressMinSize is the smallest response to compress, when it's size is known.
+const compressMinSize = 1 << 10
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// compressedType reports whether the content type is compressed already.
+func compressedType(ctype string) bool {
+	ctype = strings.TrimSpace(strings.Split(ctype, ";")[0])
+	switch {
+	case ctype == "image/svg+xml":
+		return false
+	case strings.HasPrefix(ctype, "image/"), strings.HasPrefix(ctype, "video/"), strings.HasPrefix(ctype, "audio/"), strings.HasPrefix(ctype, "font/woff"):
+		return true
+	}
+	switch ctype {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/pdf", "application/octet-stream":
+		return true
+	}
+	return false
+}
+
+// acceptedEncoding returns the encoding to compress the response with, or "".
+func acceptedEncoding(r *http.Request) string {
+	var deflate bool
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		e = strings.TrimSpace(e)
+		if strings.HasSuffix(e, ";q=0") {
+			continue
+		}
+		switch strings.TrimSpace(strings.Split(e, ";")[0]) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// withCompression compresses responses of h.
+func withCompression(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := acceptedEncoding(r)
+		// a range of a compressed response is not a range of the content.
+		if enc == "" || r.Method == "HEAD" || r.Header.Get("Range") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides whether to compress the response with it's headers,
+// when the handler starts to write it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	started bool
+	w       io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started {
+		return
+	}
+	cw.started = true
+	hdr := cw.Header()
+	hdr.Add("Vary", "Accept-Encoding")
+	if cw.shouldCompress(status) {
+		hdr.Del("Content-Length")
+		hdr.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.w = gz
+		} else {
+			fl := flateWriters.Get().(*flate.Writer)
+			fl.Reset(cw.ResponseWriter)
+			cw.w = fl
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) shouldCompress(status int) bool {
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	hdr := cw.Header()
+	if hdr.Get("Content-Encoding") != "" || compressedType(hdr.Get("Content-Type")) {
+		return false
+	}
+	if n, err := strconv.Atoi(hdr.Get("Content-Length")); err == nil && n < compressMinSize {
+		return false
+	}
+	return true
+}
+
+func (cw *compressWriter) Write(bs []byte) (int, error) {
+	if !cw.started {
+		if cw.Header().Get("Content-Type") == "" {
+			// what net/http would do for the response.
+			cw.Header().Set("Content-Type", http.DetectContentType(bs))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(bs)
+	}
+	return cw.w.Write(bs)
+}
+
+// Flush flushes compressed data written so far, for streaming responses.
+func (cw *compressWriter) Flush() {
+	if fw, ok := cw.w.(interface{ Flush() error }); ok {
+		fw.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the compressed stream, and puts the writer back to the pool.
+func (cw *compressWriter) Close() error {
+	if cw.w == nil {
+		return nil
+	}
+	err := cw.w.Close()
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(w)
+	case *flate.Writer:
+		flateWriters.Put(w)
+	}
+	cw.w = nil
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// a config file sets flags of the server, so a wiki doesn't need a long command line.
+// keys are names of the flags. keys in a table are prefixed with the table name,
+// so
+//
+//	addr = ":80"
+//	storage = "git"
+//
+//	[git]
+//	dir = "pages"
+//	remote = "origin"
+//
+// is same as -addr :80 -storage git -git-dir pages -git-remote origin.
+// flags given in the command line or by environment variables override the file.
+
+// loadConfig sets flags those are not given in the command line from the config file.
+// it returns the settings of the file, by the flag names.
+func loadConfig(fs *flag.FlagSet, path string) (map[string]string, error) {
+	cfg, err := readConfig(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	given := givenFlags(fs)
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	// set in the same order always, to report the same error first.
+	sort.Strings(names)
+	for _, name := range names {
+		if given[name] {
+			continue
+		}
+		err := fs.Set(name, cfg[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// readConfig reads the config file, and returns the settings by the flag names.
+func readConfig(fs *flag.FlagSet, path string) (map[string]string, error) {
+	raw := make(map[string]interface{})
+	_, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		return nil, err
+	}
+	cfg := make(map[string]string)
+	err = flattenConfig(fs, "", raw, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// flattenConfig puts settings of the tables in raw to cfg, with the names of the flags.
+func flattenConfig(fs *flag.FlagSet, prefix string, raw map[string]interface{}, cfg map[string]string) error {
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "-" + k
+		}
+		if table, ok := raw[k].(map[string]interface{}); ok {
+			err := flattenConfig(fs, name, table, cfg)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown setting: %s", name)
+		}
+		switch v := raw[k].(type) {
+		case string:
+			cfg[name] = v
+		case bool, int64, float64:
+			cfg[name] = fmt.Sprint(v)
+		case []interface{}:
+			// a flag given many times, like listen. the flag splits them by spaces.
+			values := make([]string, 0, len(v))
+			for _, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("%s: unsupported value: %v", name, e)
+				}
+				values = append(values, s)
+			}
+			cfg[name] = strings.Join(values, " ")
+		default:
+			return fmt.Errorf("%s: unsupported value: %v", name, v)
+		}
+	}
+	return nil
+}
+
+// givenFlags returns the flags those are set already, in the command line or by others.
+func givenFlags(fs *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// envName returns the environment variable of the flag, like WHISKY_GIT_DIR for -git-dir.
+func envName(flagName string) string {
+	return "WHISKY_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// loadEnv sets flags those are not given in the command line from the environment variables,
+// so whisky can be configured without a wrapper script in a container.
+func loadEnv(fs *flag.FlagSet) error {
+	given := givenFlags(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		env := envName(f.Name)
+		v := os.Getenv(env)
+		if v == "" {
+			return
+		}
+		if serr := fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("%s: %v", env, serr)
+		}
+	})
+	return err
+}
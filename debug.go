@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// admins can diagnose a slow or big wiki in production, with the profiles of
+// net/http/pprof at /debug/pprof/ (download them with an admin api token for
+// go tool pprof), and the runtime stats of the process at /debug/runtime.
+//
+// a cpu profile or a trace takes ?seconds=N of the request,
+// which should be shorter than -request-timeout and -write-timeout.
+
+// startTime is when the process started.
+var startTime = time.Now()
+
+// serverStats are stats of the configuration of the wiki, like the storage and enabled features.
+// they are set at startup.
+var serverStats []RuntimeStat
+
+// handleDebug adds the debug handlers, only for admins, to the mux.
+func handleDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", adminOnly(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", adminOnly(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", adminOnly(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", adminOnly(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", adminOnly(pprof.Trace))
+	mux.HandleFunc("/debug/runtime", adminOnly(runtimeHandler))
+}
+
+type RuntimePage struct {
+	// Title is empty, as it's not a page.
+	Title    string
+	Sections []RuntimeSection
+}
+
+// RuntimeSection is a group of stats.
+type RuntimeSection struct {
+	Name  string
+	Stats []RuntimeStat
+}
+
+type RuntimeStat struct {
+	Name  string
+	Value string
+}
+
+func runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	ds := db.Stats()
+	var dbSize int64
+	err := viewTx(r.Context(), func(tx *bolt.Tx) error {
+		dbSize = tx.Size()
+		return nil
+	})
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	commitStat := commit
+	if dirty {
+		commitStat += " (with uncommitted changes)"
+	}
+	lastGC := "never"
+	if m.LastGC != 0 {
+		lastGC = time.Since(time.Unix(0, int64(m.LastGC))).Round(time.Second).String() + " ago"
+	}
+	renderTemplate(w, r, "runtime", &RuntimePage{
+		Sections: []RuntimeSection{
+			{"Build", []RuntimeStat{
+				{"version", version},
+				{"commit", commitStat},
+				{"build date", buildDate},
+				{"go version", runtime.Version()},
+				{"platform", runtime.GOOS + "/" + runtime.GOARCH},
+			}},
+			{"Server", serverStats},
+			{"Process", []RuntimeStat{
+				{"uptime", time.Since(startTime).Round(time.Second).String()},
+				{"goroutines", strconv.Itoa(runtime.NumGoroutine())},
+				{"cpus", strconv.Itoa(runtime.NumCPU())},
+				{"GOMAXPROCS", strconv.Itoa(runtime.GOMAXPROCS(0))},
+			}},
+			{"Memory", []RuntimeStat{
+				{"heap in use", formatBytes(m.HeapInuse)},
+				{"heap allocated", formatBytes(m.HeapAlloc)},
+				{"heap objects", strconv.FormatUint(m.HeapObjects, 10)},
+				{"heap released to os", formatBytes(m.HeapReleased)},
+				{"stacks in use", formatBytes(m.StackInuse)},
+				{"from os", formatBytes(m.Sys)},
+				{"allocated in total", formatBytes(m.TotalAlloc)},
+				{"gc runs", strconv.FormatUint(uint64(m.NumGC), 10)},
+				{"last gc", lastGC},
+				{"gc pauses in total", time.Duration(m.PauseTotalNs).String()},
+			}},
+			{"Database", []RuntimeStat{
+				{"path", dbPath},
+				{"size", formatBytes(uint64(dbSize))},
+				{"nosync", strconv.FormatBool(dbOptions.NoSync)},
+				{"freelist type", string(dbOptions.FreelistType)},
+				{"initial mmap size", formatBytes(uint64(dbOptions.InitialMmapSize))},
+				{"save batch delay", saveBatchDelay.String()},
+				{"open read transactions", strconv.Itoa(ds.OpenTxN)},
+				{"read transactions", strconv.Itoa(ds.TxN)},
+				{"free pages", strconv.Itoa(ds.FreePageN)},
+				{"pending pages", strconv.Itoa(ds.PendingPageN)},
+				{"free allocated", formatBytes(uint64(ds.FreeAlloc))},
+				{"freelist in use", formatBytes(uint64(ds.FreelistInuse))},
+				{"pages allocated", strconv.FormatInt(ds.TxStats.GetPageCount(), 10)},
+				{"rebalances", strconv.FormatInt(ds.TxStats.GetRebalance(), 10)},
+				{"splits", strconv.FormatInt(ds.TxStats.GetSplit(), 10)},
+				{"spills", strconv.FormatInt(ds.TxStats.GetSpill(), 10)},
+				{"writes", strconv.FormatInt(ds.TxStats.GetWrite(), 10)},
+				{"time to write", ds.TxStats.GetWriteTime().String()},
+			}},
+			{"Search Index", searchIndexStats()},
+		},
+	})
+}
+
+// searchIndexStats returns the state of the search index, and how far it's behind saves.
+func searchIndexStats() []RuntimeStat {
+	s := searchIndex.stats()
+	if !s.Enabled {
+		return []RuntimeStat{{"state", "off. searches read every page"}}
+	}
+	state := "ready"
+	if !s.Ready {
+		state = "loading. searches read every page"
+	}
+	lastIndexed := "never"
+	if !s.LastIndexed.IsZero() {
+		lastIndexed = time.Since(s.LastIndexed).Round(time.Second).String() + " ago"
+	}
+	return []RuntimeStat{
+		{"state", state},
+		{"pages", strconv.Itoa(s.Pages)},
+		{"words", strconv.Itoa(s.Words)},
+		{"queued pages", strconv.Itoa(s.Queued)},
+		{"lag", s.Lag.Round(time.Millisecond).String()},
+		{"last indexed", lastIndexed},
+	}
+}
+
+// formatBytes formats the size in bytes with one decimal, like 12.3MB.
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return strconv.FormatUint(n, 10) + " bytes"
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DiffLine is a line of a diff between two revisions.
+type DiffLine struct {
+	// Op is one of "equal", "insert" or "delete".
+	Op   string `json:"op"`
+	Text string `json:"text"`
+	// Old and New are line numbers (from 1) in each revision.
+	// It is 0 when the line is not in the revision.
+	Old int `json:"old,omitempty"`
+	New int `json:"new,omitempty"`
+	// Words are the text split by the words changed, for a line changed to another.
+	// It is empty when the whole line is inserted or deleted.
+	Words []DiffWord `json:"words,omitempty"`
+}
+
+// DiffWord is a part of a changed line.
+type DiffWord struct {
+	// Op is "equal", or the Op of the line when the word is changed.
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type Diff struct {
+	Title   string     `json:"title"`
+	From    uint64     `json:"from"`
+	To      uint64     `json:"to"`
+	Inserts int        `json:"inserts"`
+	Deletes int        `json:"deletes"`
+	Lines   []DiffLine `json:"lines"`
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines finds the longest common lines of a and b,
+// and returns the others as deleted or inserted lines.
+func diffLines(a, b []string) []DiffLine {
+	// common prefix and suffix are skipped, as most edits are small.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma := a[pre : len(a)-suf]
+	mb := b[pre : len(b)-suf]
+	// lcs[i][j] is length of the longest common lines of ma[i:] and mb[j:].
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	lines := make([]DiffLine, 0, len(a)+len(b)-pre-suf)
+	for i := 0; i < pre; i++ {
+		lines = append(lines, DiffLine{Op: "equal", Text: a[i], Old: i + 1, New: i + 1})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, DiffLine{Op: "equal", Text: ma[i], Old: pre + i + 1, New: pre + j + 1})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, DiffLine{Op: "delete", Text: ma[i], Old: pre + i + 1})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: "insert", Text: mb[j], New: pre + j + 1})
+			j++
+		}
+	}
+	for k := 0; k < suf; k++ {
+		lines = append(lines, DiffLine{Op: "equal", Text: a[len(a)-suf+k], Old: len(a) - suf + k + 1, New: len(b) - suf + k + 1})
+	}
+	diffChangedLines(lines)
+	return lines
+}
+
+// maxWordDiff is the max product of the words of two lines those are diffed by words.
+const maxWordDiff = 250000
+
+// diffChangedLines finds words changed in lines those are changed to others.
+// deleted lines are paired with inserted lines right after them in order,
+// and a pair is diffed when they have enough words in common.
+func diffChangedLines(lines []DiffLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != "delete" {
+			i++
+			continue
+		}
+		dels := i
+		for i < len(lines) && lines[i].Op == "delete" {
+			i++
+		}
+		ins := i
+		for i < len(lines) && lines[i].Op == "insert" {
+			i++
+		}
+		for k := 0; dels+k < ins && ins+k < i; k++ {
+			del, in := &lines[dels+k], &lines[ins+k]
+			del.Words, in.Words = diffWords(del.Text, in.Text)
+		}
+	}
+}
+
+// splitWords splits the line into words, spaces and punctuations,
+// which are joined into the line again.
+func splitWords(s string) []string {
+	var words []string
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		kind := wordKind(r)
+		if kind == 2 {
+			// each punctuation is a word.
+			words = append(words, s[:n])
+			s = s[n:]
+			continue
+		}
+		for n < len(s) {
+			r, m := utf8.DecodeRuneInString(s[n:])
+			if wordKind(r) != kind {
+				break
+			}
+			n += m
+		}
+		words = append(words, s[:n])
+		s = s[n:]
+	}
+	return words
+}
+
+// wordKind returns 0 for a letter or a digit, 1 for a space, and 2 for others.
+func wordKind(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return 0
+	case unicode.IsSpace(r):
+		return 1
+	}
+	return 2
+}
+
+// diffWords returns words of the old and new line, marked as deleted and inserted ones.
+// It returns nil when the lines are too long, or too different to show words changed,
+// as the whole lines are easier to read then.
+func diffWords(a, b string) (del, ins []DiffWord) {
+	wa, wb := splitWords(a), splitWords(b)
+	if len(wa)*len(wb) > maxWordDiff {
+		return nil, nil
+	}
+	// lcs[i][j] is length of the longest common words of wa[i:] and wb[j:],
+	// counted by bytes, so long words weigh more.
+	lcs := make([][]int32, len(wa)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(wb)+1)
+	}
+	for i := len(wa) - 1; i >= 0; i-- {
+		for j := len(wb) - 1; j >= 0; j-- {
+			if wa[i] == wb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + int32(len(wa[i]))
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	// lines with less than half of them in common are shown as they are.
+	if int(lcs[0][0])*4 < len(a)+len(b) {
+		return nil, nil
+	}
+	// add appends the word, joined with the last one of the same op.
+	add := func(words []DiffWord, op, text string) []DiffWord {
+		if n := len(words); n != 0 && words[n-1].Op == op {
+			words[n-1].Text += text
+			return words
+		}
+		return append(words, DiffWord{Op: op, Text: text})
+	}
+	i, j := 0, 0
+	for i < len(wa) || j < len(wb) {
+		switch {
+		case i < len(wa) && j < len(wb) && wa[i] == wb[j]:
+			del = add(del, "equal", wa[i])
+			ins = add(ins, "equal", wb[j])
+			i++
+			j++
+		case j == len(wb) || (i < len(wa) && lcs[i+1][j] >= lcs[i][j+1]):
+			del = add(del, "delete", wa[i])
+			i++
+		default:
+			ins = add(ins, "insert", wb[j])
+			j++
+		}
+	}
+	return del, ins
+}
+
+// diffRevisions makes a diff between two revisions of the page.
+// When to is 0, it is the latest revision.
+// When from is 0, it is the revision before to.
+// Revision 0 of a new page is empty.
+func diffRevisions(ctx context.Context, title string, from, to uint64) (*Diff, error) {
+	top, to, err := loadRevision(ctx, title, to)
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	fromp := &Page{}
+	if from != 0 {
+		fromp, err = loadPageRev(ctx, title, from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d := &Diff{Title: title, From: from, To: to}
+	d.Lines = diffLines(splitLines(string(fromp.Body)), splitLines(string(top.Body)))
+	for _, l := range d.Lines {
+		switch l.Op {
+		case "insert":
+			d.Inserts++
+		case "delete":
+			d.Deletes++
+		}
+	}
+	return d, nil
+}
+
+// parseRevs parses from and to query of a diff request.
+// An empty value is 0.
+func parseRevs(r *http.Request) (from, to uint64, err error) {
+	q := r.URL.Query()
+	if s := q.Get("from"); s != "" {
+		from, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, 0, newError(ErrInvalid, "invalid revision: %s", s)
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		to, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, 0, newError(ErrInvalid, "invalid revision: %s", s)
+		}
+	}
+	return from, to, nil
+}
+
+// DiffRow is a row of a split diff, which has the old line on the left
+// and the new line on the right. A side is nil when the line is not in the revision.
+type DiffRow struct {
+	Old, New *DiffLine
+}
+
+// splitRows lays out the lines side by side.
+// deleted lines are next to the lines inserted right after them.
+func splitRows(lines []DiffLine) []DiffRow {
+	rows := make([]DiffRow, 0, len(lines))
+	for i := 0; i < len(lines); {
+		if lines[i].Op == "equal" {
+			rows = append(rows, DiffRow{Old: &lines[i], New: &lines[i]})
+			i++
+			continue
+		}
+		dels := i
+		for i < len(lines) && lines[i].Op == "delete" {
+			i++
+		}
+		ins := i
+		for i < len(lines) && lines[i].Op == "insert" {
+			i++
+		}
+		for k := 0; dels+k < ins || ins+k < i; k++ {
+			var row DiffRow
+			if dels+k < ins {
+				row.Old = &lines[dels+k]
+			}
+			if ins+k < i {
+				row.New = &lines[ins+k]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// DiffPage is a diff shown in the style the user prefers.
+type DiffPage struct {
+	*Diff
+	// Style is "unified" or "split".
+	Style string
+	// Rows are rows of the split diff.
+	Rows []DiffRow
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request, title string) {
+	from, to, err := parseRevs(r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	d, err := diffRevisions(r.Context(), title, from, to)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	style := r.URL.Query().Get("style")
+	if style == "" {
+		style = userPreferences(r).DiffStyle
+	}
+	p := &DiffPage{Diff: d, Style: oneOf(style, diffStyles)}
+	if p.Style == "split" {
+		p.Rows = splitRows(d.Lines)
+	}
+	renderTemplate(w, r, "diff", p)
+}
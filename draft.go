@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Draft is editor content which is saved automatically while editing.
+// It is kept apart from the page history, and removed when the page is saved.
+type Draft struct {
+	Body        []byte
+	Attribution string
+	Saved       time.Time
+}
+
+// drafts bucket has a bucket per editor, which has drafts by page titles.
+
+func loadDraft(editor, title string) (*Draft, error) {
+	d := &Draft{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("drafts")).Bucket([]byte(editor))
+		if b == nil {
+			return newError(ErrNotFound, "draft not exists")
+		}
+		bs := b.Get([]byte(title))
+		if bs == nil {
+			return newError(ErrNotFound, "draft not exists")
+		}
+		return fromBytes(bs, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func saveDraft(editor, title string, d *Draft) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("drafts")).CreateBucketIfNotExists([]byte(editor))
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		bs, err := encodePrivate(d)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(title), bs)
+	})
+}
+
+func removeDraft(editor, title string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("drafts")).Bucket([]byte(editor))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(title))
+	})
+}
+
+// pendingDraft returns the editor's draft of the page,
+// if it has changes which are not saved to the page yet.
+func pendingDraft(editor string, p *Page) *Draft {
+	d, err := loadDraft(editor, p.Title)
+	if err != nil {
+		return nil
+	}
+	if d.Saved.Before(p.Created) || (bytes.Equal(d.Body, p.Body) && d.Attribution == p.Attribution) {
+		return nil
+	}
+	return d
+}
+
+func draftHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkEditable(w, r, title) {
+		return
+	}
+	editor := editorName(r)
+	if r.URL.Query().Get("discard") != "" {
+		err := removeDraft(editor, title)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
+		return
+	}
+	d := &Draft{
+		Body:        []byte(strings.Replace(r.FormValue("body"), "\r\n", "\n", -1)),
+		Attribution: strings.TrimSpace(r.FormValue("attribution")),
+		Saved:       time.Now(),
+	}
+	err := saveDraft(editor, title, d)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// editing tracks who opened the editor of a page recently,
+// so we can warn others before they make conflicting edits.
+//
+// it is kept in memory as it does not need to survive restarts.
+var editing = struct {
+	sync.Mutex
+	// title -> editor -> when the editor was opened
+	m map[string]map[string]time.Time
+}{m: make(map[string]map[string]time.Time)}
+
+// editors who opened the editor before editingTimeout are
+// considered as they left without saving.
+const editingTimeout = 10 * time.Minute
+
+// startEditing records the editor is editing the page,
+// and returns other editors those are currently editing the page.
+func startEditing(title, editor string) []string {
+	editing.Lock()
+	defer editing.Unlock()
+	now := time.Now()
+	eds := editing.m[title]
+	if eds == nil {
+		eds = make(map[string]time.Time)
+		editing.m[title] = eds
+	}
+	others := []string{}
+	for ed, t := range eds {
+		if now.Sub(t) > editingTimeout {
+			delete(eds, ed)
+			continue
+		}
+		if ed != editor {
+			others = append(others, ed)
+		}
+	}
+	eds[editor] = now
+	sort.Strings(others)
+	return others
+}
+
+func stopEditing(title, editor string) {
+	editing.Lock()
+	defer editing.Unlock()
+	eds := editing.m[title]
+	delete(eds, editor)
+	if len(eds) == 0 {
+		delete(editing.m, title)
+	}
+}
+
+// editorName identifies an editor, by the user name or the address of an anonymous user.
+func editorName(r *http.Request) string {
+	return authorName(r)
+}
+
+// previewHandler renders the markdown of the editor, for the preview next to it.
+// it writes only the html of the page, not a whole document.
+func previewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkEditable(w, r, title) {
+		return
+	}
+	body := strings.Replace(r.FormValue("body"), "\r\n", "\n", -1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(renderMarkdown([]byte(body)))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	bolt "go.etcd.io/bbolt"
+)
+
+// records in the database are a version byte followed by the encoded value.
+//
+//	0x01  json of the value, by the json tags of it's type
+//	0x02  zstd compressed json, for large records like revisions of long pages
+//	0x03  encrypted record (see encrypt.go)
+//
+// records written before the versioning are gob of the value without
+// the version byte. a gob stream starts with the length of the first message,
+// a type definition, which is never that small, so they are told apart from versioned records.
+// they are still read, and rewritten by the migrate-db command.
+//
+// json doesn't break when a field is added or removed, but renaming a field
+// or it's json tag, or changing it's type, needs a new version or a migration.
+const (
+	recordJSON     byte = 1
+	recordJSONZstd byte = 2
+)
+
+// records smaller than compressThreshold are not compressed,
+// as compression hardly makes them smaller.
+const compressThreshold = 1 << 10
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func toBytes(x interface{}) ([]byte, error) {
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return nil, fmt.Errorf("encode %T: %v", x, err)
+	}
+	return append([]byte{recordJSON}, bs...), nil
+}
+
+// toCompressedBytes is like toBytes, but compresses a large record.
+func toCompressedBytes(x interface{}) ([]byte, error) {
+	bs, err := toBytes(x)
+	if err != nil || len(bs) < compressThreshold {
+		return bs, err
+	}
+	return zstdEncoder.EncodeAll(bs[1:], []byte{recordJSONZstd}), nil
+}
+
+// fromBytes decodes the record into x.
+// It returns an ErrCorrupted error when the record could not be decoded.
+func fromBytes(bs []byte, x interface{}) error {
+	err := decodeRecord(bs, x)
+	if err != nil {
+		return newError(ErrCorrupted, "corrupted %T record: %v", x, err)
+	}
+	return nil
+}
+
+func decodeRecord(bs []byte, x interface{}) error {
+	if len(bs) == 0 {
+		return fmt.Errorf("empty record")
+	}
+	switch bs[0] {
+	case recordJSON:
+		return json.Unmarshal(bs[1:], x)
+	case recordJSONZstd:
+		data, err := zstdDecoder.DecodeAll(bs[1:], nil)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, x)
+	case recordEncrypted:
+		data, err := decryptRecord(bs)
+		if err != nil {
+			return err
+		}
+		return decodeRecord(data, x)
+	default:
+		return gob.NewDecoder(bytes.NewReader(bs)).Decode(x)
+	}
+}
+
+// putRecord encodes x and puts it to the bucket.
+func putRecord(b *bolt.Bucket, key []byte, x interface{}) error {
+	bs, err := toBytes(x)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, bs)
+}
+
+// isLegacyRecord reports whether the record is a gob record without a version.
+func isLegacyRecord(bs []byte) bool {
+	return len(bs) != 0 && bs[0] != recordJSON && bs[0] != recordJSONZstd && bs[0] != recordEncrypted
+}
+
+// recordTypes returns an empty value of the record type kept in the bucket by the key.
+// nested buckets are per page or user, and have the same type of records.
+// it returns nil for raw values (watches, imageproxy-key, ...).
+var recordTypes = map[string]func(key []byte) interface{}{
+	"history":           func([]byte) interface{} { return &Page{} },
+	"comments":          func([]byte) interface{} { return &Comment{} },
+	"users":             func([]byte) interface{} { return &User{} },
+	"sessions":          func([]byte) interface{} { return &Session{} },
+	"protection":        func([]byte) interface{} { return &Protection{} },
+	"drafts":            func([]byte) interface{} { return &Draft{} },
+	"pending":           func([]byte) interface{} { return &PendingEdit{} },
+	"imagecache":        func([]byte) interface{} { return &Image{} },
+	"notifications":     func([]byte) interface{} { return &Notification{} },
+	"tokens":            func([]byte) interface{} { return &Token{} },
+	"webhooks":          func([]byte) interface{} { return &Webhook{} },
+	"deliveries":        func([]byte) interface{} { return &Delivery{} },
+	"attachments":       func([]byte) interface{} { return &Attachment{} },
+	"attachment-chunks": func([]byte) interface{} { return &AttachmentChunk{} },
+	"sync":              func([]byte) interface{} { return &SyncState{} },
+	"chathooks":         func([]byte) interface{} { return &ChatHook{} },
+	"titles":            func([]byte) interface{} { return &PageHead{} },
+	"views":             func([]byte) interface{} { return &PageViews{} },
+	"changes":           func([]byte) interface{} { return &Change{} },
+	"search":            func([]byte) interface{} { return &SearchDoc{} },
+	"preferences":       func([]byte) interface{} { return &Preferences{} },
+	"settings": func(key []byte) interface{} {
+		switch string(key) {
+		case "site":
+			return &Settings{}
+		case "logo", "favicon":
+			return &Image{}
+		}
+		return nil
+	},
+}
+
+// migrateDBCommand rewrites gob records written by an older whisky with the current encoding,
+// compresses large revisions written before the compression,
+// and encrypts revisions, search docs and attachments written before the encryption.
+// Old records are still readable without it, but after the migration
+// the database can be read by other tools, as it doesn't have gob anymore.
+func migrateDBCommand(fs *flag.FlagSet, args []string) (err error) {
+	parseArgs(fs, args, "", -1)
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+	total := 0
+	for name, typ := range recordTypes {
+		n := 0
+		err = db.Update(func(tx *bolt.Tx) error {
+			var err error
+			n, err = migrateBucket(tx.Bucket([]byte(name)), name, typ)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if n != 0 {
+			fmt.Fprintf(os.Stderr, "%s: migrated %d records\n", name, n)
+		}
+		total += n
+	}
+	fmt.Fprintf(os.Stderr, "migrated %d records\n", total)
+	return nil
+}
+
+// recordEncoder returns the encoder of records in the bucket.
+func recordEncoder(bucket string) func(x interface{}) ([]byte, error) {
+	switch bucket {
+	case "history", "drafts", "pending", "search":
+		return encodePrivate
+	case "attachments", "attachment-chunks":
+		return encodeAttachment
+	}
+	return toBytes
+}
+
+// needsMigration reports whether the record in the bucket is not in the current encoding.
+func needsMigration(bucket string, v []byte) bool {
+	if isLegacyRecord(v) {
+		return true
+	}
+	switch bucket {
+	case "history", "drafts", "pending", "search":
+		if v[0] == recordJSON && len(v) >= compressThreshold {
+			return true
+		}
+		fallthrough
+	case "attachments", "attachment-chunks":
+		return recordCipher != nil && v[0] != recordEncrypted
+	}
+	return false
+}
+
+// migrateBucket rewrites records not in the current encoding in the bucket and it's nested buckets.
+func migrateBucket(b *bolt.Bucket, bucket string, typ func(key []byte) interface{}) (int, error) {
+	type record struct {
+		key []byte
+		val []byte
+	}
+	var recs []record
+	var nested [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested = append(nested, k)
+			return nil
+		}
+		if needsMigration(bucket, v) {
+			// keys and values are only valid in the transaction,
+			// and the bucket can't be changed while iterating.
+			recs = append(recs, record{append([]byte{}, k...), append([]byte{}, v...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range recs {
+		x := typ(r.key)
+		if x == nil {
+			continue
+		}
+		err := decodeRecord(r.val, x)
+		if err != nil {
+			return n, fmt.Errorf("decode %q: %v", r.key, err)
+		}
+		bs, err := recordEncoder(bucket)(x)
+		if err != nil {
+			return n, err
+		}
+		err = b.Put(r.key, bs)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, k := range nested {
+		m, err := migrateBucket(b.Bucket(k), bucket, typ)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// contents of pages (revisions, drafts and pending edits) and attachments
+// can be encrypted in the database,
+// for wikis those keep sensitive documents on a shared host.
+//
+// the key is derived from a passphrase in WHISKY_PASSPHRASE environment variable,
+// or from the contents of a key file (-encryption-key-file or WHISKY_KEY_FILE),
+// with scrypt and a random salt kept in the database.
+// encrypted records are
+//
+//	0x03  nonce (12 bytes) + AES-256-GCM sealed record of another version
+//
+// once a record is encrypted, the wiki can't start without the key.
+// records written before the encryption are kept as is, until migrate-db runs.
+const recordEncrypted byte = 3
+
+// encryptionKeyFile is the file to derive the key from. It is set by a flag.
+var encryptionKeyFile = os.Getenv("WHISKY_KEY_FILE")
+
+// recordCipher encrypts records. It is nil when encryption is off.
+var recordCipher cipher.AEAD
+
+// encryptionCheck is a record encrypted with the key, to tell a wrong key.
+const encryptionCheck = "whisky"
+
+// setupEncryption derives the key when it is given, and checks it
+// against the database. It fails when the database is encrypted but no key is given.
+func setupEncryption() error {
+	secret := []byte(os.Getenv("WHISKY_PASSPHRASE"))
+	if encryptionKeyFile != "" {
+		var err error
+		secret, err = os.ReadFile(encryptionKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("settings"))
+		check := b.Get([]byte("encryption-check"))
+		if len(secret) == 0 {
+			if check != nil {
+				return errors.New("whisky.db is encrypted. give the key with WHISKY_PASSPHRASE or -encryption-key-file")
+			}
+			recordCipher = nil
+			return nil
+		}
+		salt := b.Get([]byte("encryption-salt"))
+		if salt == nil {
+			salt = make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("encryption-salt"), salt); err != nil {
+				return err
+			}
+		}
+		key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			plain, err := openRecord(aead, check)
+			if err != nil || string(plain) != encryptionCheck {
+				return errors.New("wrong encryption key for whisky.db")
+			}
+		} else {
+			sealed, err := sealRecord(aead, []byte(encryptionCheck))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("encryption-check"), sealed); err != nil {
+				return err
+			}
+		}
+		recordCipher = aead
+		return nil
+	})
+}
+
+func sealRecord(aead cipher.AEAD, bs []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{recordEncrypted}, nonce...)
+	return aead.Seal(out, nonce, bs, nil), nil
+}
+
+func openRecord(aead cipher.AEAD, bs []byte) ([]byte, error) {
+	if len(bs) < 1+aead.NonceSize() || bs[0] != recordEncrypted {
+		return nil, errors.New("not an encrypted record")
+	}
+	nonce, sealed := bs[1:1+aead.NonceSize()], bs[1+aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+// encryptRecord encrypts the encoded record when encryption is on.
+func encryptRecord(bs []byte) ([]byte, error) {
+	if recordCipher == nil {
+		return bs, nil
+	}
+	return sealRecord(recordCipher, bs)
+}
+
+// decryptRecord returns the encoded record in the encrypted record.
+func decryptRecord(bs []byte) ([]byte, error) {
+	if recordCipher == nil {
+		return nil, errors.New("the record is encrypted, but no key is given")
+	}
+	plain, err := openRecord(recordCipher, bs)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt: %v", err)
+	}
+	return plain, nil
+}
+
+// encodePrivate encodes a record with contents of a page (revisions, drafts, pending edits),
+// compressed and encrypted.
+func encodePrivate(x interface{}) ([]byte, error) {
+	bs, err := toCompressedBytes(x)
+	if err != nil {
+		return nil, err
+	}
+	return encryptRecord(bs)
+}
+
+// encodeAttachment encodes an attachment, encrypted.
+// it is not compressed, as most attachments (images, pdfs, ...) are compressed already.
+func encodeAttachment(x interface{}) ([]byte, error) {
+	bs, err := toBytes(x)
+	if err != nil {
+		return nil, err
+	}
+	return encryptRecord(bs)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// kinds of errors those users can get.
+// errors from storage and handlers wrap one of them,
+// so they can be checked with errors.Is and replied with the right status.
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("conflict")
+	ErrForbidden = errors.New("forbidden")
+	ErrTooLarge  = errors.New("too large")
+	ErrInvalid   = errors.New("invalid request")
+	// ErrCorrupted is for data which could not be read from the storage.
+	// it is an internal error, but has a message for operators.
+	ErrCorrupted = errors.New("corrupted")
+)
+
+// kindError is an error of a kind with it's own message.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+// newError returns an error of the kind, with a formatted message.
+func newError(kind error, format string, a ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, a...)}
+}
+
+var (
+	errPageNotExists    = newError(ErrNotFound, "page not exists")
+	errCommentNotExists = newError(ErrNotFound, "comment not exists")
+	errProtected        = newError(ErrForbidden, "this page is protected")
+)
+
+// errorStatus returns http status code for the error.
+// An error which is not one of the kinds is an internal error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// httpError replies the error with it's status code,
+// and the id of the request for users to report it.
+func httpError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	p := &ErrorPage{Status: status, Message: err.Error()}
+	p.Reference = logServerError(w, status, err)
+	if errors.Is(err, errPageNotExists) {
+		if m := validPath.FindStringSubmatch(r.URL.Path); m != nil {
+			p.Missing = m[2]
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				findMissingPage(r.Context(), p)
+			}
+		}
+	}
+	renderError(w, r, p)
+}
+
+// findMissingPage finds the pages similar to the missing page, and linking to it.
+// the error page is shown without them, when they could not be found.
+func findMissingPage(ctx context.Context, p *ErrorPage) {
+	var err error
+	if p.Similar, err = similarTitles(ctx, p.Missing); err != nil {
+		slog.Warn("could not find similar titles", "title", p.Missing, "err", err)
+	}
+	if p.Backlinks, err = pagesLinkingTo(ctx, p.Missing); err != nil {
+		slog.Warn("could not find backlinks", "title", p.Missing, "err", err)
+	}
+}
+
+// notFound replies 404 to a path the wiki doesn't serve.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, &ErrorPage{Status: http.StatusNotFound, Message: "page not found", Reference: w.Header().Get(requestIDHeader)})
+}
+
+// renderError replies the error page of the wiki (404.html for 404, 500.html for others)
+// to browsers, and plain text to other clients like curl.
+func renderError(w http.ResponseWriter, r *http.Request, p *ErrorPage) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		tmpl := "500"
+		if p.Status == http.StatusNotFound {
+			tmpl = "404"
+		}
+		data, err := executeTemplate(r, tmpl, p)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(p.Status)
+			w.Write(data)
+			return
+		}
+		// the template is broken or missing. plain text is better than nothing.
+		slog.Warn("could not render error page", "err", err)
+	}
+	msg := p.Message
+	if p.Reference != "" {
+		msg += "\nreference: " + p.Reference
+	}
+	http.Error(w, msg, p.Status)
+}
+
+// logServerError logs errors those are not users' fault, like a storage failure.
+// it returns the id of the request, which is in the response header already.
+func logServerError(w http.ResponseWriter, status int, err error) string {
+	ref := w.Header().Get(requestIDHeader)
+	if status == http.StatusInternalServerError {
+		slog.Error("request failed", "err", err, "request_id", ref)
+	}
+	return ref
+}
+
+// ErrorPage is a page of an error which happened while serving a request.
+type ErrorPage struct {
+	// Title is empty, as it's not a page.
+	Title   string
+	Status  int
+	Message string
+	// Reference is the id of the request, for users to report the error.
+	Reference string
+	// Missing is title of the page which doesn't exist, to create it.
+	Missing string
+	// Similar are titles which look like the missing page, for a typo.
+	Similar []string
+	// Backlinks are the pages linking to the missing page.
+	Backlinks []string
+}
+
+func (p *ErrorPage) StatusText() string {
+	return http.StatusText(p.Status)
+}
+
+// withRecovery serves requests to h, and replies an error page when h panics,
+// rather than cutting the connection with a blank response.
+// the stack is logged with the request id, which is shown to the user.
+func withRecovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// net/http aborts the response quietly with it.
+				panic(v)
+			}
+			ref := requestID(r)
+			slog.Error("panic while serving a request", "err", v, "method", r.Method, "path", r.URL.Path, "request_id", ref, "stack", string(debug.Stack()))
+			if rw.started {
+				// too late to reply an error. cut it, not to look complete.
+				panic(http.ErrAbortHandler)
+			}
+			renderError(w, r, &ErrorPage{Status: http.StatusInternalServerError, Message: "internal server error", Reference: ref})
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// recoveryWriter remembers whether the response is started.
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryWriter) Write(bs []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(bs)
+}
+
+func (w *recoveryWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		f.Flush()
+	}
+}
+
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// export writes the whole wiki as a tar.gz archive, for backups
+// and moving the wiki to other places.
+//
+//	pages/<titleFilename>           latest revision of a page as markdown
+//	attachments/<page path>/<name>  attachments of a page
+//	manifest.json                   revisions and attachments of pages
+//
+// pages directory can be imported back with `whisky import`.
+
+type ExportManifest struct {
+	Exported time.Time     `json:"exported"`
+	License  *APILicense   `json:"license,omitempty"`
+	Pages    []*ExportPage `json:"pages"`
+}
+
+type ExportPage struct {
+	Title string `json:"title"`
+	// File is path of the page in the archive.
+	// It is empty for a page which only has attachments.
+	File        string              `json:"file,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+	Revisions   []Revision          `json:"revisions"`
+	Attachments []*ExportAttachment `json:"attachments,omitempty"`
+}
+
+type ExportAttachment struct {
+	Name        string    `json:"name"`
+	File        string    `json:"file"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Uploaded    time.Time `json:"uploaded"`
+	By          string    `json:"by"`
+}
+
+// writeExport writes the archive of the wiki to w.
+// entries are written as the database is read, so a large wiki doesn't have to fit in memory.
+// only the manifest is kept until the end, in a temporary file.
+func writeExport(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	writeFile := func(name string, data []byte, mod time.Time) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: mod, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	titles, err := listTitles(ctx)
+	if err != nil {
+		return err
+	}
+	hasPage := make(map[string]bool)
+	for _, t := range titles {
+		hasPage[t] = true
+	}
+	atitles, err := attachmentTitles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range atitles {
+		if !hasPage[t] {
+			titles = append(titles, t)
+		}
+	}
+	sort.Strings(titles)
+
+	m := &ExportManifest{Exported: time.Now(), License: siteLicense(), Pages: []*ExportPage{}}
+	mw, err := newManifestWriter(m)
+	if err != nil {
+		return err
+	}
+	defer mw.remove()
+	for _, t := range titles {
+		ep := &ExportPage{Title: t, Revisions: []Revision{}}
+		if hasPage[t] {
+			p, err := loadPage(ctx, t)
+			if err != nil {
+				return err
+			}
+			h, err := loadHistory(ctx, t, -1, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			ep.File = "pages/" + titleFilename(t)
+			ep.Attribution = p.Attribution
+			ep.Revisions = h.Revs
+			err = writeFile(ep.File, p.Body, p.Created)
+			if err != nil {
+				return err
+			}
+		}
+		names, err := attachmentNames(ctx, t)
+		if err != nil {
+			return err
+		}
+		// one attachment is read at a time, as they can be large.
+		for _, name := range names {
+			a, err := loadAttachment(ctx, t, name)
+			if err != nil {
+				return err
+			}
+			ea := &ExportAttachment{
+				Name:        a.Name,
+				File:        "attachments/" + strings.TrimSuffix(titleFilename(t), filenameExt) + "/" + a.Name,
+				ContentType: a.ContentType,
+				Size:        a.Size(),
+				Uploaded:    a.Uploaded,
+				By:          a.By,
+			}
+			err = writeFile(ea.File, a.Data, a.Uploaded)
+			if err != nil {
+				return err
+			}
+			ep.Attachments = append(ep.Attachments, ea)
+		}
+		err = mw.add(ep)
+		if err != nil {
+			return err
+		}
+	}
+	err = mw.writeTo(tw, "manifest.json", m.Exported)
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// manifestWriter writes the manifest to a temporary file page by page,
+// in the same format as json.MarshalIndent of the whole manifest.
+type manifestWriter struct {
+	f     *os.File
+	pages int
+}
+
+// newManifestWriter starts the manifest with the fields of m other than it's pages.
+func newManifestWriter(m *ExportManifest) (*manifestWriter, error) {
+	head, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// pages are empty yet, and written by add.
+	head = bytes.TrimSuffix(head, []byte("[]\n}"))
+	f, err := os.CreateTemp("", "whisky-manifest-*.json")
+	if err != nil {
+		return nil, err
+	}
+	mw := &manifestWriter{f: f}
+	_, err = f.Write(append(head, '['))
+	if err != nil {
+		mw.remove()
+		return nil, err
+	}
+	return mw, nil
+}
+
+func (mw *manifestWriter) add(ep *ExportPage) error {
+	bs, err := json.MarshalIndent(ep, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n    "
+	if mw.pages == 0 {
+		sep = "\n    "
+	}
+	mw.pages++
+	_, err = mw.f.Write(append([]byte(sep), bs...))
+	return err
+}
+
+// writeTo finishes the manifest, and writes it to the archive.
+func (mw *manifestWriter) writeTo(tw *tar.Writer, name string, mod time.Time) error {
+	tail := "\n  ]\n}"
+	if mw.pages == 0 {
+		tail = "]\n}"
+	}
+	_, err := mw.f.WriteString(tail)
+	if err != nil {
+		return err
+	}
+	size, err := mw.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = mw.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: mod, Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, mw.f)
+	return err
+}
+
+func (mw *manifestWriter) remove() {
+	mw.f.Close()
+	os.Remove(mw.f.Name())
+}
+
+func exportFilename() string {
+	return "whisky-export-" + time.Now().Format("20060102") + ".tar.gz"
+}
+
+// exportCommand writes the archive to a file, or stdout with -o -.
+func exportCommand(fs *flag.FlagSet, args []string) (err error) {
+	out := fs.String("o", exportFilename(), "output file. - for stdout")
+	parseArgs(fs, args, "[flags]", -1)
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+	err = loadSettings()
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		return writeExport(context.Background(), os.Stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = writeExport(context.Background(), f)
+	if err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported to %s\n", *out)
+	return nil
+}
+
+// exportHandler lets admins download the archive.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename()+`"`)
+	// a large wiki takes longer than the timeouts of requests.
+	r, cancel := withoutTimeouts(w, r)
+	defer cancel()
+	err := writeExport(r.Context(), w)
+	if err != nil {
+		// the response is already started, the client will get a truncated archive.
+		slog.Error("could not export", "err", err)
+	}
+}
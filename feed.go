@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// atom feed types. only fields we need are defined.
+// see https://tools.ietf.org/html/rfc4287
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Author  AtomAuthor `xml:"author"`
+	Link    AtomLink   `xml:"link"`
+	Summary string     `xml:"summary,omitempty"`
+}
+
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+func siteURL(r *http.Request) string {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	if baseSite != "" {
+		return baseSite + basePath
+	}
+	return scheme + "://" + r.Host + basePath
+}
+
+func historyFeed(h *HistoryPage, site string) *AtomFeed {
+	pageURL := site + "/view/" + url.PathEscape(h.Title)
+	f := &AtomFeed{
+		ID:    pageURL,
+		Title: h.Title + " - history",
+		Links: []AtomLink{
+			{Rel: "self", Href: site + "/history/" + url.PathEscape(h.Title) + ".atom"},
+			{Rel: "alternate", Href: site + "/history/" + url.PathEscape(h.Title)},
+		},
+	}
+	for _, rev := range h.Revs {
+		revURL := pageURL + "?rev=" + strconv.Itoa(rev.Num)
+		f.Entries = append(f.Entries, AtomEntry{
+			ID:      revURL,
+			Title:   h.Title + " (rev " + strconv.Itoa(rev.Num) + ")",
+			Updated: rev.Created.UTC().Format(time.RFC3339),
+			Author:  AtomAuthor{Name: rev.Author},
+			Link:    AtomLink{Href: revURL},
+			Summary: rev.Summary,
+		})
+	}
+	// revisions are sorted from the latest one.
+	if len(h.Revs) != 0 {
+		f.Updated = f.Entries[0].Updated
+	} else {
+		f.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return f
+}
+
+func historyFeedHandler(w http.ResponseWriter, r *http.Request, title string) {
+	h, err := loadHistory(r.Context(), title, -1, 20)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(historyFeed(h, siteURL(r)))
+	if err != nil {
+		httpError(w, r, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// page titles are arbitrary strings, but backends that write pages as files
+// (git, plain filesystem, exports) need names that are safe on every
+// filesystem and can be turned back into the titles.
+//
+// titleFilename maps a title to a slash separated relative path ending with ".md".
+// every segment of a title ("A/B" has two) becomes a directory or file name,
+// and the mapping is reversible by filenameTitle.
+//
+// the rules are
+//
+//   - unsafe runes are escaped to %XX of their utf-8 bytes.
+//     they are control characters, <>:"/\|?*, the escape char % itself,
+//     and the case marker ~. leading and trailing spaces and dots are escaped too.
+//   - when the segment is a reserved device name on windows (CON, NUL, COM1, ...)
+//     its first rune is escaped.
+//   - segments are written with their case preserved. to prevent collisions on
+//     case insensitive filesystems ("Home" and "home"), a segment that is not
+//     in title case gets ~<hex> suffix which tells its case pattern.
+//     ex) "Home" -> "Home", "home" -> "home~0", "HOME" -> "HOME~f"
+//   - when a title has an empty segment ("A//B" or "/A"), its slashes are
+//     escaped so the whole title becomes a single file.
+
+const filenameExt = ".md"
+
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+func titleFilename(title string) string {
+	segs := strings.Split(title, "/")
+	for _, s := range segs {
+		if s == "" {
+			// cannot make a directory without a name.
+			segs = []string{title}
+			break
+		}
+	}
+	for i, s := range segs {
+		segs[i] = encodeSegment(s)
+	}
+	return strings.Join(segs, "/") + filenameExt
+}
+
+func filenameTitle(name string) (string, error) {
+	if !strings.HasSuffix(name, filenameExt) {
+		return "", fmt.Errorf("not a page file: %s", name)
+	}
+	name = strings.TrimSuffix(name, filenameExt)
+	segs := strings.Split(name, "/")
+	for i, s := range segs {
+		d, err := decodeSegment(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid page file name %q: %v", name, err)
+		}
+		segs[i] = d
+	}
+	return strings.Join(segs, "/"), nil
+}
+
+func encodeSegment(seg string) string {
+	rs := []rune(seg)
+	reserved := windowsReserved[strings.ToLower(strings.SplitN(seg, ".", 2)[0])]
+	b := &strings.Builder{}
+	for i, r := range rs {
+		escape := false
+		switch {
+		case r < 0x20 || r == 0x7f:
+			escape = true
+		case strings.ContainsRune(`<>:"/\|?*%~`, r):
+			escape = true
+		case (r == ' ' || r == '.') && (i == 0 || i == len(rs)-1):
+			escape = true
+		case i == 0 && reserved:
+			escape = true
+		case r == utf8.RuneError:
+			escape = true
+		}
+		if !escape {
+			b.WriteRune(r)
+			continue
+		}
+		buf := make([]byte, utf8.UTFMax)
+		n := utf8.EncodeRune(buf, r)
+		for _, c := range buf[:n] {
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+	if !isTitleCase(rs) {
+		b.WriteString("~" + caseMask(rs))
+	}
+	return b.String()
+}
+
+func decodeSegment(seg string) (string, error) {
+	if i := strings.Index(seg, "~"); i != -1 {
+		// the case mask is only for avoiding collisions.
+		// the letters already have their cases.
+		seg = seg[:i]
+	}
+	if seg == "" {
+		return "", errors.New("empty segment")
+	}
+	bs := make([]byte, 0, len(seg))
+	for i := 0; i < len(seg); i++ {
+		if seg[i] != '%' {
+			bs = append(bs, seg[i])
+			continue
+		}
+		if i+2 >= len(seg) {
+			return "", errors.New("incomplete escape")
+		}
+		var c byte
+		_, err := fmt.Sscanf(seg[i+1:i+3], "%02X", &c)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape: %s", seg[i:i+3])
+		}
+		bs = append(bs, c)
+		i += 2
+	}
+	if !utf8.Valid(bs) {
+		return "", errors.New("invalid utf-8")
+	}
+	return string(bs), nil
+}
+
+func isCased(r rune) bool {
+	return unicode.ToLower(r) != unicode.ToUpper(r)
+}
+
+// isTitleCase reports whether every word of the runes starts with
+// an upper case letter, and the others are lower case.
+// ex) "Home", "Meeting Notes", "2018 Plan"
+func isTitleCase(rs []rune) bool {
+	wordStart := true
+	for _, r := range rs {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			wordStart = true
+			continue
+		}
+		if isCased(r) {
+			if wordStart != unicode.IsUpper(r) {
+				return false
+			}
+		}
+		wordStart = false
+	}
+	return true
+}
+
+// caseMask returns hex string of bits those tell which cased runes are upper case.
+// lowest bit is for the first cased rune.
+func caseMask(rs []rune) string {
+	mask := new(big.Int)
+	i := 0
+	for _, r := range rs {
+		if !isCased(r) {
+			continue
+		}
+		if unicode.IsUpper(r) {
+			mask.SetBit(mask, i, 1)
+		}
+		i++
+	}
+	return mask.Text(16)
+}
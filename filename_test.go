@@ -0,0 +1,190 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var filenameTitles = []string{
+	"Home",
+	"home",
+	"HOME",
+	"hOmE",
+	"Home/Page",
+	"home/page",
+	"HOME/PAGE",
+	"Meeting Notes",
+	"meeting notes",
+	"Meeting notes",
+	"meeting Notes",
+	"2018 Plan",
+	"A/B",
+	"a/b",
+	"Project/Plan/2024",
+	"A//B",
+	"/A",
+	"A/",
+	"/",
+	"//",
+	"A/ /B",
+	"CON",
+	"con",
+	"Con.txt",
+	"NUL",
+	"nul/Log",
+	"Docs/COM1",
+	"LPT9.md",
+	"AUX.tar.gz",
+	"Console",
+	"PRN",
+	"com9",
+	"Lpt1.txt",
+	"Nul.",
+	"COM10",
+	"CONSOLE",
+	"trailing.",
+	"trailing ",
+	" leading",
+	".leading",
+	"Dir./File",
+	"Dir /File ",
+	".",
+	"..",
+	"...",
+	".hidden",
+	"a./b",
+	"..x..",
+	"a<b>c:d\"e\\f|g?h*i",
+	"100%",
+	"%41",
+	"a~b",
+	"~0",
+	"tab\there",
+	"한글 문서",
+	"위키/대문",
+	"日本語のページ",
+	"Ünïcödé",
+	"ÜNÏCÖDÉ",
+	"emoji 🍀",
+	"ǅungla",
+}
+
+func TestFilenameRoundTrip(t *testing.T) {
+	for _, title := range filenameTitles {
+		name := titleFilename(title)
+		got, err := filenameTitle(name)
+		if err != nil {
+			t.Errorf("filenameTitle(%q) of %q: %v", name, title, err)
+			continue
+		}
+		if got != title {
+			t.Errorf("%q -> %q -> %q", title, name, got)
+		}
+	}
+}
+
+func TestFilenameCaseCollisions(t *testing.T) {
+	seen := map[string]string{}
+	for _, title := range filenameTitles {
+		name := strings.ToLower(titleFilename(title))
+		if other, ok := seen[name]; ok {
+			t.Errorf("%q and %q collide on a case insensitive filesystem as %q", other, title, name)
+		}
+		seen[name] = title
+	}
+}
+
+func TestFilenameCasePairs(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"Home", "home"},
+		{"Home", "HOME"},
+		{"home", "hOME"},
+		{"Meeting Notes", "Meeting notes"},
+		{"Home/Page", "home/Page"},
+		{"Ünïcödé", "ünïcödé"},
+	} {
+		a, b := titleFilename(pair[0]), titleFilename(pair[1])
+		if strings.EqualFold(a, b) {
+			t.Errorf("%q and %q collide as %q and %q", pair[0], pair[1], a, b)
+		}
+	}
+}
+
+func TestFilenameSafeSegments(t *testing.T) {
+	for _, title := range filenameTitles {
+		name := titleFilename(title)
+		for _, seg := range strings.Split(strings.TrimSuffix(name, filenameExt), "/") {
+			if seg == "" {
+				t.Errorf("%q -> %q has an empty segment", title, name)
+				continue
+			}
+			if strings.ContainsAny(seg, `<>:"\|?*`) {
+				t.Errorf("%q -> %q has an unsafe rune in %q", title, name, seg)
+			}
+			for _, r := range seg {
+				if r < 0x20 || r == 0x7f {
+					t.Errorf("%q -> %q has a control character in %q", title, name, seg)
+				}
+			}
+			if strings.HasSuffix(seg, " ") || strings.HasSuffix(seg, ".") {
+				t.Errorf("%q -> %q has a segment ending with a space or a dot: %q", title, name, seg)
+			}
+			// windows ignores extensions and the case for device names.
+			base := strings.ToLower(strings.SplitN(strings.SplitN(seg, "~", 2)[0], ".", 2)[0])
+			if windowsReserved[base] {
+				t.Errorf("%q -> %q has a reserved device name: %q", title, name, seg)
+			}
+		}
+	}
+}
+
+func TestFilenameSegments(t *testing.T) {
+	tests := []struct {
+		title string
+		name  string
+	}{
+		{"Home", "Home.md"},
+		{"home", "home~0.md"},
+		{"HOME", "HOME~f.md"},
+		{"A/B", "A/B.md"},
+		{"A//B", "A%2F%2FB.md"},
+		{"Home/Page", "Home/Page.md"},
+		{"home/page", "home~0/page~0.md"},
+		{"/A", "%2FA.md"},
+		{"A/", "A%2F.md"},
+		{"//", "%2F%2F.md"},
+		{"CON", "%43ON~7.md"},
+		{"PRN", "%50RN~7.md"},
+		{"com9", "%63om9~0.md"},
+		{"Lpt1.txt", "%4Cpt1.txt~1.md"},
+		{"Nul.", "%4Eul%2E.md"},
+		{"COM10", "COM10~7.md"},
+		{"trailing.", "trailing%2E~0.md"},
+		{".", "%2E.md"},
+		{"..", "%2E%2E.md"},
+		{".hidden", "%2Ehidden~0.md"},
+		{"a./b", "a%2E~0/b~0.md"},
+		{"한글", "한글.md"},
+	}
+	for _, tt := range tests {
+		if got := titleFilename(tt.title); got != tt.name {
+			t.Errorf("titleFilename(%q) = %q, want %q", tt.title, got, tt.name)
+		}
+	}
+}
+
+func TestFilenameTitleInvalid(t *testing.T) {
+	for _, name := range []string{
+		"Home.txt",
+		".md",
+		"A//B.md",
+		"100%.md",
+		"%4.md",
+		"%ZZ.md",
+		"%FF.md",
+	} {
+		if title, err := filenameTitle(name); err == nil {
+			t.Errorf("filenameTitle(%q) = %q, want an error", name, title)
+		}
+	}
+}
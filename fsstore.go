@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fsStore keeps pages as plain markdown files in a directory,
+// so they can be searched with grep and backed up with rsync.
+//
+//	<dir>/<titleFilename>                    latest revision of a page
+//	<dir>/.history/<page path>~/<n>.md       revisions of the page
+//	<dir>/.history/<page path>~/index.json   metadata of the revisions
+//
+// history directories end with ~, which is never at the end of an encoded
+// title segment, so they don't collide with directories of sub pages.
+type fsStore struct {
+	dir string
+	// mu serializes writes. a page's files are written one by one,
+	// so readers could see a revision without it's index entry for a moment,
+	// which is just treated as not exists yet.
+	mu sync.Mutex
+}
+
+// fsRevision is an entry of index.json.
+type fsRevision struct {
+	Num         uint64      `json:"num"`
+	Created     time.Time   `json:"created"`
+	Author      string      `json:"author"`
+	Summary     string      `json:"summary,omitempty"`
+	Attribution string      `json:"attribution,omitempty"`
+	Provenance  *Provenance `json:"provenance,omitempty"`
+}
+
+const fsHistoryDir = ".history"
+
+func openFSStore(dir string) (*fsStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Join(dir, fsHistoryDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &fsStore{dir: dir}, nil
+}
+
+func (s *fsStore) pagePath(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(titleFilename(title)))
+}
+
+func (s *fsStore) historyPath(title string) string {
+	name := strings.TrimSuffix(titleFilename(title), filenameExt) + "~"
+	return filepath.Join(s.dir, fsHistoryDir, filepath.FromSlash(name))
+}
+
+func (s *fsStore) revisionPath(title string, n uint64) string {
+	return filepath.Join(s.historyPath(title), strconv.FormatUint(n, 10)+filenameExt)
+}
+
+// index returns metadata of revisions of the page, oldest first.
+func (s *fsStore) index(title string) ([]fsRevision, error) {
+	data, err := os.ReadFile(filepath.Join(s.historyPath(title), "index.json"))
+	if os.IsNotExist(err) {
+		return nil, errPageNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	revs := []fsRevision{}
+	err = json.Unmarshal(data, &revs)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, errPageNotExists
+	}
+	return revs, nil
+}
+
+// writeFileAtomic writes the file atomically, so readers don't see a half written file.
+func writeFileAtomic(fpath string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := fpath + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fpath)
+}
+
+func (s *fsStore) Save(ctx context.Context, p *Page) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, err := s.index(p.Title)
+	if err != nil && err != errPageNotExists {
+		return 0, err
+	}
+	n := uint64(len(revs)) + 1
+	revs = append(revs, fsRevision{Num: n, Created: p.Created, Author: p.Author, Summary: p.Summary, Attribution: p.Attribution, Provenance: p.Provenance})
+	idx, err := json.MarshalIndent(revs, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	// the index is written last, it makes the revision visible.
+	err = writeFileAtomic(s.revisionPath(p.Title, n), p.Body)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomic(s.pagePath(p.Title), p.Body)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomic(filepath.Join(s.historyPath(p.Title), "index.json"), idx)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *fsStore) Titles(ctx context.Context) ([]string, error) {
+	titles := []string{}
+	err := filepath.Walk(s.dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			// .history, and others like .git.
+			// dots at the start of title segments are escaped.
+			if strings.HasPrefix(fi.Name(), ".") && fpath != s.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(s.dir, fpath)
+		if err != nil {
+			return err
+		}
+		t, err := filenameTitle(filepath.ToSlash(name))
+		if err != nil {
+			// not a page.
+			return nil
+		}
+		titles = append(titles, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+func (s *fsStore) Load(ctx context.Context, title string, id uint64) (*Page, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	revs, err := s.index(title)
+	if err != nil {
+		return nil, 0, err
+	}
+	if id == 0 {
+		id = uint64(len(revs))
+	}
+	if id > uint64(len(revs)) {
+		return nil, 0, errPageNotExists
+	}
+	body, err := os.ReadFile(s.revisionPath(title, id))
+	if err != nil {
+		return nil, 0, err
+	}
+	r := revs[id-1]
+	p := &Page{
+		Title:       title,
+		Body:        body,
+		Created:     r.Created,
+		Author:      r.Author,
+		Summary:     r.Summary,
+		Attribution: r.Attribution,
+		Provenance:  r.Provenance,
+	}
+	return p, id, nil
+}
+
+func (s *fsStore) History(ctx context.Context, title string, from, n int) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	revs, err := s.index(title)
+	if err != nil {
+		return nil, err
+	}
+	if from == -1 {
+		from = len(revs)
+	}
+	if from < 1 || from > len(revs) {
+		return nil, errPageNotExists
+	}
+	var hist []Revision
+	for i := from; i >= 1 && len(hist) < n; i-- {
+		r := revs[i-1]
+		hist = append(hist, Revision{Num: i, Created: r.Created, Author: r.Author, Summary: r.Summary, Provenance: r.Provenance})
+	}
+	return hist, nil
+}
+
+func (s *fsStore) Delete(ctx context.Context, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.index(title); err != nil {
+		return err
+	}
+	err := os.RemoveAll(s.historyPath(title))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.pagePath(title))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.removeEmptyDirs(filepath.Dir(s.pagePath(title)))
+	s.removeEmptyDirs(filepath.Dir(s.historyPath(title)))
+	return nil
+}
+
+// removeEmptyDirs removes the directory and it's parents while they are empty.
+func (s *fsStore) removeEmptyDirs(dir string) {
+	for dir != s.dir && dir != filepath.Join(s.dir, fsHistoryDir) && strings.HasPrefix(dir, s.dir) {
+		// Remove fails when the directory is not empty.
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitRepo runs git commands in a repository directory.
+// It is used by the git mirror and the git page store.
+type gitRepo struct {
+	dir string
+}
+
+// openGitRepo opens the git repository of the directory.
+// It makes a new repository when the directory is not a repository yet.
+func openGitRepo(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := &gitRepo{dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		_, err = g.run(context.Background(), nil, "", "init", "-q")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// hasHead reports whether the repository has a commit.
+func (g *gitRepo) hasHead(ctx context.Context) bool {
+	_, err := g.run(ctx, nil, "", "rev-parse", "-q", "--verify", "HEAD")
+	return err == nil
+}
+
+// writeFile writes the file at the slash separated path of the working tree.
+func (g *gitRepo) writeFile(name string, data []byte) error {
+	fpath := filepath.Join(g.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fpath, data, 0644)
+}
+
+// run runs the git command with the stdin, and returns it's output.
+func (g *gitRepo) run(ctx context.Context, env []string, stdin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	// page file names are not patterns.
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME=whisky", "GIT_COMMITTER_EMAIL=", "GIT_LITERAL_PATHSPECS=1")
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(errOut.String()+out.String()))
+	}
+	return out.String(), nil
+}
+
+// gitAuthor returns git author of the wiki user.
+// Users don't have emails in the wiki, the email is left empty.
+func gitAuthor(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' {
+			return -1
+		}
+		return r
+	}, name)
+	if strings.TrimSpace(name) == "" {
+		name = "anonymous"
+	}
+	return name + " <>"
+}
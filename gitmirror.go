@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// git mirror keeps every revision of pages as commits of a git repository,
+// as a human readable backup. pages are written at their titleFilename.
+//
+// commits are made in the order of revisions by a single goroutine.
+// when a remote is set, it pushes after every commit.
+
+type gitMirror struct {
+	repo   *gitRepo
+	remote string
+	jobs   chan *mirrorJob
+}
+
+type mirrorJob struct {
+	page *Page
+	rev  uint64
+}
+
+func startGitMirror(dir, remote string) (*gitMirror, error) {
+	repo, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &gitMirror{repo: repo, remote: remote, jobs: make(chan *mirrorJob, 1000)}
+	// a new mirror starts with the latest pages.
+	if !repo.hasHead(context.Background()) {
+		err = m.initialCommit()
+		if err != nil {
+			return nil, err
+		}
+	}
+	go m.run()
+	return m, nil
+}
+
+// Save queues the revision to be committed.
+func (m *gitMirror) Save(p *Page, rev uint64) {
+	select {
+	case m.jobs <- &mirrorJob{page: p, rev: rev}:
+	default:
+		slog.Warn("git mirror is too busy, the revision is not mirrored", "page", p.Title, "rev", rev)
+	}
+}
+
+func (m *gitMirror) run() {
+	for j := range m.jobs {
+		start := time.Now()
+		err := m.commit(j.page, j.rev)
+		observeJob("git-mirror", start, err)
+		if err != nil {
+			slog.Error("could not commit to git mirror", "page", j.page.Title, "rev", j.rev, "err", err)
+			continue
+		}
+		if m.remote != "" {
+			start := time.Now()
+			_, err = m.git(nil, "", "push", "-q", m.remote, "HEAD")
+			observeJob("git-mirror-push", start, err)
+			if err != nil {
+				slog.Warn("could not push git mirror", "err", err)
+			}
+		}
+	}
+}
+
+func (m *gitMirror) writePage(p *Page) (string, error) {
+	name := titleFilename(p.Title)
+	return name, m.repo.writeFile(name, p.Body)
+}
+
+// git runs the git command in the mirror.
+func (m *gitMirror) git(env []string, stdin string, args ...string) (string, error) {
+	return m.repo.run(context.Background(), env, stdin, args...)
+}
+
+func (m *gitMirror) commit(p *Page, rev uint64) error {
+	name, err := m.writePage(p)
+	if err != nil {
+		return err
+	}
+	_, err = m.git(nil, "", "add", "--", name)
+	if err != nil {
+		return err
+	}
+	if _, err := m.git(nil, "", "diff", "--cached", "--quiet"); err == nil {
+		// nothing changed.
+		return nil
+	}
+	msg := p.Title + ": " + p.Summary
+	if p.Summary == "" {
+		msg = "Edit " + p.Title
+	}
+	msg += "\n\nrevision " + strconv.FormatUint(rev, 10) + " of " + p.Title + "\n"
+	env := []string{"GIT_AUTHOR_DATE=" + p.Created.Format(time.RFC3339)}
+	_, err = m.git(env, msg, "commit", "-q", "--author", gitAuthor(p.Author), "-F", "-")
+	return err
+}
+
+func (m *gitMirror) initialCommit() error {
+	titles, err := listTitles(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, t := range titles {
+		p, err := loadPage(context.Background(), t)
+		if err != nil {
+			return err
+		}
+		if _, err := m.writePage(p); err != nil {
+			return err
+		}
+	}
+	_, err = m.git(nil, "", "add", "-A")
+	if err != nil {
+		return err
+	}
+	_, err = m.git(nil, "Mirror pages of the wiki\n", "commit", "-q", "--allow-empty", "--author", gitAuthor("whisky"), "-F", "-")
+	return err
+}
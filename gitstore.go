@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitStore keeps pages as files of a git repository, at their titleFilename.
+// every revision is a commit, so changes of the wiki can be reviewed
+// with git tools, and commits made outside of the wiki become revisions too.
+//
+// revisions of a page are commits those changed the page file
+// after it was deleted last time, numbered from the oldest.
+//
+// commit messages have trailers for fields of a page which git doesn't have.
+//
+//	Whisky-Summary: fix typos
+//	Whisky-Attribution: ...
+//	Whisky-Source-URL: ...
+//
+// a commit without the trailers (made outside of the wiki) uses it's subject as the summary.
+type gitStore struct {
+	repo *gitRepo
+	// mu serializes commits, as they share the index of the repository.
+	mu sync.Mutex
+}
+
+func openGitStore(dir string) (*gitStore, error) {
+	repo, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &gitStore{repo: repo}, nil
+}
+
+const gitTrailerPrefix = "Whisky-"
+
+// gitRev is a commit which changed a page file.
+type gitRev struct {
+	hash    string
+	author  string
+	created time.Time
+	msg     string
+}
+
+// revisions returns revisions of the page, oldest first.
+func (s *gitStore) revisions(ctx context.Context, title string) ([]gitRev, error) {
+	if !s.repo.hasHead(ctx) {
+		return nil, ctx.Err()
+	}
+	out, err := s.repo.run(ctx, nil, "", "log", "--no-renames", "--name-status", "--format=%x1e%H%x00%an%x00%at%x00%B%x00", "--", titleFilename(title))
+	if err != nil {
+		return nil, err
+	}
+	revs := []gitRev{}
+	for _, rec := range strings.Split(out, "\x1e") {
+		f := strings.SplitN(rec, "\x00", 5)
+		if len(f) != 5 {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(f[4]), "D") {
+			// the page was deleted, older commits are not of this page.
+			break
+		}
+		at, _ := strconv.ParseInt(f[2], 10, 64)
+		revs = append(revs, gitRev{hash: f[0], author: f[1], created: time.Unix(at, 0), msg: f[3]})
+	}
+	// git log is latest first.
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+	return revs, nil
+}
+
+// page returns the page of the revision without it's body.
+func (r gitRev) page(title string) *Page {
+	p := &Page{Title: title, Created: r.created, Author: r.author}
+	trailers := make(map[string]string)
+	for _, l := range strings.Split(r.msg, "\n") {
+		if !strings.HasPrefix(l, gitTrailerPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(l, gitTrailerPrefix), ": ", 2)
+		if len(kv) == 2 {
+			trailers[kv[0]] = kv[1]
+		}
+	}
+	if _, ok := trailers["Title"]; ok {
+		p.Summary = trailers["Summary"]
+	} else {
+		p.Summary = strings.SplitN(r.msg, "\n", 2)[0]
+	}
+	p.Attribution = trailers["Attribution"]
+	if trailers["Source-URL"] != "" || trailers["Source-Author"] != "" || trailers["Source-License"] != "" {
+		p.Provenance = &Provenance{SourceURL: trailers["Source-URL"], Author: trailers["Source-Author"], License: trailers["Source-License"]}
+	}
+	return p
+}
+
+// commitMessage returns the commit message for the page.
+func commitMessage(p *Page) string {
+	oneLine := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	msg := "Edit " + p.Title
+	if p.Summary != "" {
+		msg = p.Title + ": " + oneLine(p.Summary)
+	}
+	msg += "\n\n"
+	trailer := func(k, v string) {
+		if v = oneLine(v); v != "" {
+			msg += gitTrailerPrefix + k + ": " + v + "\n"
+		}
+	}
+	trailer("Title", p.Title)
+	trailer("Summary", p.Summary)
+	trailer("Attribution", p.Attribution)
+	if pv := p.Provenance; pv != nil {
+		trailer("Source-URL", pv.SourceURL)
+		trailer("Source-Author", pv.Author)
+		trailer("Source-License", pv.License)
+	}
+	return msg
+}
+
+// Save commits the page. When the body is not changed, git doesn't make a commit,
+// and the latest revision is returned instead of a new one.
+func (s *gitStore) Save(ctx context.Context, p *Page) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := titleFilename(p.Title)
+	err := s.repo.writeFile(name, p.Body)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.repo.run(ctx, nil, "", "add", "--", name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.repo.run(ctx, nil, "", "diff", "--cached", "--quiet", "--", name); err != nil {
+		env := []string{"GIT_AUTHOR_DATE=" + p.Created.Format(time.RFC3339)}
+		_, err = s.repo.run(ctx, env, commitMessage(p), "commit", "-q", "--author", gitAuthor(p.Author), "-F", "-", "--", name)
+		if err != nil {
+			return 0, err
+		}
+	}
+	revs, err := s.revisions(ctx, p.Title)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(revs)), nil
+}
+
+func (s *gitStore) Titles(ctx context.Context) ([]string, error) {
+	titles := []string{}
+	if !s.repo.hasHead(ctx) {
+		return titles, ctx.Err()
+	}
+	out, err := s.repo.run(ctx, nil, "", "ls-tree", "-r", "-z", "--name-only", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(out, "\x00") {
+		t, err := filenameTitle(name)
+		if err != nil {
+			// not a page, like README of the repository.
+			continue
+		}
+		titles = append(titles, t)
+	}
+	return titles, nil
+}
+
+func (s *gitStore) Load(ctx context.Context, title string, id uint64) (*Page, uint64, error) {
+	revs, err := s.revisions(ctx, title)
+	if err != nil {
+		return nil, 0, err
+	}
+	if id == 0 {
+		id = uint64(len(revs))
+	}
+	if id == 0 || id > uint64(len(revs)) {
+		return nil, 0, errPageNotExists
+	}
+	r := revs[id-1]
+	body, err := s.repo.run(ctx, nil, "", "cat-file", "blob", r.hash+":"+titleFilename(title))
+	if err != nil {
+		return nil, 0, err
+	}
+	p := r.page(title)
+	p.Body = []byte(body)
+	return p, id, nil
+}
+
+func (s *gitStore) History(ctx context.Context, title string, from, n int) ([]Revision, error) {
+	revs, err := s.revisions(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	if from == -1 {
+		from = len(revs)
+	}
+	if from < 1 || from > len(revs) {
+		return nil, errPageNotExists
+	}
+	var hist []Revision
+	for i := from; i >= 1 && len(hist) < n; i-- {
+		p := revs[i-1].page(title)
+		hist = append(hist, Revision{Num: i, Created: p.Created, Author: p.Author, Summary: p.Summary, Provenance: p.Provenance})
+	}
+	return hist, nil
+}
+
+func (s *gitStore) Delete(ctx context.Context, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := titleFilename(title)
+	if !s.repo.hasHead(ctx) {
+		return errPageNotExists
+	}
+	if _, err := s.repo.run(ctx, nil, "", "cat-file", "-e", "HEAD:"+name); err != nil {
+		return errPageNotExists
+	}
+	_, err := s.repo.run(ctx, nil, "", "rm", "-q", "--", name)
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.run(ctx, nil, "Delete "+title+"\n", "commit", "-q", "--author", gitAuthor("whisky"), "-F", "-", "--", name)
+	return err
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kybin/whisky/whiskypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpc api is served with -grpc-addr flag.
+// it uses the same storage and api tokens with the http api.
+// see whiskypb/whisky.proto for the definition.
+//
+// after changing whisky.proto, regenerate the code with
+//
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative whiskypb/whisky.proto
+
+type grpcPages struct {
+	whiskypb.UnimplementedPagesServer
+}
+
+func serveGRPC(addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	whiskypb.RegisterPagesServer(s, &grpcPages{})
+	return s.Serve(l)
+}
+
+// grpcError converts the error to a grpc status error.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrConflict):
+		code = codes.AlreadyExists
+	case errors.Is(err, ErrForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, ErrTooLarge):
+		code = codes.ResourceExhausted
+	case errors.Is(err, ErrInvalid):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrCorrupted):
+		code = codes.DataLoss
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcUser returns the user of the api token in the metadata.
+func grpcUser(ctx context.Context) (*User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 || !strings.HasPrefix(auth[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "api token is needed")
+	}
+	u, err := loadTokenUser(strings.TrimSpace(strings.TrimPrefix(auth[0], "Bearer ")))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid api token")
+	}
+	return u, nil
+}
+
+func toPBProvenance(p *Provenance) *whiskypb.Provenance {
+	if p == nil {
+		return nil
+	}
+	return &whiskypb.Provenance{SourceUrl: p.SourceURL, Author: p.Author, License: p.License}
+}
+
+func toPBPage(p *Page, rev uint64) *whiskypb.Page {
+	pb := &whiskypb.Page{
+		Title:       p.Title,
+		Revision:    rev,
+		Body:        string(p.Body),
+		Created:     timestamppb.New(p.Created),
+		Author:      p.Author,
+		Summary:     p.Summary,
+		Attribution: p.Attribution,
+		Provenance:  toPBProvenance(p.Provenance),
+	}
+	if l := siteLicense(); l != nil {
+		pb.License = &whiskypb.License{Name: l.Name, Url: l.URL}
+	}
+	return pb
+}
+
+func (s *grpcPages) GetPage(ctx context.Context, req *whiskypb.GetPageRequest) (*whiskypb.Page, error) {
+	p, rev, err := loadRevision(ctx, req.Title, req.Revision)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toPBPage(p, rev), nil
+}
+
+func (s *grpcPages) PutPage(ctx context.Context, req *whiskypb.PutPageRequest) (*whiskypb.PutPageResponse, error) {
+	u, err := grpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Title == "" {
+		return nil, grpcError(newError(ErrInvalid, "page title is missing"))
+	}
+	if len(req.Body) > maxPageSize {
+		return nil, grpcError(pageTooLarge())
+	}
+	prot, err := loadProtection(req.Title)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !prot.CanEdit(u) {
+		return nil, grpcError(errProtected)
+	}
+	_, prevErr := loadPage(ctx, req.Title)
+	if prevErr != nil && !errors.Is(prevErr, ErrNotFound) {
+		return nil, grpcError(prevErr)
+	}
+	p := &Page{
+		Title:       req.Title,
+		Body:        []byte(strings.Replace(req.Body, "\r\n", "\n", -1)),
+		Created:     time.Now(),
+		Author:      u.Name,
+		Summary:     strings.TrimSpace(req.Summary),
+		Attribution: strings.TrimSpace(req.Attribution),
+	}
+	queued, err := submitEdit(ctx, u, p)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if queued {
+		return &whiskypb.PutPageResponse{Page: toPBPage(p, 0), Queued: true}, nil
+	}
+	p, rev, err := loadRevision(ctx, req.Title, 0)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &whiskypb.PutPageResponse{Page: toPBPage(p, rev), Created: prevErr != nil}, nil
+}
+
+func (s *grpcPages) DeletePage(ctx context.Context, req *whiskypb.DeletePageRequest) (*whiskypb.DeletePageResponse, error) {
+	u, err := grpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Admin {
+		return nil, grpcError(newError(ErrForbidden, "only admins can delete pages"))
+	}
+	err = deletePage(ctx, req.Title)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &whiskypb.DeletePageResponse{}, nil
+}
+
+func (s *grpcPages) ListRevisions(ctx context.Context, req *whiskypb.ListRevisionsRequest) (*whiskypb.ListRevisionsResponse, error) {
+	from := -1
+	if req.From != 0 {
+		from = int(req.From)
+	}
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > maxAPIRevisions {
+		limit = maxAPIRevisions
+	}
+	h, err := loadHistory(ctx, req.Title, from, limit+1)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &whiskypb.ListRevisionsResponse{}
+	for i, rev := range h.Revs {
+		if i == limit {
+			resp.Next = uint64(rev.Num)
+			break
+		}
+		resp.Revisions = append(resp.Revisions, &whiskypb.Revision{
+			Num:        uint64(rev.Num),
+			Created:    timestamppb.New(rev.Created),
+			Author:     rev.Author,
+			Summary:    rev.Summary,
+			Provenance: toPBProvenance(rev.Provenance),
+		})
+	}
+	return resp, nil
+}
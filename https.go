@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the tls config of the https server.
+// TLS 1.2 is limited to ECDHE key exchange with AEAD ciphers,
+// TLS 1.3 ciphers are not configurable and are all fine.
+func newTLSConfig(minVersion string) (*tls.Config, error) {
+	v, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tls version: %s. 1.2 or 1.3", minVersion)
+	}
+	return &tls.Config{
+		MinVersion:       v,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}, nil
+}
+
+// httpsAddress returns the address of the https server.
+// It is port 443 of the host of the http address when httpsAddr is empty.
+func httpsAddress(addr, httpsAddr string) string {
+	if httpsAddr != "" {
+		return httpsAddr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = ""
+	}
+	return net.JoinHostPort(host, "443")
+}
+
+// redirectToHTTPS redirects requests to the https server at httpsAddr.
+// the port is kept in the url when it's not 443.
+// requests came with https through a trusted proxy are served by h.
+func redirectToHTTPS(httpsAddr string, h http.Handler) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isHTTPS(r) {
+			// a proxy got it with https. redirecting it would loop forever.
+			h.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			// ipv6
+			host = "[" + host + "]"
+		}
+		if port != "" && port != "443" {
+			host += ":" + port
+		}
+		to := "https://" + host + r.URL.Path
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusTemporaryRedirect)
+	}
+}
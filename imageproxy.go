@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// image proxy serves external images in pages from the wiki's origin.
+// so readers don't hit third party servers, and https pages don't have
+// http images in them.
+//
+// proxied urls are signed with imageProxyKey, so the proxy cannot be used
+// for arbitrary urls.
+
+var (
+	imageProxy    bool
+	imageProxyKey []byte
+)
+
+const (
+	maxProxyImageSize  = 5 << 20
+	proxyImageCacheAge = 24 * time.Hour
+)
+
+func loadImageProxyKey() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("settings"))
+		key := b.Get([]byte("imageproxy-key"))
+		if key == nil {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("imageproxy-key"), key); err != nil {
+				return err
+			}
+		}
+		imageProxyKey = append([]byte{}, key...)
+		return nil
+	})
+}
+
+func imageSignature(u string) string {
+	mac := hmac.New(sha256.New, imageProxyKey)
+	mac.Write([]byte(u))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func proxiedImageURL(u string) string {
+	return "/imageproxy?url=" + url.QueryEscape(u) + "&sig=" + imageSignature(u)
+}
+
+// proxyImages replaces external image urls in the markdown tree with proxied urls.
+// note that images in raw html are not replaced.
+func proxyImages(ast *blackfriday.Node) {
+	ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || n.Type != blackfriday.Image {
+			return blackfriday.GoToNext
+		}
+		dest := string(n.LinkData.Destination)
+		if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+			n.LinkData.Destination = []byte(proxiedImageURL(dest))
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+// proxyClient refuses to connect to private networks,
+// or anyone who can edit a page could look into them.
+var proxyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+					return fmt.Errorf("not allowed address: %s", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+func fetchImage(ctx context.Context, u string) (*Image, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := proxyClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch image: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxProxyImageSize {
+		return nil, newError(ErrTooLarge, "image is too big")
+	}
+	ctype := http.DetectContentType(data)
+	if !strings.HasPrefix(ctype, "image/") {
+		return nil, newError(ErrInvalid, "not an image: %s", ctype)
+	}
+	return &Image{ContentType: ctype, Data: data, Updated: time.Now()}, nil
+}
+
+func loadCachedImage(u string) *Image {
+	var img *Image
+	db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("imagecache")).Get([]byte(u))
+		if bs != nil {
+			img = &Image{}
+			if fromBytes(bs, img) != nil {
+				// fetch it again.
+				img = nil
+			}
+		}
+		return nil
+	})
+	if img == nil || time.Since(img.Updated) > proxyImageCacheAge {
+		return nil
+	}
+	return img
+}
+
+func cacheImage(u string, img *Image) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("imagecache")), []byte(u), img)
+	})
+}
+
+func imageProxyHandler(w http.ResponseWriter, r *http.Request) {
+	u := r.URL.Query().Get("url")
+	sig := r.URL.Query().Get("sig")
+	if !hmac.Equal([]byte(sig), []byte(imageSignature(u))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	img := loadCachedImage(u)
+	countCache("imageproxy", img != nil)
+	if img == nil {
+		var err error
+		img, err = fetchImage(r.Context(), u)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		err = cacheImage(u, img)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(proxyImageCacheAge.Seconds())))
+	w.Header().Set("Content-Type", img.ContentType)
+	http.ServeContent(w, r, "", img.Updated, bytes.NewReader(img.Data))
+}
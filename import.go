@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// local commands work on the wiki in the current directory without the server.
+// they can't run while the server is running, as it locks the database.
+//
+//	whisky import [-conflict skip|overwrite|rename] [-author name] [-dashes] <dir>
+//	whisky import-mediawiki [-license text] [-author name] <dump.xml>
+//	whisky export [-o file]
+//	whisky export-html [-o dir]
+//	whisky sync [-pull] [-push] [-conflict skip|local|remote] -url <remote> -token <token>
+//	whisky migrate-db
+//	whisky compact
+//	whisky check [-repair]
+//	whisky multi [-config wikis.json]
+
+var localCommands = map[string]func(fs *flag.FlagSet, args []string) error{
+	"import":            importCommand,
+	"import-mediawiki":  importMediaWikiCommand,
+	"export":            exportCommand,
+	"export-html":       exportHTMLCommand,
+	"sync":              syncCommand,
+	"migrate-db":        migrateDBCommand,
+	"compact":           compactCommand,
+	"check":             checkCommand,
+	"multi":             multiCommand,
+	"extract-templates": extractTemplatesCommand,
+	"user":              userCommand,
+}
+
+// runLocalCommand runs the local command when args starts with one.
+// It returns false when args is not a local command.
+func runLocalCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := localCommands[args[0]]
+	if !ok {
+		return false
+	}
+	fs := flag.NewFlagSet("whisky "+args[0], flag.ExitOnError)
+	err := cmd(fs, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// openLocalDB opens the database for a local command.
+func openLocalDB() error {
+	err := openDB()
+	if err != nil {
+		return fmt.Errorf("could not open whisky.db: %v (is the wiki running?)", err)
+	}
+	// commit of every revision doesn't have to wait for the disk.
+	// the database is synced once when it is closed.
+	db.NoSync = true
+	return nil
+}
+
+func closeLocalDB() error {
+	err := db.Sync()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// importCommand imports markdown files in a directory, like a notes repository
+// or a gollum wiki. A file becomes a revision of the page titled by it's path.
+// (ex. notes/Meeting Notes.md -> notes/Meeting Notes)
+func importCommand(fs *flag.FlagSet, args []string) (err error) {
+	conflict := fs.String("conflict", "skip", "what to do when a page already exists.\nskip it, overwrite it with a new revision, or rename the imported page")
+	author := fs.String("author", "import", "author of the imported revisions")
+	dashes := fs.Bool("dashes", false, "dashes in file names are spaces, like gollum wikis")
+	batchSize := fs.Int("batch", defaultBatchSize, "number of pages to save in a transaction")
+	args = parseArgs(fs, args, "[flags] <dir>", 1)
+	if *conflict != "skip" && *conflict != "overwrite" && *conflict != "rename" {
+		return fmt.Errorf("unknown conflict handling: %s", *conflict)
+	}
+	dir := args[0]
+	if fi, err := os.Stat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx := context.Background()
+	batch := newPageBatch(ctx, *batchSize)
+	batch.progress = printImportProgress
+	var pages, skipped int
+	err = filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") && fpath != dir {
+			// .git and such.
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), filenameExt) {
+			return nil
+		}
+		name, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		title := importTitle(name, *dashes)
+		title, ok, err := resolveImportTitle(ctx, batch, title, *conflict)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "skip %s: page %s exists\n", name, title)
+			skipped++
+			return nil
+		}
+		body, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		p := &Page{
+			Title:   title,
+			Body:    bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1),
+			Created: fi.ModTime(),
+			Author:  *author,
+			Summary: "imported from " + name,
+		}
+		err = batch.add(p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s -> %s\n", name, title)
+		pages++
+		return nil
+	})
+	if err == nil {
+		err = batch.flush()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d pages, skipped %d pages\n", pages, skipped)
+	return nil
+}
+
+// importTitle returns title of the page for the slash separated file path.
+// Files written by the wiki (git storage, mirror) get their original titles back.
+func importTitle(name string, dashes bool) string {
+	if t, err := filenameTitle(name); err == nil && !dashes {
+		return t
+	}
+	t := strings.TrimSuffix(name, filenameExt)
+	if dashes {
+		t = strings.Replace(t, "-", " ", -1)
+	}
+	return t
+}
+
+// printImportProgress prints the number of revisions saved by an import.
+func printImportProgress(saved int) {
+	fmt.Fprintf(os.Stderr, "saved %d revisions\n", saved)
+}
+
+// importedPageExists reports whether the page exists, or will be saved by the batch.
+func importedPageExists(ctx context.Context, batch *pageBatch, title string) (bool, error) {
+	if batch.has(title) {
+		return true, nil
+	}
+	return pageExists(ctx, title)
+}
+
+// resolveImportTitle returns the title to import a page by the conflict handling.
+// It returns false, when the page should be skipped.
+func resolveImportTitle(ctx context.Context, batch *pageBatch, title, conflict string) (string, bool, error) {
+	ok, err := importedPageExists(ctx, batch, title)
+	if err != nil || !ok {
+		return title, err == nil, err
+	}
+	switch conflict {
+	case "overwrite":
+		return title, true, nil
+	case "rename":
+		for i := 1; ; i++ {
+			t := title + " (imported)"
+			if i > 1 {
+				t = fmt.Sprintf("%s (imported %d)", title, i)
+			}
+			ok, err := importedPageExists(ctx, batch, t)
+			if err != nil {
+				return "", false, err
+			}
+			if !ok {
+				return t, true, nil
+			}
+		}
+	}
+	return title, false, nil
+}
+
+// importMediaWikiCommand imports pages of a mediawiki xml dump with their history.
+// Pages those already exist in the wiki are skipped.
+func importMediaWikiCommand(fs *flag.FlagSet, args []string) (err error) {
+	license := fs.String("license", "", "license of the original wiki. ex) CC BY-SA 3.0")
+	author := fs.String("author", "mediawiki", "author of the imported revisions in the wiki. original authors are kept in provenance")
+	all := fs.Bool("all", false, "import pages of every namespace (talk, user, ...), not only the main namespace")
+	batchSize := fs.Int("batch", defaultBatchSize, "number of revisions to save in a transaction")
+	args = parseArgs(fs, args, "[flags] <dump.xml>", 1)
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx := context.Background()
+	batch := newPageBatch(ctx, *batchSize)
+	batch.progress = printImportProgress
+	var pages, revs, skipped int
+	err = readMediaWikiDump(f, func(site *mwSiteInfo, mp *mwPage) error {
+		if mp.NS != 0 && !*all {
+			skipped++
+			return nil
+		}
+		title := mwTitle(mp.Title)
+		exists, err := importedPageExists(ctx, batch, title)
+		if err != nil {
+			return err
+		}
+		if exists {
+			fmt.Fprintf(os.Stderr, "skip %s: page exists\n", title)
+			skipped++
+			return nil
+		}
+		sort.SliceStable(mp.Revisions, func(i, j int) bool {
+			return mp.Revisions[i].Timestamp.Before(mp.Revisions[j].Timestamp)
+		})
+		for _, r := range mp.Revisions {
+			p := &Page{
+				Title:   title,
+				Body:    []byte(wikitextMarkdown(r.Text)),
+				Created: r.Timestamp,
+				Author:  *author,
+				Summary: r.Comment,
+				Provenance: &Provenance{
+					SourceURL: site.PageURL(mp.Title),
+					Author:    r.Author(),
+					License:   *license,
+				},
+			}
+			err := batch.add(p)
+			if err != nil {
+				return err
+			}
+			revs++
+		}
+		pages++
+		return nil
+	})
+	if err == nil {
+		err = batch.flush()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d pages (%d revisions), skipped %d pages\n", pages, revs, skipped)
+	return nil
+}
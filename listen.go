@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// servers listen on one of those addresses.
+//
+//	host:port       a tcp address
+//	unix:path       a unix socket, for a proxy on the same host. ex) unix:/run/whisky/whisky.sock
+//	systemd         the next socket systemd opened for whisky (socket activation)
+//	systemd:name    the socket of systemd with FileDescriptorName=name
+//
+// with a unix socket or systemd, whisky doesn't need to open tcp ports,
+// or the privilege to open port 80 and 443.
+
+// listen listens on the address.
+func listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(addr, "unix:"))
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on the unix socket, which can be connected by the owner and the group.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		// left by the last run.
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+var (
+	systemdOnce sync.Once
+	systemdMu   sync.Mutex
+	// systemdSockets are sockets from systemd those are not taken yet, in order.
+	systemdSockets []systemdSocket
+	systemdErr     error
+)
+
+type systemdSocket struct {
+	name string
+	l    net.Listener
+}
+
+// loadSystemdSockets loads sockets systemd passed to the process.
+// see sd_listen_fds(3) for the protocol.
+func loadSystemdSockets() {
+	defer func() {
+		// not for child processes.
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		systemdErr = fmt.Errorf("invalid LISTEN_FDS: %v", err)
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		// the first passed socket is 3, after stdin, stdout and stderr.
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			systemdErr = fmt.Errorf("systemd socket %d is not a listening socket: %v", 3+i, err)
+			return
+		}
+		systemdSockets = append(systemdSockets, systemdSocket{name: name, l: l})
+	}
+}
+
+// systemdListener takes the socket of the name from systemd, or the next one when name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	systemdOnce.Do(loadSystemdSockets)
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+	for i, s := range systemdSockets {
+		if name == "" || s.name == name {
+			systemdSockets = append(systemdSockets[:i], systemdSockets[i+1:]...)
+			return s.l, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no socket from systemd")
+	}
+	return nil, fmt.Errorf("no socket named %s from systemd", name)
+}
+
+// fromUnixSocket reports whether the request came to a unix socket,
+// from a proxy on the same host.
+func fromUnixSocket(r *http.Request) bool {
+	a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && a.Network() == "unix"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// besides -addr (and -https-addr), the wiki can serve at other addresses with
+// only some of the routes, like the api at a unix socket for scripts on the host,
+// or admin pages at an address only reachable from the internal network.
+//
+//	-listen 127.0.0.1:8081=admin,debug,metrics -listen unix:/run/whisky/api.sock=api
+//
+// or in the config file,
+//
+//	listen = ["127.0.0.1:8081=admin,debug,metrics", "unix:/run/whisky/api.sock=api"]
+//
+// routes are in routeGroups. assets and logging in or out are served at any address,
+// for the pages to work. users still log in to see admin pages at any address.
+// extra addresses serve plain http, behind the same limits and logs of -addr.
+
+// routeGroups are the groups of routes a listener can serve, by the prefixes of their paths.
+// wiki is the rest of the routes, the pages.
+var routeGroups = map[string][]string{
+	"wiki":    nil,
+	"api":     {"/api/"},
+	"dav":     {"/dav/"},
+	"admin":   {"/settings", "/users", "/webhooks", "/chathooks", "/export", "/backup"},
+	"metrics": {"/metrics"},
+	"debug":   {"/debug/"},
+}
+
+// sharedRoutes are served at every address.
+var sharedRoutes = []string{"/static/", "/logo", "/favicon.ico"}
+
+// listener is an extra address to serve the groups of routes.
+type listener struct {
+	addr   string
+	groups map[string]bool
+}
+
+// listenerFlag is -listen, which can be given many times.
+type listenerFlag []*listener
+
+func (f *listenerFlag) String() string {
+	var specs []string
+	for _, l := range *f {
+		specs = append(specs, l.addr+"="+l.String())
+	}
+	return strings.Join(specs, " ")
+}
+
+// Set adds listeners of the space separated specs, like addr=group,group.
+// they are separated by spaces in the config file and environment variables.
+func (f *listenerFlag) Set(s string) error {
+	for _, spec := range strings.Fields(s) {
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid listener: %s. it should be like addr=api,admin", spec)
+		}
+		l := &listener{addr: spec[:i], groups: make(map[string]bool)}
+		for _, g := range strings.Split(spec[i+1:], ",") {
+			g = strings.TrimSpace(g)
+			if _, ok := routeGroups[g]; !ok {
+				return fmt.Errorf("unknown routes of %s: %s", l.addr, g)
+			}
+			l.groups[g] = true
+		}
+		*f = append(*f, l)
+	}
+	return nil
+}
+
+// routeGroup returns the group of the route of the path.
+func routeGroup(path string) string {
+	for g, prefixes := range routeGroups {
+		for _, p := range prefixes {
+			if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+				return g
+			}
+		}
+	}
+	return "wiki"
+}
+
+// handler serves requests to h, only of the routes of the listener.
+func (l *listener) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.serves(r) {
+			notFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (l *listener) String() string {
+	groups := make([]string, 0, len(l.groups))
+	for g := range l.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	return strings.Join(groups, ",")
+}
+
+func (l *listener) serves(r *http.Request) bool {
+	for _, p := range sharedRoutes {
+		if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+			return true
+		}
+	}
+	q := r.URL.Query()
+	if q.Get("login") != "" || q.Get("logout") != "" {
+		return true
+	}
+	return l.groups[routeGroup(r.URL.Path)]
+}
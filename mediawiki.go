@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shurcooL/sanitized_anchor_name"
+)
+
+// mediawiki xml dump (Special:Export) looks like
+//
+//	<mediawiki>
+//	  <siteinfo><sitename>..</sitename><base>https://example.com/wiki/Main_Page</base></siteinfo>
+//	  <page>
+//	    <title>..</title><ns>0</ns>
+//	    <revision>
+//	      <timestamp>2018-01-15T13:15:00Z</timestamp>
+//	      <contributor><username>..</username></contributor>
+//	      <comment>..</comment>
+//	      <text>..</text>
+//	    </revision>
+//	  </page>
+//	</mediawiki>
+
+type mwSiteInfo struct {
+	SiteName string `xml:"sitename"`
+	Base     string `xml:"base"`
+}
+
+// PageURL returns url of the page in the original wiki.
+func (s *mwSiteInfo) PageURL(title string) string {
+	i := strings.LastIndex(s.Base, "/")
+	if i < 0 {
+		return ""
+	}
+	segs := strings.Split(strings.Replace(title, " ", "_", -1), "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	return s.Base[:i+1] + strings.Join(segs, "/")
+}
+
+type mwPage struct {
+	Title     string       `xml:"title"`
+	NS        int          `xml:"ns"`
+	Revisions []mwRevision `xml:"revision"`
+}
+
+type mwRevision struct {
+	Timestamp   time.Time `xml:"timestamp"`
+	Contributor struct {
+		Username string `xml:"username"`
+		IP       string `xml:"ip"`
+	} `xml:"contributor"`
+	Comment string `xml:"comment"`
+	Text    string `xml:"text"`
+}
+
+func (r *mwRevision) Author() string {
+	if r.Contributor.Username != "" {
+		return r.Contributor.Username
+	}
+	return r.Contributor.IP
+}
+
+// readMediaWikiDump calls fn with pages of the dump one by one,
+// so a big dump doesn't have to be in memory at once.
+func readMediaWikiDump(r io.Reader, fn func(site *mwSiteInfo, p *mwPage) error) error {
+	d := xml.NewDecoder(r)
+	site := &mwSiteInfo{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "siteinfo":
+			err = d.DecodeElement(site, &se)
+		case "page":
+			p := &mwPage{}
+			err = d.DecodeElement(p, &se)
+			if err == nil {
+				err = fn(site, p)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wikitextMarkdown converts wikitext of mediawiki to markdown.
+//
+// it is best-effort. headings, emphasis, links, lists, tables and
+// preformatted text are converted. templates, references, categories and
+// files are dropped, as they don't have a counterpart in the wiki.
+// other markup is left as it is.
+func wikitextMarkdown(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = mwComment.ReplaceAllString(text, "")
+	text = mwRef.ReplaceAllString(text, "")
+	text = removeTemplates(text)
+	text = mwMagicWord.ReplaceAllString(text, "")
+
+	// contents of these tags shouldn't be converted.
+	// they are kept aside and put back at the end.
+	kept := []string{}
+	keep := func(s string) string {
+		kept = append(kept, s)
+		return "\x00" + strconv.Itoa(len(kept)-1) + "\x00"
+	}
+	text = mwPre.ReplaceAllStringFunc(text, func(s string) string {
+		m := mwPre.FindStringSubmatch(s)
+		lang := ""
+		if l := mwLang.FindStringSubmatch(m[2]); l != nil {
+			lang = l[1]
+		}
+		return "\n" + keep("```"+lang+"\n"+strings.Trim(m[3], "\n")+"\n```") + "\n"
+	})
+	text = mwCode.ReplaceAllStringFunc(text, func(s string) string {
+		return keep("`" + mwCode.FindStringSubmatch(s)[1] + "`")
+	})
+	text = mwNowiki.ReplaceAllStringFunc(text, func(s string) string {
+		return keep(markdownEscaper.Replace(mwNowiki.FindStringSubmatch(s)[1]))
+	})
+
+	lines := strings.Split(text, "\n")
+	out := []string{}
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "{|"):
+			j := i + 1
+			for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "|}") {
+				j++
+			}
+			out = append(out, "", mwTable(lines[i+1:j]), "")
+			i = j
+		case strings.HasPrefix(l, " ") && strings.TrimSpace(l) != "":
+			// lines start with a space are preformatted.
+			pre := []string{}
+			for ; i < len(lines) && strings.HasPrefix(lines[i], " ") && strings.TrimSpace(lines[i]) != ""; i++ {
+				pre = append(pre, lines[i][1:])
+			}
+			i--
+			out = append(out, "```", strings.Join(pre, "\n"), "```")
+		default:
+			out = append(out, mwLine(l))
+		}
+	}
+	text = strings.Join(out, "\n")
+
+	text = mwKept.ReplaceAllStringFunc(text, func(s string) string {
+		n, _ := strconv.Atoi(strings.Trim(s, "\x00"))
+		return kept[n]
+	})
+	text = mwBlankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
+
+var (
+	mwComment    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	mwRef        = regexp.MustCompile(`(?s)<ref[^>]*/>|<ref[^>]*>.*?</ref>`)
+	mwMagicWord  = regexp.MustCompile(`__[A-Z]+__`)
+	mwPre        = regexp.MustCompile(`(?s)<(pre|syntaxhighlight|source)([^>]*)>(.*?)</(?:pre|syntaxhighlight|source)>`)
+	mwLang       = regexp.MustCompile(`lang="?([a-zA-Z0-9+-]+)`)
+	mwCode       = regexp.MustCompile(`(?s)<code>(.*?)</code>`)
+	mwNowiki     = regexp.MustCompile(`(?s)<nowiki>(.*?)</nowiki>`)
+	mwKept       = regexp.MustCompile("\x00[0-9]+\x00")
+	mwBlankLines = regexp.MustCompile(`\n{3,}`)
+
+	mwRedirect = regexp.MustCompile(`(?i)^#redirect\s*(\[\[.*\]\])`)
+	mwHeading  = regexp.MustCompile(`^(=+)\s*(.*?)\s*(=+)\s*$`)
+	mwRule     = regexp.MustCompile(`^-{4,}\s*$`)
+	mwList     = regexp.MustCompile(`^([*#:;]+)\s*(.*)$`)
+
+	mwBoldItalic = regexp.MustCompile(`'''''(.+?)'''''`)
+	mwBold       = regexp.MustCompile(`'''(.+?)'''`)
+	mwItalic     = regexp.MustCompile(`''(.+?)''`)
+	mwLink       = regexp.MustCompile(`\[\[([^\[\]|]*)(?:\|([^\[\]]*))?\]\]([a-z]*)`)
+	mwExtLink    = regexp.MustCompile(`\[((?:https?|ftp)://[^\s\]]+|mailto:[^\s\]]+)(?:\s+([^\]]*))?\]`)
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `#`, `\#`, `<`, `&lt;`,
+)
+
+// removeTemplates removes {{templates}}, which can be nested.
+func removeTemplates(text string) string {
+	b := &strings.Builder{}
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		if strings.HasPrefix(text[i:], "{{") {
+			depth++
+			i++
+			continue
+		}
+		if depth > 0 && strings.HasPrefix(text[i:], "}}") {
+			depth--
+			i++
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+// mwLine converts a line which is not in a block.
+func mwLine(l string) string {
+	if m := mwRedirect.FindStringSubmatch(l); m != nil {
+		return "Redirected to " + mwInline(m[1]) + "."
+	}
+	if m := mwHeading.FindStringSubmatch(l); m != nil {
+		n := len(m[1])
+		if len(m[3]) < n {
+			n = len(m[3])
+		}
+		if n > 6 {
+			n = 6
+		}
+		return "\n" + strings.Repeat("#", n) + " " + mwInline(m[2]) + "\n"
+	}
+	if mwRule.MatchString(l) {
+		return "\n---\n"
+	}
+	if m := mwList.FindStringSubmatch(l); m != nil {
+		return mwListItem(m[1], m[2])
+	}
+	return mwInline(l)
+}
+
+// mwListItem converts an item of lists (*, #), definition lists (;, :) and indents (:).
+func mwListItem(prefix, text string) string {
+	if strings.Trim(prefix, ":") == "" {
+		// indents are mostly used for replies in talk pages.
+		return strings.Repeat("> ", len(prefix)) + mwInline(text)
+	}
+	indent := ""
+	for _, c := range prefix[:len(prefix)-1] {
+		if c == '#' {
+			indent += "   "
+		} else {
+			indent += "  "
+		}
+	}
+	switch prefix[len(prefix)-1] {
+	case '*':
+		return indent + "- " + mwInline(text)
+	case '#':
+		return indent + "1. " + mwInline(text)
+	case ';':
+		// ; term : definition
+		if i := strings.Index(text, " : "); i >= 0 {
+			return indent + "**" + mwInline(strings.TrimSpace(text[:i])) + "**  \n" + indent + mwInline(strings.TrimSpace(text[i+3:]))
+		}
+		return indent + "**" + mwInline(text) + "**  "
+	default:
+		return indent + mwInline(text)
+	}
+}
+
+// mwTable converts lines between {| and |} to a markdown table.
+// the first row becomes the header, as markdown tables need one.
+func mwTable(lines []string) string {
+	var (
+		caption string
+		rows    [][]string
+		row     []string
+	)
+	endRow := func() {
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		row = nil
+	}
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(l, "|+"):
+			caption = mwCell(l[2:])
+		case strings.HasPrefix(l, "|-"):
+			endRow()
+		case strings.HasPrefix(l, "!"):
+			for _, c := range strings.Split(strings.Replace(l[1:], "!!", "||", -1), "||") {
+				row = append(row, mwCell(c))
+			}
+		case strings.HasPrefix(l, "|"):
+			for _, c := range strings.Split(l[1:], "||") {
+				row = append(row, mwCell(c))
+			}
+		case l != "" && len(row) > 0:
+			// continued content of the last cell.
+			row[len(row)-1] += " " + mwInline(l)
+		}
+	}
+	endRow()
+	if len(rows) == 0 {
+		return ""
+	}
+	cols := 0
+	for _, r := range rows {
+		if len(r) > cols {
+			cols = len(r)
+		}
+	}
+	b := &strings.Builder{}
+	if caption != "" {
+		b.WriteString("*" + caption + "*\n\n")
+	}
+	writeRow := func(r []string) {
+		for i := 0; i < cols; i++ {
+			c := ""
+			if i < len(r) {
+				c = strings.Replace(r[i], "|", `\|`, -1)
+			}
+			b.WriteString("| " + c + " ")
+		}
+		b.WriteString("|\n")
+	}
+	writeRow(rows[0])
+	b.WriteString(strings.Repeat("| --- ", cols) + "|\n")
+	for _, r := range rows[1:] {
+		writeRow(r)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// mwCell converts a table cell, dropping it's attributes. (ex. style="color:red" | text)
+func mwCell(c string) string {
+	if i := strings.Index(c, "|"); i >= 0 && strings.Contains(c[:i], "=") && !strings.Contains(c[:i], "[[") {
+		c = c[i+1:]
+	}
+	return mwInline(strings.TrimSpace(c))
+}
+
+// mwInline converts inline markup of the text.
+func mwInline(s string) string {
+	s = mwLink.ReplaceAllStringFunc(s, func(l string) string {
+		m := mwLink.FindStringSubmatch(l)
+		return mwLinkMarkdown(m[1], m[2], m[3])
+	})
+	s = mwExtLink.ReplaceAllStringFunc(s, func(l string) string {
+		m := mwExtLink.FindStringSubmatch(l)
+		if m[2] == "" {
+			return "<" + m[1] + ">"
+		}
+		return "[" + m[2] + "](" + m[1] + ")"
+	})
+	s = mwBoldItalic.ReplaceAllString(s, "***$1***")
+	s = mwBold.ReplaceAllString(s, "**$1**")
+	s = mwItalic.ReplaceAllString(s, "*$1*")
+	return s
+}
+
+// mwLinkMarkdown converts an internal link [[target|label]]trail.
+func mwLinkMarkdown(target, label, trail string) string {
+	target = strings.TrimSpace(target)
+	colon := strings.HasPrefix(target, ":")
+	target = strings.TrimPrefix(target, ":")
+	ns := ""
+	if i := strings.Index(target, ":"); i >= 0 {
+		ns = strings.ToLower(strings.TrimSpace(target[:i]))
+	}
+	if !colon && ns == "category" {
+		return ""
+	}
+	if !colon && (ns == "file" || ns == "image") {
+		// files are not imported. keep the caption if there is.
+		if i := strings.LastIndex(label, "|"); i >= 0 {
+			label = label[i+1:]
+		}
+		if label == "" || strings.Contains(label, "px") || label == "thumb" {
+			return ""
+		}
+		return "*" + label + "*"
+	}
+	text := label
+	if text == "" {
+		text = target
+	}
+	text += trail
+	anchor := ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, anchor = target[:i], "#"+sanitized_anchor_name.Create(target[i+1:])
+	}
+	if target == "" {
+		return "[" + text + "](" + anchor + ")"
+	}
+	title := mwTitle(target)
+	segs := strings.Split(title, "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	return "[" + text + "](/view/" + strings.Join(segs, "/") + anchor + ")"
+}
+
+// mwTitle normalizes a mediawiki title, as the wiki does.
+// underscores are spaces, and the first letter is upper case.
+func mwTitle(t string) string {
+	t = strings.TrimSpace(strings.Replace(t, "_", " ", -1))
+	r, n := utf8.DecodeRuneInString(t)
+	return string(unicode.ToUpper(r)) + t[n:]
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// metrics of the wiki are served at /metrics in the prometheus text format.
+// it's for admins (or their admin api token), or for anyone who can reach
+// -metrics-addr, which is meant to be a local or internal address.
+//
+// they are counted by hand, not with the prometheus client library,
+// as a few counters and histograms don't need it.
+
+var (
+	httpRequests = newCounter("whisky_http_requests_total",
+		"requests by the handler (the pattern of the path), method and status code.")
+	httpDuration = newHistogram("whisky_http_request_duration_seconds",
+		"time to serve requests by the handler.")
+	pageSaves = newCounter("whisky_page_saves_total",
+		"revisions saved.")
+	cacheRequests = newCounter("whisky_cache_requests_total",
+		"lookups of caches by the result (hit or miss). conditional is 304 responses to browsers' caches.")
+	jobRuns = newCounter("whisky_job_runs_total",
+		"runs of background jobs by the result (ok or error).")
+	jobDuration = newHistogram("whisky_job_duration_seconds",
+		"time of runs of background jobs.")
+)
+
+// metricBuckets are upper bounds of histogram buckets in seconds.
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// metricsList is metrics in the order of /metrics.
+var metricsList []interface{ writeMetric(w io.Writer) }
+
+// metricLabels formats label name and value pairs like {name="value"}.
+func metricLabels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("{")
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i != 0 {
+			b.WriteString(",")
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, kv[i], v)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// counter is a counter with labels.
+type counter struct {
+	name string
+	help string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string) *counter {
+	c := &counter{name: name, help: help, values: make(map[string]float64)}
+	metricsList = append(metricsList, c)
+	return c
+}
+
+// inc adds 1 to the counter with labels in name and value pairs.
+func (c *counter) inc(kv ...string) {
+	l := metricLabels(kv...)
+	c.mu.Lock()
+	c.values[l]++
+	c.mu.Unlock()
+}
+
+func (c *counter) writeMetric(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	labels := make([]string, 0, len(c.values))
+	for l := range c.values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, l, formatMetric(c.values[l]))
+	}
+}
+
+// histogram is a histogram of durations with labels.
+type histogram struct {
+	name string
+	help string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts are not cumulative, they are summed up when written.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string) *histogram {
+	h := &histogram{name: name, help: help, series: make(map[string]*histogramSeries)}
+	metricsList = append(metricsList, h)
+	return h
+}
+
+// observe adds the duration to the histogram with labels in name and value pairs.
+func (h *histogram) observe(d time.Duration, kv ...string) {
+	l := metricLabels(kv...)
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[l]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(metricBuckets))}
+		h.series[l] = s
+	}
+	for i, le := range metricBuckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogram) writeMetric(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := make([]string, 0, len(h.series))
+	for l := range h.series {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		s := h.series[l]
+		// le label goes with other labels.
+		inner := strings.TrimSuffix(strings.TrimPrefix(l, "{"), "}")
+		if inner != "" {
+			inner += ","
+		}
+		var n uint64
+		for i, le := range metricBuckets {
+			n += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, inner, formatMetric(le), n)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, inner, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, l, formatMetric(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, l, s.count)
+	}
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// observeJob counts a run of the background job started at start.
+func observeJob(job string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	jobRuns.inc("job", job, "result", result)
+	jobDuration.observe(time.Since(start), "job", job)
+}
+
+// countCache counts a lookup of the cache.
+func countCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.inc("cache", cache, "result", result)
+}
+
+// withMetrics counts requests to the mux by the pattern they matched.
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "none"
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r)
+		httpRequests.inc("handler", pattern, "method", r.Method, "code", strconv.Itoa(sw.status))
+		httpDuration.observe(time.Since(start), "handler", pattern)
+	})
+}
+
+// writeGauges writes metrics those are read at the time, from the database.
+func writeGauges(w io.Writer) error {
+	var size int64
+	var sessions int
+	now := time.Now()
+	err := db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return tx.Bucket([]byte("sessions")).ForEach(func(k, bs []byte) error {
+			s := &Session{}
+			if err := fromBytes(bs, s); err != nil {
+				// not a session anyone can use.
+				return nil
+			}
+			if now.Before(s.Expires) {
+				sessions++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# HELP whisky_db_size_bytes size of whisky.db.\n# TYPE whisky_db_size_bytes gauge\nwhisky_db_size_bytes %d\n", size)
+	fmt.Fprintf(w, "# HELP whisky_sessions_active sessions not expired.\n# TYPE whisky_sessions_active gauge\nwhisky_sessions_active %d\n", sessions)
+	s := searchIndex.stats()
+	fmt.Fprintf(w, "# HELP whisky_search_index_queued pages waiting to be indexed for search.\n# TYPE whisky_search_index_queued gauge\nwhisky_search_index_queued %d\n", s.Queued)
+	fmt.Fprintf(w, "# HELP whisky_search_index_lag_seconds time the oldest page in the search index queue has waited.\n# TYPE whisky_search_index_lag_seconds gauge\nwhisky_search_index_lag_seconds %s\n", formatMetric(s.Lag.Seconds()))
+	return nil
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	err := writeGauges(&buf)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	for _, m := range metricsList {
+		m.writeMetric(&buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// a page which doesn't exist shows titles similar to it, for a typo in a link,
+// and the pages linking to it, which tells what it should be about.
+
+// maxSimilarTitles is the number of similar titles shown for a missing page.
+const maxSimilarTitles = 10
+
+// similarTitles returns titles of the pages which look like the title, closest first.
+// titles differ only in cases, having the same last part, containing one or another,
+// and a few typos away are similar.
+func similarTitles(ctx context.Context, title string) ([]string, error) {
+	all, err := listTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type similar struct {
+		title    string
+		distance int
+	}
+	want := strings.ToLower(title)
+	wantName := want[strings.LastIndex(want, "/")+1:]
+	// longer titles can have more typos.
+	limit := max(1, utf8.RuneCountInString(want)/4)
+	var found []similar
+	for _, t := range all {
+		if t == title {
+			continue
+		}
+		lower := strings.ToLower(t)
+		d := -1
+		switch {
+		case lower == want:
+			d = 0
+		case lower[strings.LastIndex(lower, "/")+1:] == wantName,
+			len(want) >= 3 && strings.Contains(lower, want),
+			len(lower) >= 3 && strings.Contains(want, lower):
+			d = 1
+		default:
+			if n, ok := editDistance(want, lower, limit); ok {
+				d = 1 + n
+			}
+		}
+		if d >= 0 {
+			found = append(found, similar{t, d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].distance != found[j].distance {
+			return found[i].distance < found[j].distance
+		}
+		return found[i].title < found[j].title
+	})
+	titles := []string{}
+	for _, s := range found[:min(len(found), maxSimilarTitles)] {
+		titles = append(titles, s.title)
+	}
+	return titles, nil
+}
+
+// editDistance returns the number of runes to insert, delete or replace to make a into b.
+// It returns false when it's more than the limit, without computing all of it.
+func editDistance(a, b string, limit int) (int, bool) {
+	ra, rb := []rune(a), []rune(b)
+	if abs := len(ra) - len(rb); abs > limit || -abs > limit {
+		return 0, false
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		lowest := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			lowest = min(lowest, cur[j])
+		}
+		if lowest > limit {
+			return 0, false
+		}
+		prev, cur = cur, prev
+	}
+	d := prev[len(rb)]
+	return d, d <= limit
+}
+
+// pagesLinkingTo returns titles of the pages linking to the title.
+// Other stores than bolt don't have the backlinks index, and nothing is returned.
+func pagesLinkingTo(ctx context.Context, title string) ([]string, error) {
+	s, ok := store.(interface {
+		Backlinks(ctx context.Context, title string) ([]string, error)
+	})
+	if !ok {
+		return nil, nil
+	}
+	return s.Backlinks(ctx, title)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// multiCommand hosts several wikis from one address, routed by the hostname and the path.
+//
+// state of a wiki (the database, settings, templates) is global in a whisky process,
+// so each wiki runs as a child whisky process with it's own data directory.
+// the multi process terminates https for all of them and proxies requests
+// to the wiki of the Host header. a child is restarted when it exits.
+//
+// children listen on unix sockets in a directory only the multi process's user can enter.
+// they trust forwarded headers from the sockets (see proxy.go), which only the user of
+// the multi process can connect to, unlike a local port any user of the host can.
+//
+// wikis are given by a json config.
+//
+//	{
+//		"addr": ":80",
+//		"https": true,
+//		"https_addr": ":443",
+//		"cert": "/etc/whisky/cert.pem",
+//		"key": "/etc/whisky/key.pem",
+//		"args": ["-image-proxy"],
+//		"wikis": [
+//			{"host": "docs.example.com", "data": "/var/lib/whisky/docs"},
+//			{"host": "team.example.com", "data": "/var/lib/whisky/team", "args": ["-home", "Welcome"]},
+//			{"host": "team.example.com", "path": "/archive", "data": "/var/lib/whisky/archive"}
+//		]
+//	}
+//
+// args are flags given to every wiki, and args of a wiki are given after them.
+// a wiki of an empty host gets requests of unknown hosts.
+// a wiki with a path gets requests under it, and is run with it as -base-url.
+// (see baseurl.go) the longest path of the host matching the request wins.
+func multiCommand(fs *flag.FlagSet, args []string) error {
+	config := fs.String("config", "wikis.json", "config file of the wikis")
+	parseArgs(fs, args, "[flags]", -1)
+	cfg, err := readMultiConfig(*config)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// it's made with mode 0700.
+	sockDir, err := os.MkdirTemp("", "whisky-multi-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(sockDir)
+	hosts := make(map[string][]multiRoute)
+	children := make([]*multiChild, 0, len(cfg.Wikis))
+	for i, w := range cfg.Wikis {
+		host := strings.ToLower(w.Host)
+		path := strings.TrimRight(w.Path, "/")
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		name := w.Host
+		if name == "" {
+			name = "*"
+		}
+		name += path
+		for _, rt := range hosts[host] {
+			if rt.path == path {
+				return fmt.Errorf("%s: duplicated wiki %q", *config, name)
+			}
+		}
+		if w.Data == "" {
+			return fmt.Errorf("%s: data directory of %q is not given", *config, name)
+		}
+		sock := filepath.Join(sockDir, strconv.Itoa(i)+".sock")
+		args := []string{"-data", w.Data, "-addr", "unix:" + sock}
+		if path != "" {
+			args = append(args, "-base-url", path+"/")
+		}
+		c := &multiChild{
+			name: name,
+			exe:  exe,
+			data: w.Data,
+			args: append(append(args, cfg.Args...), w.Args...),
+			done: make(chan struct{}),
+		}
+		hosts[host] = append(hosts[host], multiRoute{path, unixProxy(sock)})
+		children = append(children, c)
+	}
+	for _, rts := range hosts {
+		sort.Slice(rts, func(i, j int) bool { return len(rts[i].path) > len(rts[j].path) })
+	}
+	for _, c := range children {
+		go c.run()
+	}
+
+	// stop the wikis with the multi process, not to leave them holding the databases.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		for _, c := range children {
+			c.stop()
+		}
+		os.RemoveAll(sockDir)
+		os.Exit(0)
+	}()
+	// and reload them with it. (see reload.go)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, c := range children {
+				c.reload()
+			}
+		}
+	}()
+
+	// the wikis trust the sockets, so they log with the same request ids.
+	h := withRequestID(multiHandler(hosts, cfg.HTTPS))
+	if cfg.HTTPS {
+		if cfg.Cert == "" || cfg.Key == "" {
+			return fmt.Errorf("%s: https needs both cert and key", *config)
+		}
+		tlsConfig, err := newTLSConfig(cfg.TLSMinVersion)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *config, err)
+		}
+		httpsAddr := httpsAddress(cfg.Addr, cfg.HTTPSAddr)
+		l, err := listen(cfg.Addr)
+		if err != nil {
+			return err
+		}
+		tl, err := listen(httpsAddr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			fatal("http server stopped", "err", multiServer(cfg.Addr, redirectToHTTPS(httpsAddr, h)).Serve(l))
+		}()
+		srv := multiServer(httpsAddr, h)
+		srv.TLSConfig = tlsConfig
+		return srv.ServeTLS(tl, cfg.Cert, cfg.Key)
+	}
+	l, err := listen(cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return multiServer(cfg.Addr, h).Serve(l)
+}
+
+// multiServer makes a server which doesn't wait slow clients forever.
+// time to serve requests is up to the wikis.
+func multiServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+type multiConfig struct {
+	Addr          string      `json:"addr"`
+	HTTPS         bool        `json:"https"`
+	HTTPSAddr     string      `json:"https_addr"`
+	TLSMinVersion string      `json:"tls_min_version"`
+	Cert          string      `json:"cert"`
+	Key           string      `json:"key"`
+	Args          []string    `json:"args"`
+	Wikis         []multiWiki `json:"wikis"`
+}
+
+type multiWiki struct {
+	Host string `json:"host"`
+	// Path is the path prefix the wiki is served under. ex) /archive
+	Path string   `json:"path"`
+	Data string   `json:"data"`
+	Args []string `json:"args"`
+}
+
+// multiRoute is a wiki of a host, which gets requests under the path.
+type multiRoute struct {
+	path  string
+	proxy *httputil.ReverseProxy
+}
+
+// match reports whether the request path is under the route's path.
+func (rt multiRoute) match(p string) bool {
+	return rt.path == "" || p == rt.path || strings.HasPrefix(p, rt.path+"/")
+}
+
+func readMultiConfig(path string) (*multiConfig, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &multiConfig{Addr: ":8080", TLSMinVersion: "1.2"}
+	err = json.Unmarshal(bs, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(cfg.Wikis) == 0 {
+		return nil, fmt.Errorf("%s: no wikis", path)
+	}
+	return cfg, nil
+}
+
+// unixProxy makes a proxy to the wiki listening on the unix socket.
+func unixProxy(sock string) *httputil.ReverseProxy {
+	// the host is not used to connect, the Host header of the request is kept.
+	p := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "wiki"})
+	p.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return p
+}
+
+// multiHandler proxies a request to the wiki of it's host and path.
+// routes of a host are sorted by the length of their paths, longest first.
+func multiHandler(hosts map[string][]multiRoute, https bool) http.Handler {
+	find := func(host, path string) *httputil.ReverseProxy {
+		for _, rt := range hosts[host] {
+			if rt.match(path) {
+				return rt.proxy
+			}
+		}
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		p := find(host, r.URL.Path)
+		if p == nil {
+			p = find("", r.URL.Path)
+		}
+		if p == nil {
+			http.Error(w, "no wiki for "+r.Host+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		// the wikis make links of feeds and such with it.
+		proto := "http"
+		if https {
+			proto = "https"
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+		p.ServeHTTP(w, r)
+	})
+}
+
+// multiChild is a whisky process serving one of the wikis.
+type multiChild struct {
+	name string
+	exe  string
+	data string
+	args []string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+	done    chan struct{}
+}
+
+// run runs the wiki, and restarts it whenever it exits.
+func (c *multiChild) run() {
+	defer close(c.done)
+	for {
+		c.mu.Lock()
+		if c.stopped {
+			c.mu.Unlock()
+			return
+		}
+		c.cmd = exec.Command(c.exe, c.args...)
+		c.cmd.Stdout = &prefixWriter{prefix: c.name + ": ", w: os.Stdout}
+		c.cmd.Stderr = &prefixWriter{prefix: c.name + ": ", w: os.Stderr}
+		err := c.cmd.Start()
+		c.mu.Unlock()
+		if err == nil {
+			err = c.cmd.Wait()
+		}
+		c.mu.Lock()
+		stopped := c.stopped
+		c.mu.Unlock()
+		if stopped {
+			return
+		}
+		slog.Warn("whisky exited. restarting", "wiki", c.name, "err", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// stop stops the wiki and waits a while for it to exit.
+func (c *multiChild) stop() {
+	c.mu.Lock()
+	c.stopped = true
+	cmd := c.cmd
+	c.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+	}
+}
+
+// reload sends SIGHUP to the wiki.
+func (c *multiChild) reload() {
+	c.mu.Lock()
+	cmd := c.cmd
+	c.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	cmd.Process.Signal(syscall.SIGHUP)
+}
+
+// prefixWriter writes lines to w with the prefix, to tell logs of the wikis.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+
+	mu   sync.Mutex
+	line []byte
+}
+
+func (p *prefixWriter) Write(bs []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = append(p.line, bs...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		_, err := io.WriteString(p.w, p.prefix+string(p.line[:i+1]))
+		p.line = p.line[i+1:]
+		if err != nil {
+			return len(bs), err
+		}
+	}
+	return len(bs), nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Notification tells a user about something happened to them,
+// like a mention, a change of a watched page, or a review result.
+type Notification struct {
+	ID      uint64
+	Kind    string
+	Actor   string
+	Message string
+	Link    string
+	Created time.Time
+	Read    bool
+}
+
+const (
+	notifyMention  = "mention"
+	notifyChange   = "change"
+	notifyComment  = "comment"
+	notifyApproval = "approval"
+)
+
+// notifications bucket has a bucket per user which has their notifications.
+// watches bucket has a bucket per page which has names of the watchers as keys.
+
+func notify(user string, n *Notification) error {
+	if n.Created.IsZero() {
+		n.Created = time.Now()
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("notifications")).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		n.ID, _ = b.NextSequence()
+		return putRecord(b, byteID(n.ID), n)
+	})
+}
+
+// notifyUsers sends the notification to the users except the actor.
+// errors are logged, as failed notifications shouldn't fail user's request.
+func notifyUsers(users []string, n Notification) {
+	sent := make(map[string]bool)
+	for _, u := range users {
+		if u == n.Actor || sent[u] {
+			continue
+		}
+		sent[u] = true
+		nn := n
+		if err := notify(u, &nn); err != nil {
+			slog.Error("could not notify", "user", u, "err", err)
+		}
+	}
+}
+
+// loadNotifications returns user's notifications from the latest one.
+func loadNotifications(user string, n int) ([]*Notification, error) {
+	ns := []*Notification{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("notifications")).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(ns) < n; k, v = c.Prev() {
+			nt := &Notification{}
+			if err := fromBytes(v, nt); err != nil {
+				return err
+			}
+			ns = append(ns, nt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+func countUnread(user string) int {
+	n := 0
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("notifications")).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			nt := &Notification{}
+			if err := fromBytes(v, nt); err != nil {
+				return err
+			}
+			if !nt.Read {
+				n++
+			}
+			return nil
+		})
+	})
+	return n
+}
+
+func markAllRead(user string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("notifications")).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			nt := &Notification{}
+			if err := fromBytes(v, nt); err != nil {
+				return err
+			}
+			if nt.Read {
+				continue
+			}
+			nt.Read = true
+			if err := putRecord(b, k, nt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func watchers(title string) ([]string, error) {
+	users := []string{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("watches")).Bucket([]byte(title))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			users = append(users, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func isWatching(user, title string) bool {
+	watching := false
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("watches")).Bucket([]byte(title))
+		watching = b != nil && b.Get([]byte(user)) != nil
+		return nil
+	})
+	return watching
+}
+
+func setWatch(user, title string, watch bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("watches")).CreateBucketIfNotExists([]byte(title))
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		if !watch {
+			return b.Delete([]byte(user))
+		}
+		return b.Put([]byte(user), []byte{})
+	})
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([a-zA-Z0-9_-]+(?:\.[a-zA-Z0-9_-]+)*)`)
+
+// mentions returns names of existing users who are mentioned (ex. @kybin) in the text.
+func mentions(text []byte) []string {
+	users := []string{}
+	for _, m := range mentionPattern.FindAllSubmatch(text, -1) {
+		name := string(m[1])
+		if _, err := loadUser(name); err == nil {
+			users = append(users, name)
+		}
+	}
+	return users
+}
+
+// notifyPageChange notifies watchers of the page and newly mentioned users.
+// prev is the previous revision of the page, it could be nil.
+func notifyPageChange(prev, p *Page) {
+	link := "/view/" + p.Title
+	ws, err := watchers(p.Title)
+	if err != nil {
+		slog.Error("could not load watchers", "page", p.Title, "err", err)
+	}
+	notifyUsers(ws, Notification{Kind: notifyChange, Actor: p.Author, Link: link,
+		Message: p.Author + " changed " + p.Title})
+	mentioned := make(map[string]bool)
+	if prev != nil {
+		for _, u := range mentions(prev.Body) {
+			mentioned[u] = true
+		}
+	}
+	newly := []string{}
+	for _, u := range mentions(p.Body) {
+		if !mentioned[u] {
+			newly = append(newly, u)
+		}
+	}
+	notifyUsers(newly, Notification{Kind: notifyMention, Actor: p.Author, Link: link,
+		Message: p.Author + " mentioned you in " + p.Title})
+}
+
+// notifyCommentPosted notifies watchers of the page, author of the parent comment,
+// and mentioned users.
+func notifyCommentPosted(title string, c *Comment) {
+	link := "/talk/" + title + "#comment-" + strconv.FormatUint(c.ID, 10)
+	users, err := watchers(title)
+	if err != nil {
+		slog.Error("could not load watchers", "page", title, "err", err)
+	}
+	if c.Parent != 0 {
+		// the comment is already posted, notifications should not be canceled with the request.
+		cs, err := loadComments(context.Background(), title)
+		if err != nil {
+			slog.Error("could not load comments", "page", title, "err", err)
+		}
+		for _, pc := range cs {
+			if pc.ID == c.Parent {
+				// anonymous authors are not users, they will be ignored.
+				users = append(users, pc.Author)
+			}
+		}
+	}
+	notifyUsers(users, Notification{Kind: notifyComment, Actor: c.Author, Link: link,
+		Message: c.Author + " commented on " + title})
+	notifyUsers(mentions(c.Body), Notification{Kind: notifyMention, Actor: c.Author, Link: link,
+		Message: c.Author + " mentioned you in a comment on " + title})
+}
+
+func notifyReview(e *PendingEdit, reviewer string, approved bool) {
+	result := "rejected"
+	if approved {
+		result = "approved"
+	}
+	notifyUsers([]string{e.Page.Author}, Notification{Kind: notifyApproval, Actor: reviewer,
+		Link: "/view/" + e.Page.Title, Message: reviewer + " " + result + " your edit of " + e.Page.Title})
+}
+
+func watchHandler(w http.ResponseWriter, r *http.Request, title string) {
+	u := currentUser(r)
+	if u == nil {
+		httpError(w, r, newError(ErrForbidden, "please log in to watch pages"))
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := setWatch(u.Name, title, r.FormValue("unwatch") == "")
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+}
+
+type NotificationsPage struct {
+	Title         string
+	Notifications []*Notification
+}
+
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		httpError(w, r, newError(ErrForbidden, "please log in to see notifications"))
+		return
+	}
+	if r.Method == "POST" {
+		err := markAllRead(u.Name)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/notifications", http.StatusFound)
+		return
+	}
+	ns, err := loadNotifications(u.Name, 100)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "notifications", &NotificationsPage{Notifications: ns})
+}
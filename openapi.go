@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openapi document of the http api is generated from the api types,
+// so it does not drift from what the api really sends.
+// it is served at /api/openapi.json.
+
+// obj is a shorthand for json objects of the document.
+type obj = map[string]interface{}
+
+// apiTypes are types those are referred in the document as components.
+var apiTypes = []interface{}{
+	APIPage{}, APILicense{}, APIError{}, APIEdit{}, APIAppend{}, APIRevisions{},
+	Revision{}, Provenance{}, Diff{}, DiffLine{}, DiffWord{}, APISearch{}, SearchResult{},
+	APISyncPages{}, APISyncPage{}, APISyncPush{},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns json schema of the type.
+// structs those are in apiTypes are referred by $ref.
+func schemaOf(t reflect.Type, top bool) obj {
+	if t.Kind() == reflect.Ptr {
+		return schemaOf(t.Elem(), top)
+	}
+	if t == timeType {
+		return obj{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return obj{"type": "string"}
+	case reflect.Bool:
+		return obj{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return obj{"type": "integer"}
+	case reflect.Slice:
+		return obj{"type": "array", "items": schemaOf(t.Elem(), false)}
+	case reflect.Struct:
+		if !top {
+			return obj{"$ref": "#/components/schemas/" + t.Name()}
+		}
+		props := obj{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				// unexported
+				continue
+			}
+			name := f.Name
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if tag[0] == "-" {
+				continue
+			}
+			if tag[0] != "" {
+				name = tag[0]
+			}
+			props[name] = schemaOf(f.Type, false)
+			if !strings.Contains(f.Tag.Get("json"), "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := obj{"type": "object", "properties": props}
+		if len(required) != 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return obj{}
+}
+
+func jsonContent(typ string) obj {
+	return obj{"application/json": obj{"schema": obj{"$ref": "#/components/schemas/" + typ}}}
+}
+
+func response(desc, typ string) obj {
+	r := obj{"description": desc}
+	if typ != "" {
+		r["content"] = jsonContent(typ)
+	}
+	return r
+}
+
+func errorResponses(codes ...string) obj {
+	desc := map[string]string{
+		"400": "invalid request",
+		"401": "api token is missing or invalid",
+		"403": "not allowed",
+		"404": "page or revision not exists",
+		"409": "the page is changed",
+		"413": "request body is too large",
+	}
+	rs := obj{}
+	for _, c := range codes {
+		rs[c] = response(desc[c], "APIError")
+	}
+	return rs
+}
+
+func merge(a, b obj) obj {
+	for k, v := range b {
+		a[k] = v
+	}
+	return a
+}
+
+func queryParam(name, desc string) obj {
+	return obj{"name": name, "in": "query", "description": desc, "schema": obj{"type": "integer"}}
+}
+
+func openAPIDocument(server string) obj {
+	schemas := obj{}
+	for _, v := range apiTypes {
+		t := reflect.TypeOf(v)
+		schemas[t.Name()] = schemaOf(t, true)
+	}
+	title := obj{"name": "title", "in": "path", "required": true, "description": "title of the page", "schema": obj{"type": "string"}}
+	secured := []obj{{"token": []string{}}}
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":       "whisky",
+			"description": "api of the whisky wiki. writing needs an api token from /tokens page.",
+			"version":     "1",
+		},
+		"servers": []obj{{"url": server + "/api/v1"}},
+		"paths": obj{
+			"/pages/{title}": obj{
+				"parameters": []obj{title},
+				"get": obj{
+					"summary":    "get a page",
+					"parameters": []obj{queryParam("rev", "revision number. the latest revision if omitted")},
+					"responses":  merge(obj{"200": response("the page", "APIPage")}, errorResponses("400", "404")),
+				},
+				"put": obj{
+					"summary":     "save a new revision of a page",
+					"security":    secured,
+					"requestBody": obj{"required": true, "content": jsonContent("APIEdit")},
+					"responses": merge(obj{
+						"200": response("the saved revision", "APIPage"),
+						"201": response("the page is created", "APIPage"),
+						"202": response("the edit is waiting for review", "APIPage"),
+					}, errorResponses("400", "401", "403", "413")),
+				},
+				"delete": obj{
+					"summary":   "delete a page with it's history. only admins can delete pages",
+					"security":  secured,
+					"responses": merge(obj{"204": response("the page is deleted", "")}, errorResponses("401", "403", "404")),
+				},
+			},
+			"/search": obj{
+				"get": obj{
+					"summary": "search pages",
+					"parameters": []obj{
+						{"name": "q", "in": "query", "required": true, "description": "words to find", "schema": obj{"type": "string"}},
+					},
+					"responses": obj{"200": response("pages those have all the words", "APISearch")},
+				},
+			},
+			"/pages/{title}/append": obj{
+				"parameters": []obj{title},
+				"post": obj{
+					"summary":  "append a snippet to a page. the page is created if it does not exist",
+					"security": secured,
+					"requestBody": obj{"required": true, "content": merge(jsonContent("APIAppend"), obj{
+						"text/plain": obj{"schema": obj{"type": "string"}},
+					})},
+					"responses": merge(obj{
+						"200": response("the saved revision", "APIPage"),
+						"201": response("the page is created", "APIPage"),
+						"202": response("the edit is waiting for review", "APIPage"),
+					}, errorResponses("400", "401", "403", "413")),
+				},
+			},
+			"/pages/{title}/revisions": obj{
+				"parameters": []obj{title},
+				"get": obj{
+					"summary": "list revisions of a page from the latest one",
+					"parameters": []obj{
+						queryParam("from", "revision number to start from"),
+						queryParam("limit", "maximum number of revisions. (default 20, max 100)"),
+					},
+					"responses": merge(obj{"200": response("revisions", "APIRevisions")}, errorResponses("400", "404")),
+				},
+			},
+			"/pages/{title}/diff": obj{
+				"parameters": []obj{title},
+				"get": obj{
+					"summary": "diff between two revisions of a page",
+					"parameters": []obj{
+						queryParam("from", "old revision. the revision before to if omitted"),
+						queryParam("to", "new revision. the latest revision if omitted"),
+					},
+					"responses": merge(obj{"200": response("the diff", "Diff")}, errorResponses("400", "404")),
+				},
+			},
+			"/sync": obj{
+				"get": obj{
+					"summary":   "latest revision numbers of all pages, to sync with another wiki",
+					"responses": obj{"200": response("pages", "APISyncPages")},
+				},
+			},
+			"/sync/{title}": obj{
+				"parameters": []obj{title},
+				"post": obj{
+					"summary":     "add revisions to a page keeping their authors and times. only admins can sync pages",
+					"security":    secured,
+					"requestBody": obj{"required": true, "content": jsonContent("APISyncPush")},
+					"responses": merge(obj{
+						"200": response("the latest revision", "APIPage"),
+					}, errorResponses("400", "401", "403", "409", "413")),
+				},
+			},
+			"/backup": obj{
+				"get": obj{
+					"summary":  "a consistent snapshot of the database (whisky.db) of the running wiki. only admins can backup the wiki",
+					"security": secured,
+					"responses": merge(obj{
+						"200": obj{
+							"description": "the database file",
+							"content":     obj{"application/octet-stream": obj{"schema": obj{"type": "string", "format": "binary"}}},
+						},
+					}, errorResponses("401", "403")),
+				},
+			},
+		},
+		"components": obj{
+			"schemas": schemas,
+			"securitySchemes": obj{
+				"token": obj{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, openAPIDocument(siteURL(r)))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"html/template"
+	"sync"
+)
+
+// recently loaded revisions are kept in memory with their html, so popular pages
+// like Home are not read, decoded and rendered again for every view.
+//
+// revisions don't change once saved, so they are only forgotten when the page
+// is deleted, as numbers of a page made again start from 1.
+// the latest revision of a page is changed when it's saved by the wiki.
+//
+// only pages in whisky.db are cached, as other storages can be changed by others
+// than the wiki, like git commits or another wiki sharing the postgres database.
+
+// pageCacheSize is the maximum number of revisions in the cache, set by -page-cache.
+var pageCacheSize = 1000
+
+var pages = newPageCache()
+
+type pageKey struct {
+	title string
+	rev   uint64
+}
+
+type cachedPage struct {
+	key  pageKey
+	page Page
+	// html is rendered when it's first needed.
+	html template.HTML
+	// rendering is the render in progress, which other views of the revision wait for.
+	rendering *render
+}
+
+// render is a render of a revision, shared by views while it's rendered.
+type render struct {
+	done chan struct{}
+	html template.HTML
+}
+
+// pageCache is a LRU cache of revisions of pages.
+type pageCache struct {
+	mu sync.Mutex
+	// ll has the recently used revisions in front.
+	ll    *list.List
+	items map[pageKey]*list.Element
+	// latest is the latest revision of the cached pages.
+	latest map[string]uint64
+	// gen is increased whenever revisions are forgotten,
+	// not to add revisions loaded before that.
+	gen uint64
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{
+		ll:     list.New(),
+		items:  make(map[pageKey]*list.Element),
+		latest: make(map[string]uint64),
+	}
+}
+
+// get returns a copy of the revision of the page. 0 means the latest revision.
+func (c *pageCache) get(title string, rev uint64) (*Page, uint64, bool) {
+	if pageCacheSize <= 0 {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rev == 0 {
+		rev = c.latest[title]
+	}
+	el, ok := c.items[pageKey{title, rev}]
+	countCache("page", ok)
+	if !ok {
+		return nil, 0, false
+	}
+	c.ll.MoveToFront(el)
+	p := el.Value.(*cachedPage).page
+	return &p, rev, true
+}
+
+// generation returns the generation to add revisions loaded after now.
+func (c *pageCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add keeps a copy of the revision, which was loaded at the generation.
+// latest tells whether it was the latest revision when it was loaded.
+func (c *pageCache) add(p *Page, rev uint64, latest bool, gen uint64) {
+	if pageCacheSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	key := pageKey{p.Title, rev}
+	if latest && rev > c.latest[p.Title] {
+		c.latest[p.Title] = rev
+	}
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return
+	}
+	cp := &cachedPage{key: key, page: *p}
+	c.items[key] = c.ll.PushFront(cp)
+	for c.ll.Len() > pageCacheSize {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		k := el.Value.(*cachedPage).key
+		delete(c.items, k)
+		// the latest revision is only known while it's cached,
+		// or the map would keep every title ever viewed.
+		if c.latest[k.title] == k.rev {
+			delete(c.latest, k.title)
+		}
+	}
+}
+
+// saved adds a new revision of the page as the latest one.
+func (c *pageCache) saved(p *Page, rev uint64) {
+	cp := *p
+	cp.rev = rev
+	c.add(&cp, rev, true, c.generation())
+}
+
+// remove forgets revisions of the page.
+func (c *pageCache) remove(title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.latest, title)
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if cp := el.Value.(*cachedPage); cp.key.title == title {
+			c.ll.Remove(el)
+			delete(c.items, cp.key)
+		}
+		el = next
+	}
+}
+
+// purge forgets all pages, when the database is replaced.
+func (c *pageCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.ll.Init()
+	c.items = make(map[pageKey]*list.Element)
+	c.latest = make(map[string]uint64)
+}
+
+// html returns the html of the page, rendered once for a revision.
+func (c *pageCache) html(p *Page) template.HTML {
+	if p.rev == 0 || pageCacheSize <= 0 {
+		return template.HTML(renderMarkdown(p.Body))
+	}
+	c.mu.Lock()
+	el, ok := c.items[pageKey{p.Title, p.rev}]
+	var cp *cachedPage
+	if ok {
+		cp = el.Value.(*cachedPage)
+	}
+	// the body could be changed after it's loaded, like restoring a draft.
+	if cp == nil || !bytes.Equal(cp.page.Body, p.Body) {
+		c.mu.Unlock()
+		return template.HTML(renderMarkdown(p.Body))
+	}
+	if cp.html != "" {
+		html := cp.html
+		c.mu.Unlock()
+		return html
+	}
+	// a popular page just saved is viewed by many at once. render it only once.
+	if r := cp.rendering; r != nil {
+		c.mu.Unlock()
+		<-r.done
+		return r.html
+	}
+	r := &render{done: make(chan struct{})}
+	cp.rendering = r
+	c.mu.Unlock()
+	defer func() {
+		// the next view renders it again, if it panicked.
+		c.mu.Lock()
+		cp.rendering = nil
+		c.mu.Unlock()
+		close(r.done)
+	}()
+	bs, timedOut := renderMarkdownLimited(p.Body, fixLinks)
+	r.html = template.HTML(bs)
+	if !timedOut {
+		c.mu.Lock()
+		cp.html = r.html
+		c.mu.Unlock()
+	}
+	return r.html
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPageCacheLatestPruned(t *testing.T) {
+	defer func(n int) { pageCacheSize = n }(pageCacheSize)
+	pageCacheSize = 10
+	c := newPageCache()
+	for i := 0; i < 100; i++ {
+		c.saved(&Page{Title: "Page" + strconv.Itoa(i)}, 1)
+	}
+	if len(c.latest) != pageCacheSize {
+		t.Errorf("%d latest revisions are kept for %d cached revisions", len(c.latest), pageCacheSize)
+	}
+	for title := range c.latest {
+		if _, ok := c.items[pageKey{title, c.latest[title]}]; !ok {
+			t.Errorf("the latest revision of %q is kept after it's evicted", title)
+		}
+	}
+	if _, _, ok := c.get("Page0", 0); ok {
+		t.Error("an evicted page is found")
+	}
+	if _, _, ok := c.get("Page99", 0); !ok {
+		t.Error("a cached page is not found")
+	}
+
+	// older revisions are evicted before the latest one of the page.
+	c.saved(&Page{Title: "Page99"}, 2)
+	for i := 0; i < pageCacheSize-1; i++ {
+		c.saved(&Page{Title: "Other" + strconv.Itoa(i)}, 1)
+	}
+	if rev := c.latest["Page99"]; rev != 2 {
+		t.Errorf("the latest revision of Page99 is %d, want 2", rev)
+	}
+
+	c.remove("Page99")
+	if _, ok := c.latest["Page99"]; ok {
+		t.Error("the latest revision of a deleted page is kept")
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the pages index lists all the pages, sorted by title, last modified time or author,
+// and filtered by a title prefix, a namespace or a tag.
+//
+// a namespace is the part of titles before a slash, like "Project" of "Project/Plan".
+// tags are hashtags in the text of pages, like #draft. they are in the titles index
+// with the authors, so the pages are listed without loading them.
+//
+// it's paged by a cursor of the last page shown, not an offset,
+// so a page saved while going through the list doesn't shift it.
+
+var (
+	hashtag    = regexp.MustCompile(`(?:^|[\s(])#([\pL\pN_][\pL\pN_/-]*)`)
+	inlineCode = regexp.MustCompile("`+[^`]*`+")
+	hasLetter  = regexp.MustCompile(`\pL`)
+)
+
+// maxTags is the number of tags of a page kept in the index.
+const maxTags = 50
+
+// pageTags returns tags of the page's body, lowercased, in the order they appear.
+// tags in code are not tags, and a tag needs a letter, so #1 is not one.
+func pageTags(body []byte) []string {
+	var tags []string
+	seen := map[string]bool{}
+	var fence string
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if fence != "" {
+			if bytes.HasPrefix(bytes.TrimLeft(line, " "), []byte(fence)) {
+				fence = ""
+			}
+			continue
+		}
+		if m := fenceLine.FindSubmatch(line); m != nil {
+			fence = string(m[1])
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("    ")) || bytes.HasPrefix(line, []byte("\t")) {
+			continue
+		}
+		line = inlineCode.ReplaceAll(line, nil)
+		for _, m := range hashtag.FindAllSubmatch(line, -1) {
+			tag := strings.ToLower(strings.TrimRight(string(m[1]), "/-"))
+			if seen[tag] || !hasLetter.MatchString(tag) {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+			if len(tags) == maxTags {
+				return tags
+			}
+		}
+	}
+	return tags
+}
+
+// PageInfo is a page in the pages index.
+type PageInfo struct {
+	Title string
+	PageHead
+}
+
+// PageHeads returns heads of the pages with titles starting with the prefix, by titles.
+func (boltStore) PageHeads(ctx context.Context, prefix string) ([]PageInfo, error) {
+	var pages []PageInfo
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("titles")).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			info := PageInfo{Title: string(k)}
+			if err := fromBytes(v, &info.PageHead); err != nil {
+				return err
+			}
+			pages = append(pages, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// listPageHeads returns heads of the pages with titles starting with the prefix.
+// Other stores than bolt don't have the index, so the latest revisions are loaded.
+func listPageHeads(ctx context.Context, prefix string) ([]PageInfo, error) {
+	if s, ok := store.(interface {
+		PageHeads(ctx context.Context, prefix string) ([]PageInfo, error)
+	}); ok {
+		return s.PageHeads(ctx, prefix)
+	}
+	titles, err := listTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pages []PageInfo
+	for _, t := range titles {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		p, rev, err := loadRevision(ctx, t, 0)
+		if errors.Is(err, ErrNotFound) {
+			// deleted while listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, PageInfo{Title: t, PageHead: *newPageHead(p, rev)})
+	}
+	return pages, nil
+}
+
+// pageOrders are the orders the pages can be sorted by.
+// pages of the same author are sorted by titles.
+var pageOrders = map[string]func(a, b *PageInfo) bool{
+	"title": func(a, b *PageInfo) bool {
+		return a.Title < b.Title
+	},
+	// recently modified first.
+	"modified": func(a, b *PageInfo) bool {
+		if !a.Updated.Equal(b.Updated) {
+			return a.Updated.After(b.Updated)
+		}
+		return a.Title < b.Title
+	},
+	"author": func(a, b *PageInfo) bool {
+		if a.Author != b.Author {
+			return a.Author < b.Author
+		}
+		return a.Title < b.Title
+	},
+}
+
+// pageCursor is where a listing continues after.
+// it's the last page of the previous listing, with the key it's sorted by.
+type pageCursor struct {
+	Title   string
+	Updated time.Time
+	Author  string
+}
+
+func (c *pageCursor) String() string {
+	s := strings.Join([]string{c.Title, c.Updated.Format(time.RFC3339Nano), c.Author}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func parsePageCursor(s string) (*pageCursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, newError(ErrInvalid, "invalid cursor: %s", s)
+	}
+	parts := strings.SplitN(string(bs), "\n", 3)
+	if len(parts) != 3 {
+		return nil, newError(ErrInvalid, "invalid cursor: %s", s)
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return nil, newError(ErrInvalid, "invalid cursor: %s", s)
+	}
+	return &pageCursor{Title: parts[0], Updated: t, Author: parts[2]}, nil
+}
+
+// PageQuery is what pages are listed, and how.
+type PageQuery struct {
+	// Sort is one of pageOrders.
+	Sort      string
+	Prefix    string
+	Namespace string
+	Tag       string
+	After     *pageCursor
+	Limit     int
+}
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+func parsePageQuery(r *http.Request) (*PageQuery, error) {
+	q := r.URL.Query()
+	pq := &PageQuery{
+		Sort:      q.Get("sort"),
+		Prefix:    q.Get("prefix"),
+		Namespace: strings.Trim(q.Get("ns"), "/"),
+		Tag:       strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q.Get("tag")), "#")),
+		Limit:     defaultPageLimit,
+	}
+	if pq.Sort == "" {
+		pq.Sort = "title"
+	}
+	if pageOrders[pq.Sort] == nil {
+		return nil, newError(ErrInvalid, "unknown sort: %s", pq.Sort)
+	}
+	if s := q.Get("after"); s != "" {
+		c, err := parsePageCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		pq.After = c
+	}
+	if s := q.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, newError(ErrInvalid, "invalid number of pages: %s", s)
+		}
+		pq.Limit = min(n, maxPageLimit)
+	}
+	return pq, nil
+}
+
+// titlePrefix is the prefix of titles listed by the query.
+// the prefix is in the namespace when it's given.
+func (pq *PageQuery) titlePrefix() string {
+	if pq.Namespace == "" {
+		return pq.Prefix
+	}
+	return pq.Namespace + "/" + pq.Prefix
+}
+
+// values returns the query as url values, with the cursor.
+func (pq *PageQuery) values(after *pageCursor) url.Values {
+	v := url.Values{}
+	if pq.Sort != "title" {
+		v.Set("sort", pq.Sort)
+	}
+	for k, s := range map[string]string{"prefix": pq.Prefix, "ns": pq.Namespace, "tag": pq.Tag} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	if pq.Limit != defaultPageLimit {
+		v.Set("n", strconv.Itoa(pq.Limit))
+	}
+	if after != nil {
+		v.Set("after", after.String())
+	}
+	return v
+}
+
+// With returns the path of the first listing of the query with the key set to the value,
+// like sort, ns or tag. An empty value removes it.
+func (pq *PageQuery) With(key, value string) string {
+	v := pq.values(nil)
+	if value == "" {
+		v.Del(key)
+	} else {
+		v.Set(key, value)
+	}
+	if key == "ns" {
+		// the prefix was in the namespace.
+		v.Del("prefix")
+	}
+	return pagesPath(v)
+}
+
+func pagesPath(v url.Values) string {
+	if len(v) == 0 {
+		return "/pages"
+	}
+	return "/pages?" + v.Encode()
+}
+
+// Facet is a namespace or a tag, with the number of pages in it.
+type Facet struct {
+	Name  string
+	Pages int
+}
+
+// PagesPage is the pages index.
+type PagesPage struct {
+	// Title is empty, as it's not a page.
+	Title string
+	Query *PageQuery
+	Pages []PageInfo
+	// Total is the number of pages matched, in all listings.
+	Total int
+	// Namespaces are namespaces right in the namespace listed, by their full names.
+	Namespaces []Facet
+	// Tags are tags of the pages matched.
+	Tags []Facet
+	// Next is the path of the next listing, or empty at the end.
+	Next string
+}
+
+// facets returns the counts sorted by the number of pages, then by names.
+func facets(counts map[string]int) []Facet {
+	fs := make([]Facet, 0, len(counts))
+	for name, n := range counts {
+		fs = append(fs, Facet{Name: name, Pages: n})
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		if fs[i].Pages != fs[j].Pages {
+			return fs[i].Pages > fs[j].Pages
+		}
+		return fs[i].Name < fs[j].Name
+	})
+	return fs
+}
+
+// hasTag reports whether the tags have the tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// listPages lists the pages of the query.
+func listPages(ctx context.Context, pq *PageQuery) (*PagesPage, error) {
+	heads, err := listPageHeads(ctx, pq.titlePrefix())
+	if err != nil {
+		return nil, err
+	}
+	namespaces := map[string]int{}
+	tags := map[string]int{}
+	matched := heads[:0]
+	for _, h := range heads {
+		if pq.Tag != "" && !hasTag(h.Tags, pq.Tag) {
+			continue
+		}
+		matched = append(matched, h)
+		ns := ""
+		if pq.Namespace != "" {
+			ns = pq.Namespace + "/"
+		}
+		if i := strings.Index(h.Title[len(ns):], "/"); i > 0 {
+			namespaces[h.Title[:len(ns)+i]]++
+		}
+		for _, t := range h.Tags {
+			tags[t]++
+		}
+	}
+	less := pageOrders[pq.Sort]
+	sort.Slice(matched, func(i, j int) bool {
+		return less(&matched[i], &matched[j])
+	})
+	start := 0
+	if c := pq.After; c != nil {
+		after := &PageInfo{Title: c.Title, PageHead: PageHead{Updated: c.Updated, Author: c.Author}}
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(after, &matched[i])
+		})
+	}
+	end := min(start+pq.Limit, len(matched))
+	lp := &PagesPage{
+		Query:      pq,
+		Pages:      matched[start:end],
+		Total:      len(matched),
+		Namespaces: facets(namespaces),
+		Tags:       facets(tags),
+	}
+	if end < len(matched) {
+		last := matched[end-1]
+		lp.Next = pagesPath(pq.values(&pageCursor{Title: last.Title, Updated: last.Updated, Author: last.Author}))
+	}
+	return lp, nil
+}
+
+// Crumb is a page above a page in the title, like "Projects" of "Projects/Whisky".
+type Crumb struct {
+	Title string
+	// Name is the last part of the title.
+	Name string
+	// Missing is true when the page doesn't exist yet, and it's link creates it.
+	Missing bool
+}
+
+// breadcrumbs returns the pages above the page, from the top.
+func breadcrumbs(ctx context.Context, title string) ([]Crumb, error) {
+	var crumbs []Crumb
+	for i := 0; i < len(title); i++ {
+		// empty parts of the title, like the end of "Notes/", are not pages.
+		if title[i] != '/' || i == 0 || title[i-1] == '/' || i == len(title)-1 {
+			continue
+		}
+		t := title[:i]
+		ok, err := pageExists(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		crumbs = append(crumbs, Crumb{Title: t, Name: t[strings.LastIndex(t, "/")+1:], Missing: !ok})
+	}
+	return crumbs, nil
+}
+
+func pagesHandler(w http.ResponseWriter, r *http.Request) {
+	pq, err := parsePageQuery(r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	lp, err := listPages(r.Context(), pq)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "pages", lp)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// preferences are how a user likes pages to be shown.
+// they are kept in "preferences" bucket by user names,
+// and anonymous users, or users who didn't set them, get the defaults.
+
+// Preferences of a user. Empty values are the defaults.
+type Preferences struct {
+	// Timezone is a name of the tz database, like Asia/Seoul.
+	// Times are shown in the server's timezone without it.
+	Timezone string `json:",omitempty"`
+	// DateFormat is one of dateFormats.
+	DateFormat string `json:",omitempty"`
+	// HistoryPerPage is the number of revisions in a page of history.
+	HistoryPerPage int `json:",omitempty"`
+	// DiffStyle is "unified" or "split".
+	DiffStyle string `json:",omitempty"`
+	// Theme is "light", "dark", or "auto" which follows the system.
+	Theme string `json:",omitempty"`
+	// HomePage is the title of the page "/" goes to, instead of the home page of the wiki.
+	HomePage string `json:",omitempty"`
+}
+
+// dateFormats are layouts of times those users can choose.
+var dateFormats = map[string]string{
+	"iso":      "2006-01-02 15:04",
+	"us":       "Jan 2, 2006 3:04 PM",
+	"european": "02.01.2006 15:04",
+}
+
+var themes = []string{"light", "dark", "auto"}
+
+var diffStyles = []string{"unified", "split"}
+
+const (
+	defaultHistoryPerPage = 20
+	maxHistoryPerPage     = 500
+)
+
+// Location returns the timezone of the preferences.
+func (p *Preferences) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		// the tz database of the server could be changed after it's saved.
+		return time.Local
+	}
+	return loc
+}
+
+// FormatTime formats the time in the timezone and the date format of the preferences.
+func (p *Preferences) FormatTime(t time.Time) string {
+	layout, ok := dateFormats[p.DateFormat]
+	if !ok {
+		layout = dateFormats["iso"]
+	}
+	return t.In(p.Location()).Format(layout)
+}
+
+// relativeTime returns how long ago the time was, like "3 hours ago".
+// It returns false for a time older than a month, which is better shown as it is.
+func relativeTime(t, now time.Time) (string, bool) {
+	d := now.Sub(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		// a little in the future too, as clocks of servers differ.
+		return "just now", true
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute"), true
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour"), true
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day"), true
+	}
+	return "", false
+}
+
+// TimeHTML shows the time relative to now, with the formatted time on hover.
+// Old times are shown formatted.
+func (p *Preferences) TimeHTML(t time.Time) template.HTML {
+	abs := p.FormatTime(t)
+	rel, ok := relativeTime(t, time.Now())
+	if !ok {
+		rel = abs
+	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		t.Format(time.RFC3339), template.HTMLEscapeString(abs), template.HTMLEscapeString(rel)))
+}
+
+func (p *Preferences) historyPerPage() int {
+	if p.HistoryPerPage <= 0 {
+		return defaultHistoryPerPage
+	}
+	return p.HistoryPerPage
+}
+
+// oneOf returns the value if it's one of the choices, or the first choice.
+func oneOf(v string, choices []string) string {
+	for _, c := range choices {
+		if v == c {
+			return v
+		}
+	}
+	return choices[0]
+}
+
+func loadPreferences(user string) (*Preferences, error) {
+	p := &Preferences{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("preferences")).Get([]byte(user))
+		if bs == nil {
+			return nil
+		}
+		return fromBytes(bs, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func savePreferences(user string, p *Preferences) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("preferences")), []byte(user), p)
+	})
+}
+
+// userPreferences returns preferences of the user of the request.
+// The defaults are returned when they could not be loaded, as pages can be shown with them.
+func userPreferences(r *http.Request) *Preferences {
+	return preferencesOf(currentUser(r))
+}
+
+func preferencesOf(u *User) *Preferences {
+	if u == nil {
+		return &Preferences{}
+	}
+	p, err := loadPreferences(u.Name)
+	if err != nil {
+		return &Preferences{}
+	}
+	return p
+}
+
+// parsePreferences reads preferences from the form.
+func parsePreferences(r *http.Request) (*Preferences, error) {
+	p := &Preferences{
+		Timezone:   strings.TrimSpace(r.FormValue("timezone")),
+		DateFormat: r.FormValue("date_format"),
+		DiffStyle:  oneOf(r.FormValue("diff_style"), diffStyles),
+		Theme:      oneOf(r.FormValue("theme"), themes),
+		// "/view/" is allowed too, as users might paste the path of the page.
+		HomePage: strings.Trim(strings.TrimPrefix(strings.TrimSpace(r.FormValue("home_page")), "/view/"), "/"),
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return nil, newError(ErrInvalid, "unknown timezone: %s", p.Timezone)
+		}
+	}
+	if _, ok := dateFormats[p.DateFormat]; !ok {
+		return nil, newError(ErrInvalid, "unknown date format: %s", p.DateFormat)
+	}
+	if s := r.FormValue("history_per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxHistoryPerPage {
+			return nil, newError(ErrInvalid, "revisions in a page of history should be 1-%d", maxHistoryPerPage)
+		}
+		p.HistoryPerPage = n
+	}
+	return p, nil
+}
+
+// DateFormatChoice is a date format shown in the preferences page.
+type DateFormatChoice struct {
+	Name    string
+	Example string
+}
+
+type PreferencesPage struct {
+	// Title is empty, as it's not a page.
+	Title       string
+	Preferences *Preferences
+	DateFormats []DateFormatChoice
+	Saved       bool
+}
+
+func preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		httpError(w, r, newError(ErrForbidden, "please log in to change your preferences"))
+		return
+	}
+	if r.Method == "POST" {
+		p, err := parsePreferences(r)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if err := savePreferences(u.Name, p); err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/preferences?saved=1", http.StatusFound)
+		return
+	}
+	p, err := loadPreferences(u.Name)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	page := &PreferencesPage{Preferences: p, Saved: r.URL.Query().Get("saved") != ""}
+	now := time.Now()
+	for _, name := range []string{"iso", "us", "european"} {
+		page.DateFormats = append(page.DateFormats, DateFormatChoice{Name: name, Example: (&Preferences{Timezone: p.Timezone, DateFormat: name}).FormatTime(now)})
+	}
+	renderTemplate(w, r, "preferences", page)
+}
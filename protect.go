@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Protection is lock of a page.
+// When a page is locked, only admins and members of the Groups can edit it.
+type Protection struct {
+	Locked bool
+	Groups []string
+	// Reviewed page's edits should be approved by a reviewer
+	// before they become the latest revision.
+	Reviewed bool
+	By       string
+	Updated  time.Time
+}
+
+func loadProtection(title string) (*Protection, error) {
+	p := &Protection{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("protection")).Get([]byte(title))
+		if bs == nil {
+			return nil
+		}
+		return fromBytes(bs, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func saveProtection(title string, p *Protection) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("protection"))
+		if !p.Locked && !p.Reviewed {
+			return b.Delete([]byte(title))
+		}
+		return putRecord(b, []byte(title), p)
+	})
+}
+
+func (p *Protection) CanEdit(u *User) bool {
+	if !p.Locked {
+		return true
+	}
+	if u == nil {
+		return false
+	}
+	return u.Admin || u.InGroup(p.Groups...)
+}
+
+// canEdit checks the user of the request can edit the page.
+func canEdit(r *http.Request, title string) (bool, error) {
+	p, err := loadProtection(title)
+	if err != nil {
+		return false, err
+	}
+	return p.CanEdit(currentUser(r)), nil
+}
+
+type ProtectPage struct {
+	Title      string
+	Protection *Protection
+}
+
+func protectHandler(w http.ResponseWriter, r *http.Request, title string) {
+	u := currentUser(r)
+	if u == nil || !u.Admin {
+		httpError(w, r, newError(ErrForbidden, "only admins can protect pages"))
+		return
+	}
+	if r.Method == "POST" {
+		p := &Protection{
+			Locked:   r.FormValue("locked") != "",
+			Groups:   parseGroups(r.FormValue("groups")),
+			Reviewed: r.FormValue("reviewed") != "",
+			By:       u.Name,
+			Updated:  time.Now(),
+		}
+		err := saveProtection(title, p)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/view/"+title, http.StatusFound)
+		return
+	}
+	p, err := loadProtection(title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "protect", &ProtectPage{Title: title, Protection: p})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// behind a reverse proxy (nginx, the multi command, ...), the proxy is the
+// peer of every request. forwarded headers from trusted proxies tell who the
+// client is (X-Forwarded-For), whether it came with https (X-Forwarded-Proto),
+// and the id of the request (X-Request-ID).
+//
+// -behind-proxy trusts the peer whoever it is, for a wiki which can be reached
+// only through the proxy. -trusted-proxies trusts the addresses and networks.
+// a peer of a unix socket (see listen.go) is a proxy on the same host, and trusted.
+// headers from other peers are ignored, as anyone can send them.
+
+var (
+	// behindProxy is true when whisky is served by a reverse proxy (like the multi command).
+	behindProxy bool
+	// trustedProxies are networks of proxies of -trusted-proxies.
+	trustedProxies []*net.IPNet
+)
+
+// parseTrustedProxies parses comma separated addresses or networks (CIDR) of proxies.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address: %s", p)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			p += fmt.Sprintf("/%d", bits)
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network: %s", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether the address is one of -trusted-proxies.
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerAddr returns address of the peer of the request without the port.
+func peerAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromProxy reports whether the request is from a trusted proxy.
+func fromProxy(r *http.Request) bool {
+	return behindProxy || fromUnixSocket(r) || trustedProxy(peerAddr(r))
+}
+
+// clientAddr returns address of the client without the port.
+// behind proxies, it's the last address in X-Forwarded-For not of a trusted proxy.
+func clientAddr(r *http.Request) string {
+	addr := peerAddr(r)
+	if !fromProxy(r) {
+		return addr
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !trustedProxy(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// isHTTPS reports whether the client sent the request with https.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return fromProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requests are limited by rate, in total and from each client address,
+// not for one client to keep the single process wiki busy for others.
+// the limits are token buckets, which allow a burst of rateBurst of requests.
+// requests over the limits get 429 Too Many Requests.
+
+const (
+	rateBurst = 2 * time.Second
+	// rateSweepInterval is how often buckets of idle clients are forgotten.
+	rateSweepInterval = time.Minute
+)
+
+// rateBucket is a token bucket. a token is taken by a request.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket by rate per second up to the burst, and takes a token.
+// it reports false when there isn't a token to take.
+func (b *rateBucket) take(now time.Time, rate float64) bool {
+	burst := rate * rateBurst.Seconds()
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter limits requests per second in total and from a client. 0 is no limit.
+type rateLimiter struct {
+	rate   float64
+	ipRate float64
+
+	mu  sync.Mutex
+	all rateBucket
+	ips map[string]*rateBucket
+}
+
+func newRateLimiter(rate, ipRate float64) *rateLimiter {
+	l := &rateLimiter{rate: rate, ipRate: ipRate, ips: make(map[string]*rateBucket)}
+	if ipRate > 0 {
+		go l.sweep()
+	}
+	return l
+}
+
+// allow reports whether a request from the client address can be served at now.
+func (l *rateLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ipRate > 0 {
+		b := l.ips[ip]
+		if b == nil {
+			b = &rateBucket{}
+			l.ips[ip] = b
+		}
+		if !b.take(now, l.ipRate) {
+			return false
+		}
+	}
+	if l.rate > 0 && !l.all.take(now, l.rate) {
+		return false
+	}
+	return true
+}
+
+// sweep forgets clients those have a full bucket, which is the same as a new one.
+func (l *rateLimiter) sweep() {
+	for {
+		time.Sleep(rateSweepInterval)
+		l.mu.Lock()
+		for ip, b := range l.ips {
+			if time.Since(b.last) > rateBurst {
+				delete(l.ips, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// handler serves requests to h within the limits.
+func (l *rateLimiter) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientAddr(r), time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests. please try again later", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// on SIGHUP, whisky reloads what can change while it's running,
+// without closing the database or dropping requests in flight.
+//
+//   - the config file is read again. -log-level, -log-format and -access-log-file
+//     take effect. other settings need a restart, and changes of them are logged.
+//   - templates and assets are loaded again. (see tmplfs.go)
+//   - the access log file is opened again, for logrotate to move the old one away.
+//
+// a broken config file or template is logged, and the old one is kept.
+
+// reloadableFlags are flags those take effect by a reload.
+var reloadableFlags = map[string]bool{
+	"log-level":       true,
+	"log-format":      true,
+	"access-log-file": true,
+}
+
+// reloader reloads the wiki on SIGHUP.
+type reloader struct {
+	// configFile is the absolute path of -config, as the wiki changes the directory.
+	configFile string
+	// given are flags given in the command line or by environment variables,
+	// which the config file doesn't override.
+	given map[string]bool
+	// config is the settings of the config file when it was loaded.
+	config map[string]string
+
+	accessLog *accessLog
+}
+
+func newReloader(configFile string, given map[string]bool, config map[string]string) (*reloader, error) {
+	if configFile != "" {
+		var err error
+		configFile, err = filepath.Abs(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &reloader{configFile: configFile, given: given, config: config}, nil
+}
+
+// run reloads the wiki whenever it gets SIGHUP.
+func (rl *reloader) run() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		slog.Info("reloading")
+		rl.reload()
+	}
+}
+
+func (rl *reloader) reload() {
+	if rl.configFile != "" {
+		cfg, err := readConfig(flag.CommandLine, rl.configFile)
+		if err != nil {
+			slog.Error("could not reload the config file", "err", err)
+		} else {
+			rl.reloadConfig(cfg)
+		}
+	}
+	err := loadTemplates()
+	if err != nil {
+		slog.Error("could not reload templates", "err", err)
+	}
+	if rl.accessLog != nil {
+		err := rl.accessLog.reopen(rl.value("access-log-file"))
+		if err != nil {
+			slog.Error("could not reopen the access log", "err", err)
+		}
+	}
+}
+
+// reloadConfig applies settings of the config file those can be changed while running.
+func (rl *reloader) reloadConfig(cfg map[string]string) {
+	names := make(map[string]bool)
+	for name := range rl.config {
+		names[name] = true
+	}
+	for name := range cfg {
+		names[name] = true
+	}
+	changed := make([]string, 0, len(names))
+	for name := range names {
+		old, had := rl.config[name]
+		v, has := cfg[name]
+		if had != has || old != v {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	rl.config = cfg
+	for _, name := range changed {
+		if rl.given[name] || reloadableFlags[name] {
+			continue
+		}
+		slog.Warn("the setting needs a restart to take effect", "setting", name)
+	}
+	err := setupLogging(rl.value("log-level"), rl.value("log-format"))
+	if err != nil {
+		slog.Error("could not change logging", "err", err)
+	}
+}
+
+// value returns the value of the flag, as the command line and the config file say now.
+// it doesn't set the flag, which might be read by requests at the time.
+func (rl *reloader) value(name string) string {
+	f := flag.Lookup(name)
+	if rl.given[name] {
+		return f.Value.String()
+	}
+	if v, ok := rl.config[name]; ok {
+		return v
+	}
+	return f.DefValue
+}
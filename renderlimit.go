@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// a page which is huge or deeply nested (like thousands of nested lists or quotes)
+// could keep the cpu busy for every view. rendering stops at the limits,
+// and the page is shown as far as it's rendered, with a notice.
+//
+// markdown over maxRenderSize is not parsed at all, as parsing can't be stopped in the middle.
+// whisky doesn't include pages in pages, so the depth is of the markdown itself.
+
+var (
+	// maxRenderSize is the size of markdown to render in bytes, set by -max-render-size.
+	// it's larger than maxPageSize, for pages imported or saved before it was lowered.
+	maxRenderSize = 4 << 20
+	// renderTimeout is the time to render a page, set by -render-timeout. 0 means no limit.
+	renderTimeout = 2 * time.Second
+	// maxRenderDepth is the deepest nesting of blocks to render, set by -max-render-depth.
+	maxRenderDepth = 32
+)
+
+var renderLimited = newCounter("whisky_render_limited_total",
+	"pages rendered partially by the limit they hit. size, time or depth.")
+
+const partialRenderNotice = `<p class="notice">the rest of the page is not shown, as it's too large or complex to render. see the raw text of the page for all of it.</p>`
+
+// limitRenderSize cuts the markdown at the end of the last line within maxRenderSize.
+// it returns true when the markdown is cut.
+func limitRenderSize(body []byte) ([]byte, bool) {
+	if maxRenderSize <= 0 || len(body) <= maxRenderSize {
+		return body, false
+	}
+	body = body[:maxRenderSize]
+	if i := bytes.LastIndexByte(body, '\n'); i >= 0 {
+		body = body[:i+1]
+	}
+	renderLimited.inc("limit", "size")
+	return body, true
+}
+
+// renderLimiter stops rendering of a tree at the time and depth limits.
+type renderLimiter struct {
+	deadline time.Time
+	depth    int
+	// limited is the limit hit, empty until one is hit.
+	limited string
+	// nodes counts rendered nodes, not to look at the clock for every node.
+	nodes int
+}
+
+func newRenderLimiter() *renderLimiter {
+	l := &renderLimiter{}
+	if renderTimeout > 0 {
+		l.deadline = time.Now().Add(renderTimeout)
+	}
+	return l
+}
+
+// visit tells whether to render the node. nodes entered are always exited,
+// so the html is closed properly after a limit is hit.
+func (l *renderLimiter) visit(n *blackfriday.Node, entering bool) bool {
+	if !entering {
+		if isContainer(n) {
+			l.depth--
+		}
+		return true
+	}
+	if l.limited != "" {
+		return false
+	}
+	l.nodes++
+	if !l.deadline.IsZero() && l.nodes%256 == 0 && time.Now().After(l.deadline) {
+		l.limit("time")
+		return false
+	}
+	if isContainer(n) {
+		if maxRenderDepth > 0 && l.depth >= maxRenderDepth {
+			l.limit("depth")
+			return false
+		}
+		l.depth++
+	}
+	return true
+}
+
+func (l *renderLimiter) limit(name string) {
+	l.limited = name
+	renderLimited.inc("limit", name)
+}
+
+// isContainer reports whether the node has children, which is exited after them.
+// leaves are only entered.
+func isContainer(n *blackfriday.Node) bool {
+	switch n.Type {
+	case blackfriday.Text, blackfriday.Softbreak, blackfriday.Hardbreak, blackfriday.Code,
+		blackfriday.CodeBlock, blackfriday.HTMLBlock, blackfriday.HTMLSpan, blackfriday.HorizontalRule:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// a replica serves views from a copy of the primary's database,
+// to scale reads and to keep the wiki readable while the primary restarts.
+//
+// the copy is a backup of the primary (see backup.go) downloaded periodically,
+// and swapped with the database while no request is running.
+// requests those write (any method other than GET and HEAD, and logout)
+// are forwarded to the primary, and the replica syncs right after them,
+// so a writer sees it's write soon.
+// writes of the replica itself, like image caches, are lost on the next sync.
+
+type replicaServer struct {
+	primary  *apiClient
+	proxy    *httputil.ReverseProxy
+	interval time.Duration
+	syncNow  chan struct{}
+}
+
+// newReplica makes a replica of the primary wiki.
+// token is an api token of an admin of the primary, to download backups.
+func newReplica(primary, token string, interval time.Duration) (*replicaServer, error) {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid primary url: %s", primary)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("replica needs an api token of an admin of the primary")
+	}
+	rs := &replicaServer{
+		primary:  &apiClient{url: primary, token: token},
+		proxy:    httputil.NewSingleHostReverseProxy(u),
+		interval: interval,
+		syncNow:  make(chan struct{}, 1),
+	}
+	rs.proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode < 400 {
+			rs.requestSync()
+		}
+		return nil
+	}
+	rs.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		slog.Warn("could not forward to the primary", "err", err)
+		http.Error(w, "the wiki is read-only for a while. please try again later", http.StatusServiceUnavailable)
+	}
+	return rs, nil
+}
+
+// forwards reports whether the request should be served by the primary.
+func (rs *replicaServer) forwards(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+	return r.URL.Query().Get("logout") != ""
+}
+
+// handler serves the request with h, or forwards it to the primary.
+func (rs *replicaServer) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rs.forwards(r) {
+			if r.TLS != nil {
+				r.Header.Set("X-Forwarded-Proto", "https")
+			}
+			rs.proxy.ServeHTTP(w, r)
+			return
+		}
+		dbSwap.RLock()
+		defer dbSwap.RUnlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (rs *replicaServer) requestSync() {
+	select {
+	case rs.syncNow <- struct{}{}:
+	default:
+		// a sync is requested already.
+	}
+}
+
+// run syncs the database every interval, or when requested.
+func (rs *replicaServer) run() {
+	for {
+		select {
+		case <-time.After(rs.interval):
+		case <-rs.syncNow:
+		}
+		start := time.Now()
+		err := rs.sync()
+		observeJob("replica-sync", start, err)
+		if err != nil {
+			slog.Warn("could not sync with the primary", "err", err)
+		}
+	}
+}
+
+// download downloads a backup of the primary, and returns path of it.
+func (rs *replicaServer) download() (path string, err error) {
+	resp, err := rs.primary.send("GET", "/api/v1/backup", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	path = dbPath + ".replica"
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+		}
+	}()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return "", fmt.Errorf("backup is truncated: got %d of %d bytes", n, resp.ContentLength)
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+	// check it before swapping, not to lose the database we have.
+	b, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return "", fmt.Errorf("invalid backup: %v", err)
+	}
+	b.Close()
+	return path, nil
+}
+
+// init downloads the database from the primary when there isn't one, before it is opened.
+func (rs *replicaServer) init() error {
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil
+	}
+	path, err := rs.download()
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, dbPath)
+}
+
+// sync replaces the database with a new copy of the primary.
+func (rs *replicaServer) sync() error {
+	path, err := rs.download()
+	if err != nil {
+		return err
+	}
+	// jobs in background stop using the database too, see usingDB.
+	dbSwap.Lock()
+	defer dbSwap.Unlock()
+	err = db.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	renameErr := os.Rename(path, dbPath)
+	if renameErr != nil {
+		// reopen the database we have.
+		os.Remove(path)
+	}
+	// the replica can't serve anything without the database.
+	if err := openDB(); err != nil {
+		fatal("could not open the database synced", "err", err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	pages.purge()
+	searchIndex.reload()
+	err = loadSettings()
+	if err != nil {
+		return err
+	}
+	if imageProxy {
+		return loadImageProxyKey()
+	}
+	return nil
+}
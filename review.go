@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// edits of a reviewed page are not saved to the history directly.
+// they are queued in the pending bucket until a reviewer approves them.
+// reviewers are admins and members of reviewerGroup.
+
+const reviewerGroup = "reviewers"
+
+type PendingEdit struct {
+	ID   uint64
+	Page *Page
+	// Base is the revision the edit is made on, 0 for a new page.
+	// it's approved only when the page is not changed since.
+	Base uint64
+}
+
+func isReviewer(u *User) bool {
+	return u != nil && (u.Admin || u.InGroup(reviewerGroup))
+}
+
+// needsReview checks an edit of the user should wait for review.
+// u is nil for an anonymous user.
+func needsReview(u *User, title string) (bool, error) {
+	p, err := loadProtection(title)
+	if err != nil {
+		return false, err
+	}
+	return p.Reviewed && !isReviewer(u), nil
+}
+
+// queueEdit queues the edit made on the base revision for a review.
+func queueEdit(ctx context.Context, p *Page, base uint64) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("pending"))
+		id, _ := b.NextSequence()
+		bs, err := encodePrivate(&PendingEdit{ID: id, Page: p, Base: base})
+		if err != nil {
+			return err
+		}
+		return b.Put(byteID(id), bs)
+	})
+}
+
+func loadPendingEdit(ctx context.Context, id uint64) (*PendingEdit, error) {
+	e := &PendingEdit{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("pending")).Get(byteID(id))
+		if bs == nil {
+			return newError(ErrNotFound, "pending edit not exists")
+		}
+		return fromBytes(bs, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// loadPendingEdits returns pending edits of the page.
+// If title is empty, it returns all pending edits.
+func loadPendingEdits(ctx context.Context, title string) ([]*PendingEdit, error) {
+	edits := []*PendingEdit{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("pending")).ForEach(func(k, v []byte) error {
+			e := &PendingEdit{}
+			if err := fromBytes(v, e); err != nil {
+				return err
+			}
+			if title == "" || e.Page.Title == title {
+				edits = append(edits, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
+func removePendingEdit(ctx context.Context, id uint64) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("pending")).Delete(byteID(id))
+	})
+}
+
+// approveEdit saves the pending edit as the latest revision of the page.
+// It fails with ErrConflict when the page is changed after the edit was made,
+// not to revert the changes.
+func approveEdit(ctx context.Context, e *PendingEdit) error {
+	p := e.Page
+	prev, _ := loadPage(ctx, p.Title)
+	// it becomes a revision now. original author is kept.
+	p.Created = time.Now()
+	_, err := savePageOn(ctx, p, e.Base)
+	if errors.Is(err, ErrConflict) {
+		return newError(ErrConflict, "%s is edited after this edit was made, approving it would revert them. please reject it and ask the author to edit the page again", p.Title)
+	}
+	if err != nil {
+		return err
+	}
+	notifyPageChange(prev, p)
+	return removePendingEdit(ctx, e.ID)
+}
+
+type ReviewPage struct {
+	Title string
+	Edits []*PendingEdit
+	// Edit is set when a reviewer is looking at a pending edit.
+	Edit *PendingEdit
+}
+
+func reviewHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if !isReviewer(u) {
+		httpError(w, r, newError(ErrForbidden, "only reviewers can access this page"))
+		return
+	}
+	q := r.URL.Query()
+	if ids := q.Get("id"); ids != "" {
+		id, err := strconv.ParseUint(ids, 10, 64)
+		if err != nil {
+			notFound(w, r)
+			return
+		}
+		e, err := loadPendingEdit(r.Context(), id)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if r.Method == "POST" {
+			approved := q.Get("approve") != ""
+			switch {
+			case approved:
+				err = approveEdit(r.Context(), e)
+			case q.Get("reject") != "":
+				err = removePendingEdit(r.Context(), id)
+			default:
+				err = newError(ErrInvalid, "approve or reject?")
+			}
+			if err != nil {
+				httpError(w, r, err)
+				return
+			}
+			notifyReview(e, u.Name, approved)
+			http.Redirect(w, r, "/review", http.StatusFound)
+			return
+		}
+		renderTemplate(w, r, "review", &ReviewPage{Edit: e})
+		return
+	}
+	edits, err := loadPendingEdits(r.Context(), "")
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "review", &ReviewPage{Edits: edits})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// newReviewedWiki makes a wiki which has Home reviewed, with an admin alice and a user bob.
+func newReviewedWiki(t *testing.T) (srv *httptest.Server, alice, bob *testUser) {
+	t.Helper()
+	srv = newTestWiki(t)
+	alice = signup(t, srv, "alice")
+	bob = signup(t, srv, "bob")
+	if resp := alice.save("Home", "first"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not save Home: %s", resp.Status)
+	}
+	if resp := alice.submit("/protect/Home", url.Values{"reviewed": {"on"}}); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not protect Home: %s", resp.Status)
+	}
+	return srv, alice, bob
+}
+
+// pendingEdit returns the only pending edit of the page.
+func pendingEdit(t *testing.T, title string) *PendingEdit {
+	t.Helper()
+	edits, err := loadPendingEdits(context.Background(), title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 {
+		t.Fatalf("%d edits are waiting for review, want 1", len(edits))
+	}
+	return edits[0]
+}
+
+func reviewPath(e *PendingEdit, action string) string {
+	return "/review?id=" + strconv.FormatUint(e.ID, 10) + "&" + action + "=1"
+}
+
+func TestReviewQueue(t *testing.T) {
+	srv, _, bob := newReviewedWiki(t)
+	if resp := bob.save("Home", "by bob"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not submit an edit: %s", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "first" {
+		t.Errorf("Home is %q before the review, want %q", body, "first")
+	}
+	e := pendingEdit(t, "Home")
+	if string(e.Page.Body) != "by bob" || e.Page.Author != "bob" {
+		t.Errorf("pending edit is %q by %s", e.Page.Body, e.Page.Author)
+	}
+	if e.Base != 1 {
+		t.Errorf("pending edit is made on revision %d, want 1", e.Base)
+	}
+	// only reviewers can review.
+	if resp := bob.submit(reviewPath(e, "approve"), nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bob approved his edit: %s", resp.Status)
+	}
+}
+
+func TestReviewApprove(t *testing.T) {
+	srv, alice, bob := newReviewedWiki(t)
+	bob.save("Home", "by bob")
+	e := pendingEdit(t, "Home")
+	if resp := alice.submit(reviewPath(e, "approve"), nil); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not approve: %s", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "by bob" {
+		t.Errorf("Home is %q after the approval, want %q", body, "by bob")
+	}
+	edits, _ := loadPendingEdits(context.Background(), "Home")
+	if len(edits) != 0 {
+		t.Errorf("%d edits are waiting after the approval", len(edits))
+	}
+}
+
+func TestReviewReject(t *testing.T) {
+	srv, alice, bob := newReviewedWiki(t)
+	bob.save("Home", "by bob")
+	e := pendingEdit(t, "Home")
+	if resp := alice.submit(reviewPath(e, "reject"), nil); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not reject: %s", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "first" {
+		t.Errorf("Home is %q after the rejection, want %q", body, "first")
+	}
+	edits, _ := loadPendingEdits(context.Background(), "Home")
+	if len(edits) != 0 {
+		t.Errorf("%d edits are waiting after the rejection", len(edits))
+	}
+}
+
+func TestReviewApproveAfterEdit(t *testing.T) {
+	srv, alice, bob := newReviewedWiki(t)
+	bob.save("Home", "by bob")
+	e := pendingEdit(t, "Home")
+	// reviewers don't wait for a review.
+	if resp := alice.save("Home", "by alice"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not save Home: %s", resp.Status)
+	}
+	if resp := alice.submit(reviewPath(e, "approve"), nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("approved an edit made before the latest revision: %s, want 409", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "by alice" {
+		t.Errorf("Home is %q, want %q", body, "by alice")
+	}
+	// it's still there to be rejected.
+	pendingEdit(t, "Home")
+}
+
+func TestReviewNewPage(t *testing.T) {
+	srv, alice, bob := newReviewedWiki(t)
+	if resp := alice.submit("/protect/New", url.Values{"reviewed": {"on"}}); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not protect New: %s", resp.Status)
+	}
+	bob.save("New", "new page")
+	e := pendingEdit(t, "New")
+	if e.Base != 0 {
+		t.Errorf("pending edit of a new page is made on revision %d, want 0", e.Base)
+	}
+	if resp := alice.submit(reviewPath(e, "approve"), nil); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not approve: %s", resp.Status)
+	}
+	if body := pageBody(t, srv, "New"); body != "new page" {
+		t.Errorf("New is %q, want %q", body, "new page")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// search finds pages those have all words of the query in their title or text.
+// it looks up the search index (see searchindex.go), or reads every page without it.
+
+type SearchResult struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet,omitempty"`
+	score   int
+}
+
+type SearchPage struct {
+	Title   string
+	Query   string
+	Results []SearchResult
+}
+
+const maxSearchResults = 50
+
+// snippetSize is number of runes around the first match in a snippet.
+const snippetSize = 80
+
+func searchPages(ctx context.Context, query string) ([]SearchResult, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return []SearchResult{}, nil
+	}
+	results, indexed := searchIndex.search(words)
+	if !indexed {
+		var err error
+		results, err = scanPages(ctx, words)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].Title < results[j].Title
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	if indexed {
+		// snippets are made only for the results.
+		for i := range results {
+			p, err := loadPage(ctx, results[i].Title)
+			if errors.Is(err, ErrNotFound) {
+				// deleted, but not removed from the index yet.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			text := p.PlainText()
+			results[i].Snippet = snippet(text, strings.ToLower(text), words[0])
+		}
+	}
+	return results, nil
+}
+
+// scanPages finds pages those have all the words by reading every page.
+func scanPages(ctx context.Context, words []string) ([]SearchResult, error) {
+	pages, err := loadLatestPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	for t, p := range pages {
+		title := strings.ToLower(t)
+		text := p.PlainText()
+		lower := strings.ToLower(text)
+		score := 0
+		for _, w := range words {
+			n := strings.Count(lower, w)
+			if strings.Contains(title, w) {
+				// title match is more important than matches in the text.
+				n += 10
+			}
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score == 0 {
+			continue
+		}
+		results = append(results, SearchResult{Title: t, Snippet: snippet(text, lower, words[0]), score: score})
+	}
+	return results, nil
+}
+
+// snippet returns a part of the text around the word.
+// lower is lower cased text.
+func snippet(text, lower, word string) string {
+	i := strings.Index(lower, word)
+	if i < 0 {
+		i = 0
+	}
+	if len(lower) != len(text) {
+		// lower casing changed byte positions, start from the beginning.
+		i = 0
+	}
+	r := []rune(text[:i])
+	start := len(r) - snippetSize/2
+	if start < 0 {
+		start = 0
+	}
+	all := []rune(text)
+	end := start + snippetSize
+	if end > len(all) {
+		end = len(all)
+	}
+	s := strings.Join(strings.Fields(string(all[start:end])), " ")
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(all) {
+		s += "..."
+	}
+	return s
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	results, err := searchPages(r.Context(), q)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "search", &SearchPage{Query: q, Results: results})
+}
+
+// APISearch is the result of a search.
+type APISearch struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	results, err := searchPages(r.Context(), q)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &APISearch{Query: q, Results: results})
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the search index has words of the latest revisions of pages,
+// so a search doesn't read and render every page.
+//
+// saving a page queues it to a background worker, and the save doesn't wait for the index.
+// words of a page are kept in "search" bucket with the revision they are from,
+// and the index in memory is made from them when the wiki starts. pages not indexed
+// before the wiki stopped have other revisions in the titles index, and they are queued again.
+//
+// it's only for bolt storage, as other storages can be changed by others than the wiki.
+// searches read every page until the index is made.
+
+// SearchDoc is the words of a revision of a page.
+type SearchDoc struct {
+	Rev   uint64         `json:"rev"`
+	Words map[string]int `json:"words"`
+}
+
+var searchIndex = &searchIndexer{wake: make(chan struct{}, 1)}
+
+// searchIndexer keeps the search index, and updates it in the background.
+type searchIndexer struct {
+	mu      sync.RWMutex
+	started bool
+	ready   bool
+	// words has counts of the words in the pages.
+	words map[string]map[string]int
+	// docs are the indexed pages.
+	docs map[string]*SearchDoc
+
+	queueMu sync.Mutex
+	// queued are pages waiting for the worker, with when they are queued.
+	queued      map[string]time.Time
+	lastIndexed time.Time
+	wake        chan struct{}
+}
+
+// searchWords splits the text into lower cased words.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// start makes the index from the database, and starts the worker.
+func (ix *searchIndexer) start() {
+	ix.queueMu.Lock()
+	ix.queued = make(map[string]time.Time)
+	ix.queueMu.Unlock()
+	ix.mu.Lock()
+	ix.started = true
+	ix.mu.Unlock()
+	go ix.run()
+}
+
+// reload makes the index again, when the database is replaced.
+func (ix *searchIndexer) reload() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.started {
+		return
+	}
+	ix.ready = false
+	go ix.load()
+}
+
+func (ix *searchIndexer) run() {
+	ix.load()
+	for range ix.wake {
+		ix.queueMu.Lock()
+		titles := make([]string, 0, len(ix.queued))
+		for t := range ix.queued {
+			titles = append(titles, t)
+		}
+		ix.queueMu.Unlock()
+		for _, t := range titles {
+			// a page queued again while it's indexed stays in the queue.
+			ix.queueMu.Lock()
+			delete(ix.queued, t)
+			ix.queueMu.Unlock()
+			err := usingDB(func() error { return ix.index(t) })
+			if err != nil {
+				// it's indexed again when the wiki starts.
+				slog.Error("could not index the page", "page", t, "err", err)
+				continue
+			}
+			ix.queueMu.Lock()
+			ix.lastIndexed = time.Now()
+			ix.queueMu.Unlock()
+		}
+	}
+}
+
+// load makes the index in memory from "search" bucket,
+// and queues pages those are changed after they were indexed.
+func (ix *searchIndexer) load() {
+	start := time.Now()
+	words := make(map[string]map[string]int)
+	docs := make(map[string]*SearchDoc)
+	var stale []string
+	// it runs in background, also after a replica replaced the database.
+	err := usingDB(func() error {
+		return db.View(func(tx *bolt.Tx) error {
+			err := tx.Bucket([]byte("search")).ForEach(func(k, v []byte) error {
+				doc := &SearchDoc{}
+				if err := fromBytes(v, doc); err != nil {
+					// index it again.
+					stale = append(stale, string(k))
+					return nil
+				}
+				docs[string(k)] = doc
+				addWords(words, string(k), doc)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			titles := tx.Bucket([]byte("titles"))
+			err = titles.ForEach(func(k, v []byte) error {
+				h := &PageHead{}
+				if err := fromBytes(v, h); err != nil {
+					return err
+				}
+				if doc := docs[string(k)]; doc == nil || doc.Rev != h.Rev {
+					stale = append(stale, string(k))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for t := range docs {
+				if titles.Get([]byte(t)) == nil {
+					// deleted.
+					stale = append(stale, t)
+				}
+			}
+			return nil
+		})
+	})
+	observeJob("search-index", start, err)
+	if err != nil {
+		// searches keep reading every page.
+		slog.Error("could not load the search index", "err", err)
+		return
+	}
+	ix.mu.Lock()
+	ix.words = words
+	ix.docs = docs
+	ix.ready = true
+	ix.mu.Unlock()
+	if len(stale) != 0 {
+		slog.Info("indexing pages for search", "pages", len(stale))
+	}
+	for _, t := range stale {
+		ix.enqueue(t)
+	}
+}
+
+// enqueue queues the page to be indexed.
+func (ix *searchIndexer) enqueue(title string) {
+	ix.queueMu.Lock()
+	if ix.queued == nil {
+		// not started.
+		ix.queueMu.Unlock()
+		return
+	}
+	if _, ok := ix.queued[title]; !ok {
+		ix.queued[title] = time.Now()
+	}
+	ix.queueMu.Unlock()
+	select {
+	case ix.wake <- struct{}{}:
+	default:
+	}
+}
+
+// index indexes the latest revision of the page, or removes it when it's deleted.
+func (ix *searchIndexer) index(title string) error {
+	ctx := context.Background()
+	p, rev, err := loadRevision(ctx, title, 0)
+	if errors.Is(err, ErrNotFound) {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("search")).Delete([]byte(title))
+		})
+		if err != nil {
+			return err
+		}
+		ix.mu.Lock()
+		if doc := ix.docs[title]; doc != nil {
+			removeWords(ix.words, title, doc)
+			delete(ix.docs, title)
+		}
+		ix.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	doc := &SearchDoc{Rev: rev, Words: make(map[string]int)}
+	for _, w := range searchWords(p.PlainText()) {
+		doc.Words[w]++
+	}
+	bs, err := encodePrivate(doc)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("search")).Put([]byte(title), bs)
+	})
+	if err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	if old := ix.docs[title]; old != nil {
+		removeWords(ix.words, title, old)
+	}
+	if ix.docs != nil {
+		ix.docs[title] = doc
+		addWords(ix.words, title, doc)
+	}
+	ix.mu.Unlock()
+	return nil
+}
+
+func addWords(words map[string]map[string]int, title string, doc *SearchDoc) {
+	for w, n := range doc.Words {
+		if words[w] == nil {
+			words[w] = make(map[string]int)
+		}
+		words[w][title] = n
+	}
+}
+
+func removeWords(words map[string]map[string]int, title string, doc *SearchDoc) {
+	for w := range doc.Words {
+		delete(words[w], title)
+		if len(words[w]) == 0 {
+			delete(words, w)
+		}
+	}
+}
+
+// search returns pages those have all the words, without snippets.
+// It returns false when the index is not made yet.
+func (ix *searchIndexer) search(words []string) ([]SearchResult, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if !ix.ready {
+		return nil, false
+	}
+	var scores map[string]int
+	for i, w := range words {
+		found := make(map[string]int)
+		// words in pages those have the word in them match too, like the search reading pages.
+		for iw, counts := range ix.words {
+			if !strings.Contains(iw, w) {
+				continue
+			}
+			for t, n := range counts {
+				found[t] += n
+			}
+		}
+		for t := range ix.docs {
+			if strings.Contains(strings.ToLower(t), w) {
+				// title match is more important than matches in the text.
+				found[t] += 10
+			}
+		}
+		if i == 0 {
+			scores = found
+			continue
+		}
+		for t, score := range scores {
+			if n := found[t]; n != 0 {
+				scores[t] = score + n
+			} else {
+				delete(scores, t)
+			}
+		}
+	}
+	results := make([]SearchResult, 0, len(scores))
+	for t, score := range scores {
+		results = append(results, SearchResult{Title: t, score: score})
+	}
+	return results, true
+}
+
+// SearchIndexStats is the state of the search index.
+type SearchIndexStats struct {
+	Enabled bool
+	Ready   bool
+	Pages   int
+	Words   int
+	Queued  int
+	// Lag is how long the oldest page in the queue has waited.
+	Lag         time.Duration
+	LastIndexed time.Time
+}
+
+func (ix *searchIndexer) stats() SearchIndexStats {
+	ix.mu.RLock()
+	s := SearchIndexStats{Enabled: ix.started, Ready: ix.ready, Pages: len(ix.docs), Words: len(ix.words)}
+	ix.mu.RUnlock()
+	ix.queueMu.Lock()
+	defer ix.queueMu.Unlock()
+	s.Queued = len(ix.queued)
+	s.LastIndexed = ix.lastIndexed
+	now := time.Now()
+	for _, t := range ix.queued {
+		if lag := now.Sub(t); lag > s.Lag {
+			s.Lag = lag
+		}
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// a page can be edited a section at a time, from the edit links next to it's headings.
+// a section is a heading with the text under it, until the next heading of the same
+// or a higher level, so it has it's subsections. sections are numbered from 1.
+//
+// sections are found by reading lines of the markdown, which knows headings out of
+// lists, quotes and html blocks only. the links are shown only when the headings found
+// are the same with those rendered, so a link doesn't edit a wrong section.
+
+// section is where a section is in the body of the page.
+type section struct {
+	level      int
+	start, end int
+}
+
+var (
+	atxHeading     = regexp.MustCompile(`^#{1,6} `)
+	setextLine     = regexp.MustCompile(`^(=+|-+) *$`)
+	fenceLine      = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	nonParagraph   = regexp.MustCompile(`^( {4}|\t| {0,3}([-*+] |\d+[.)] |>|<))`)
+	renderedHeader = regexp.MustCompile(`<h([1-6])(?: id="[^"]*")?>`)
+)
+
+// findSections returns sections of the markdown in order.
+func findSections(body []byte) []section {
+	var secs []section
+	// fence is the fence of the code block the line is in.
+	var fence string
+	// text is where the last line of a paragraph starts, which becomes a heading
+	// by the underline of === or ---, or -1.
+	text := -1
+	// block is true in a list, a quote or a html block, until a blank line.
+	block := false
+	for off := 0; off < len(body); {
+		end := bytes.IndexByte(body[off:], '\n')
+		if end < 0 {
+			end = len(body)
+		} else {
+			end += off
+		}
+		line := string(body[off:end])
+		start := off
+		off = end + 1
+		switch {
+		case fence != "":
+			if strings.HasPrefix(strings.TrimLeft(line, " "), fence) {
+				fence = ""
+			}
+		case strings.TrimSpace(line) == "":
+			text, block = -1, false
+			continue
+		case block:
+		case fenceLine.MatchString(line):
+			fence = fenceLine.FindStringSubmatch(line)[1]
+		case atxHeading.MatchString(line):
+			secs = append(secs, section{level: strings.Index(line, " "), start: start})
+		case text >= 0 && setextLine.MatchString(line):
+			level := 1
+			if line[0] == '-' {
+				level = 2
+			}
+			secs = append(secs, section{level: level, start: text})
+		case text < 0 && nonParagraph.MatchString(line):
+			// indented code doesn't continue after a blank line, but it's not a heading either.
+			block = !strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "\t")
+		default:
+			text = start
+			continue
+		}
+		text = -1
+	}
+	for i := range secs {
+		secs[i].end = len(body)
+		for _, next := range secs[i+1:] {
+			if next.level <= secs[i].level {
+				secs[i].end = next.start
+				break
+			}
+		}
+	}
+	return secs
+}
+
+// pageSection returns the n-th section of the body.
+func pageSection(body []byte, n int) (section, error) {
+	secs := findSections(body)
+	if n < 1 || n > len(secs) {
+		return section{}, newError(ErrInvalid, "the page doesn't have section %d", n)
+	}
+	return secs[n-1], nil
+}
+
+// spliceSection returns the body with the section replaced by the text.
+func spliceSection(body []byte, s section, text []byte) []byte {
+	out := make([]byte, 0, len(body)-(s.end-s.start)+len(text)+1)
+	out = append(out, body[:s.start]...)
+	out = append(out, bytes.TrimRight(text, "\n")...)
+	if s.end < len(body) {
+		// keeps the blank lines before the next section, which the editor could trim.
+		old := body[s.start:s.end]
+		out = append(out, old[len(bytes.TrimRight(old, "\n")):]...)
+		if len(text) != 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+	} else if len(text) != 0 {
+		out = append(out, '\n')
+	}
+	return append(out, body[s.end:]...)
+}
+
+// editSection shows the editor of the n-th section of the page at the revision.
+// drafts are not saved from it, as they are of the whole page.
+func editSection(w http.ResponseWriter, r *http.Request, p *Page, rev uint64, n string, editors []string) {
+	num, err := strconv.Atoi(n)
+	if err != nil {
+		httpError(w, r, newError(ErrInvalid, "invalid section: %s", n))
+		return
+	}
+	s, err := pageSection(p.Body, num)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	sp := *p
+	sp.Body = p.Body[s.start:s.end]
+	sp.rev = 0
+	renderTemplate(w, r, "edit", &EditPage{Page: &sp, Editors: editors, Section: num, Rev: rev})
+}
+
+// saveSection puts the section edited into the latest revision of the page,
+// and returns the editor of the section to show again.
+// It fails when the page is changed after the editor was opened,
+// as the section could be moved.
+func saveSection(r *http.Request, p *Page, n string) (*EditPage, error) {
+	num, err := strconv.Atoi(n)
+	if err != nil {
+		return nil, newError(ErrInvalid, "invalid section: %s", n)
+	}
+	base, err := strconv.ParseUint(r.FormValue("rev"), 10, 64)
+	if err != nil {
+		return nil, newError(ErrInvalid, "invalid revision: %s", r.FormValue("rev"))
+	}
+	latest, rev, err := loadRevision(r.Context(), p.Title, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rev != base {
+		return nil, newError(ErrConflict, "the page is changed after you opened the editor. edit the section again")
+	}
+	s, err := pageSection(latest.Body, num)
+	if err != nil {
+		return nil, err
+	}
+	edited := *p
+	p.Body = spliceSection(latest.Body, s, p.Body)
+	return &EditPage{Page: &edited, Section: num, Rev: rev}, nil
+}
+
+// sectionEditLinks adds edit links of sections to headings of the page's html.
+// The html is returned as is when it's headings are not those found in the body.
+func sectionEditLinks(title string, html template.HTML, body []byte) template.HTML {
+	secs := findSections(body)
+	if len(secs) == 0 {
+		return html
+	}
+	s := string(html)
+	tags := renderedHeader.FindAllStringSubmatchIndex(s, -1)
+	if len(tags) != len(secs) {
+		return html
+	}
+	for i, t := range tags {
+		if int(s[t[2]]-'0') != secs[i].level {
+			return html
+		}
+	}
+	edit := template.HTMLEscapeString((&url.URL{Path: basePath + "/edit/" + title}).EscapedPath())
+	var b strings.Builder
+	last := 0
+	for i, t := range tags {
+		end := strings.Index(s[t[1]:], "</h"+s[t[2]:t[3]]+">")
+		if end < 0 {
+			return html
+		}
+		end += t[1]
+		b.WriteString(s[last:end])
+		b.WriteString(`<a class="section-edit" href="` + edit + "?section=" + strconv.Itoa(i+1) + `">edit</a>`)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return template.HTML(b.String())
+}
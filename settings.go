@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Settings are wiki wide settings which are stored in the db,
+// so they survive restarts and can be changed while the wiki is running.
+type Settings struct {
+	// License is the name of the license that the wiki contents are under.
+	// ex) CC BY-SA 4.0
+	License    string
+	LicenseURL string
+	// URL is the address of the wiki. (ex. https://wiki.example.com)
+	// It is used for links in messages sent out of the wiki, like webhooks.
+	URL string
+	// Banner is a notice shown at the top of every page until it's cleared,
+	// like an outage or a migration notice.
+	Banner string
+	// Logo and Favicon tell that the images are uploaded.
+	// The images are served at /logo and /favicon.ico.
+	Logo    bool
+	Favicon bool
+	// ImageVersion changes whenever an image is uploaded or removed.
+	// urls of the images have it, so browsers can cache them for long.
+	ImageVersion int64
+}
+
+var (
+	settingsMu sync.RWMutex
+	settings   = &Settings{}
+)
+
+func siteSettings() *Settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+func loadSettings() error {
+	s := &Settings{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("settings")).Get([]byte("site"))
+		if bs == nil {
+			return nil
+		}
+		return fromBytes(bs, s)
+	})
+	if err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	settings = s
+	settingsMu.Unlock()
+	return nil
+}
+
+func saveSettings(s *Settings) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("settings")), []byte("site"), s)
+	})
+	if err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	settings = s
+	settingsMu.Unlock()
+	return nil
+}
+
+type SettingsPage struct {
+	Title    string
+	Settings *Settings
+}
+
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := r.ParseMultipartForm(4 * maxImageSize)
+		if err != nil {
+			httpError(w, r, newError(ErrInvalid, "%v", err))
+			return
+		}
+		old := siteSettings()
+		s := &Settings{
+			License:      strings.TrimSpace(r.FormValue("license")),
+			LicenseURL:   strings.TrimSpace(r.FormValue("license_url")),
+			URL:          strings.TrimSuffix(strings.TrimSpace(r.FormValue("url")), "/"),
+			Banner:       strings.TrimSpace(r.FormValue("banner")),
+			Logo:         old.Logo,
+			Favicon:      old.Favicon,
+			ImageVersion: old.ImageVersion,
+		}
+		for _, img := range []struct {
+			name string
+			has  *bool
+		}{
+			{"logo", &s.Logo},
+			{"favicon", &s.Favicon},
+		} {
+			if r.FormValue("remove_"+img.name) != "" {
+				err := saveImage(img.name, nil)
+				if err != nil {
+					httpError(w, r, err)
+					return
+				}
+				*img.has = false
+				s.ImageVersion = time.Now().Unix()
+				continue
+			}
+			up, err := uploadedImage(r, img.name)
+			if err != nil {
+				httpError(w, r, err)
+				return
+			}
+			if up == nil {
+				continue
+			}
+			err = saveImage(img.name, up)
+			if err != nil {
+				httpError(w, r, err)
+				return
+			}
+			*img.has = true
+			s.ImageVersion = time.Now().Unix()
+		}
+		err = saveSettings(s)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/settings", http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "settings", &SettingsPage{Settings: siteSettings()})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// a standalone page is a single html file which has everything to show the page.
+// css is inlined, and images are embedded as data urls, so it can be
+// sent by email or archived without the wiki.
+//
+// links to other pages point the wiki, when the site url is set in settings.
+
+type StandalonePage struct {
+	*Page
+	Body     template.HTML
+	CSS      template.CSS
+	Exported time.Time
+}
+
+// standaloneHTML renders the page as a standalone html file.
+func standaloneHTML(ctx context.Context, p *Page) ([]byte, error) {
+	sp := &StandalonePage{
+		Page:     p,
+		Body:     template.HTML(renderMarkdownTree(p.Body, func(ast *blackfriday.Node) { inlineResources(ctx, ast) })),
+		Exported: time.Now(),
+	}
+	if a, ok := findAsset("whisky.css"); ok {
+		sp.CSS = template.CSS(a.Data)
+	}
+	t, err := currentTemplates()
+	if err != nil {
+		return nil, err
+	}
+	// the loaded templates are cloned for requests, which can't be done after they are executed.
+	t, err = t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	err = t.ExecuteTemplate(buf, "standalone.html", sp)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inlineResources replaces images of the tree with data urls,
+// and makes links to the wiki absolute.
+// An image which couldn't be loaded is left as it is.
+func inlineResources(ctx context.Context, ast *blackfriday.Node) {
+	site := strings.TrimSuffix(siteSettings().URL, "/")
+	ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		dest := string(n.LinkData.Destination)
+		switch n.Type {
+		case blackfriday.Image:
+			img, err := loadPageImage(ctx, dest)
+			if err != nil {
+				slog.Warn("could not inline image", "src", dest, "err", err)
+				if site != "" && strings.HasPrefix(dest, "/") {
+					n.LinkData.Destination = []byte(site + dest)
+				}
+				break
+			}
+			n.LinkData.Destination = []byte("data:" + img.ContentType + ";base64," + base64.StdEncoding.EncodeToString(img.Data))
+		case blackfriday.Link:
+			if site != "" && strings.HasPrefix(dest, "/") && !strings.HasPrefix(dest, "//") {
+				n.LinkData.Destination = []byte(site + dest)
+			}
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+// loadPageImage loads an image of a page, which is an attachment,
+// a static asset or an external image.
+func loadPageImage(ctx context.Context, dest string) (*Image, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		if img := loadCachedImage(dest); img != nil {
+			return img, nil
+		}
+		return fetchImage(ctx, dest)
+	case u.Scheme != "" || u.Host != "":
+		return nil, fmt.Errorf("unsupported url")
+	case strings.HasPrefix(u.Path, "/attachments/"):
+		p := strings.TrimPrefix(u.Path, "/attachments/")
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return nil, errPageNotExists
+		}
+		a, err := loadAttachment(ctx, p[:i], p[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		return &Image{ContentType: a.ContentType, Data: a.Data, Updated: a.Uploaded}, nil
+	case strings.HasPrefix(u.Path, "/static/"):
+		name := strings.TrimPrefix(u.Path, "/static/")
+		a, _ := staticAsset(name)
+		if a == nil {
+			return nil, newError(ErrNotFound, "asset not exists")
+		}
+		return &Image{ContentType: mime.TypeByExtension(path.Ext(name)), Data: a.Data, Updated: a.ModTime}, nil
+	}
+	return nil, fmt.Errorf("unsupported url")
+}
+
+// standaloneHandler serves the page as a standalone html file to download.
+func standaloneHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, _, err := loadRequestedPage(r, title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	data, err := standaloneHTML(r.Context(), p)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fname := path.Base(title) + ".html"
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fname}))
+	w.Write(data)
+}
+
+// exportHTMLCommand writes every page as a standalone html file to a directory.
+// files are named like titleFilename, with .html extension.
+func exportHTMLCommand(fs *flag.FlagSet, args []string) (err error) {
+	out := fs.String("o", "html", "output directory")
+	parseArgs(fs, args, "[flags]", -1)
+	err = loadTemplates()
+	if err != nil {
+		return err
+	}
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+	err = loadSettings()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	titles, err := listTitles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range titles {
+		p, err := loadPage(ctx, t)
+		if err != nil {
+			return err
+		}
+		data, err := standaloneHTML(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %v", t, err)
+		}
+		name := strings.TrimSuffix(titleFilename(t), filenameExt) + ".html"
+		fpath := filepath.Join(*out, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(fpath, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d pages to %s\n", len(titles), *out)
+	return nil
+}
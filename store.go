@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// pageStore keeps pages with their revisions.
+// Other data of the wiki (users, comments, settings, ...) are always kept in bolt.
+//
+// Revisions of a page are numbered from 1 in the order of saving.
+// 0 means the latest revision when loading a page.
+type pageStore interface {
+	// Save adds a new revision of the page, and returns it's number.
+	Save(ctx context.Context, p *Page) (uint64, error)
+	// Titles returns titles of all pages.
+	Titles(ctx context.Context) ([]string, error)
+	// Load loads a revision of the page with it's number.
+	Load(ctx context.Context, title string, id uint64) (*Page, uint64, error)
+	// History returns at most n revisions of the page from the revision, latest first.
+	// from -1 means the latest revision.
+	History(ctx context.Context, title string, from, n int) ([]Revision, error)
+	// Delete deletes the page with it's history.
+	Delete(ctx context.Context, title string) error
+}
+
+// store is the page store selected by -storage flag.
+var store pageStore = boltStore{}
+
+// boltStore keeps revisions of a page in a nested bucket of "history" bucket.
+// revisions of long pages are compressed, and encrypted when a key is given.
+//
+// "titles" bucket is an index of the pages, updated with them.
+// pages are listed and looked up with it, without going through their history.
+type boltStore struct{}
+
+// PageHead is the latest revision of a page in the titles index.
+type PageHead struct {
+	Rev     uint64
+	Updated time.Time
+	Size    int
+	Author  string   `json:",omitempty"`
+	Tags    []string `json:",omitempty"`
+	// Created is when the first revision is saved.
+	Created time.Time
+	// Links are titles of the other pages the page links to, sorted.
+	Links []string `json:",omitempty"`
+}
+
+// newPageHead returns the head of the page saved as the revision.
+// Created is not known from the revision, unless it's the first one.
+func newPageHead(p *Page, rev uint64) *PageHead {
+	h := &PageHead{Rev: rev, Updated: p.Created, Size: len(p.Body), Author: p.Author, Tags: pageTags(p.Body), Links: pageLinkTargets(p.Title, p.Body)}
+	if rev == 1 {
+		h.Created = p.Created
+	}
+	return h
+}
+
+func (s boltStore) Save(ctx context.Context, p *Page) (uint64, error) {
+	return s.save(ctx, p, nil)
+}
+
+// SaveOn adds a new revision of the page only when the latest revision is base,
+// or the page doesn't exist with base 0. It's checked in the transaction of the save,
+// so it fails with ErrConflict when another save came first.
+func (s boltStore) SaveOn(ctx context.Context, p *Page, base uint64) (uint64, error) {
+	return s.save(ctx, p, &base)
+}
+
+func (boltStore) save(ctx context.Context, p *Page, base *uint64) (uint64, error) {
+	pageBytes, err := encodePrivate(p)
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	// a bot appending to a log page saves it many times a second.
+	// those saves are written together.
+	err = batchTx(ctx, func(tx *bolt.Tx) error {
+		if base != nil {
+			if rev := latestRevision(tx, p.Title); rev != *base {
+				return newError(ErrConflict, "the page has revision %d, not %d", rev, *base)
+			}
+		}
+		var err error
+		id, err = putRevision(tx, p, pageBytes)
+		if err != nil {
+			return err
+		}
+		return putChange(tx.Bucket([]byte("changes")), newChange(p, id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SaveBatch saves the revisions in a transaction.
+func (s boltStore) SaveBatch(ctx context.Context, ps []*Page) ([]uint64, error) {
+	return s.saveAll(ctx, ps, nil)
+}
+
+// SaveBatchOn saves revisions of a page in a transaction, like SaveOn.
+// They are saved only when the latest revision of the page is base, checked in the transaction.
+func (s boltStore) SaveBatchOn(ctx context.Context, ps []*Page, base uint64) ([]uint64, error) {
+	return s.saveAll(ctx, ps, &base)
+}
+
+func (boltStore) saveAll(ctx context.Context, ps []*Page, base *uint64) ([]uint64, error) {
+	// encoding (and compressing, encrypting) is done outside of the transaction,
+	// not to block other writers longer.
+	pageBytes := make([][]byte, len(ps))
+	for i, p := range ps {
+		bs, err := encodePrivate(p)
+		if err != nil {
+			return nil, err
+		}
+		pageBytes[i] = bs
+	}
+	ids := make([]uint64, len(ps))
+	err := updateTx(ctx, func(tx *bolt.Tx) error {
+		if base != nil && len(ps) != 0 {
+			if rev := latestRevision(tx, ps[0].Title); rev != *base {
+				return newError(ErrConflict, "the page has revision %d, not %d", rev, *base)
+			}
+		}
+		for i, p := range ps {
+			id, err := putRevision(tx, p, pageBytes[i])
+			if err != nil {
+				return err
+			}
+			err = putChange(tx.Bucket([]byte("changes")), newChange(p, id))
+			if err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// KeepsChanges is true, as revisions are added to the recent changes
+// in the transactions saving them.
+func (boltStore) KeepsChanges() bool {
+	return true
+}
+
+// latestRevision returns the number of the latest revision of the page, or 0 if it doesn't exist.
+func latestRevision(tx *bolt.Tx, title string) uint64 {
+	b := tx.Bucket([]byte("history")).Bucket([]byte(title))
+	if b == nil {
+		return 0
+	}
+	k, _ := b.Cursor().Last()
+	if k == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(k)
+}
+
+// putRevision adds the encoded revision of the page, and returns it's number.
+func putRevision(tx *bolt.Tx, p *Page, pageBytes []byte) (uint64, error) {
+	b, err := tx.Bucket([]byte("history")).CreateBucketIfNotExists([]byte(p.Title))
+	if err != nil {
+		return 0, fmt.Errorf("could not create bucket: %s", err)
+	}
+	id, _ := b.NextSequence()
+	err = b.Put(byteID(id), pageBytes)
+	if err != nil {
+		return 0, err
+	}
+	titles := tx.Bucket([]byte("titles"))
+	head := newPageHead(p, id)
+	old := &PageHead{}
+	if bs := titles.Get([]byte(p.Title)); bs != nil {
+		// a broken head is made again, without the links of it.
+		if fromBytes(bs, old) == nil && head.Created.IsZero() {
+			head.Created = old.Created
+		}
+	}
+	err = updateBacklinks(tx, p.Title, old.Links, head.Links)
+	if err != nil {
+		return 0, err
+	}
+	return id, putRecord(titles, []byte(p.Title), head)
+}
+
+func (boltStore) Titles(ctx context.Context) ([]string, error) {
+	titles := []string{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("titles")).ForEach(func(k, v []byte) error {
+			titles = append(titles, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// Exists reports whether the page exists.
+func (boltStore) Exists(ctx context.Context, title string) (bool, error) {
+	var ok bool
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		ok = tx.Bucket([]byte("titles")).Get([]byte(title)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (boltStore) Load(ctx context.Context, title string, id uint64) (*Page, uint64, error) {
+	page := &Page{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("history")).Bucket([]byte(title))
+		if b == nil {
+			return errPageNotExists
+		}
+		var pageBytes []byte
+		if id == 0 {
+			// bolt's id creator (Bucket.NextSequence) create ids from 1,
+			// I will treat 0 as latest revision.
+			c := b.Cursor()
+			var k []byte
+			k, pageBytes = c.Last()
+			if k != nil {
+				id = binary.BigEndian.Uint64(k)
+			}
+		} else {
+			pageBytes = b.Get(byteID(id))
+		}
+		if pageBytes == nil {
+			return errPageNotExists
+		}
+		if err := fromBytes(pageBytes, page); err != nil {
+			return revisionCorrupted(title, id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return page, id, nil
+}
+
+func (boltStore) History(ctx context.Context, title string, from, n int) ([]Revision, error) {
+	var revs []Revision
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("history")).Bucket([]byte(title))
+		if b == nil {
+			return errPageNotExists
+		}
+		c := b.Cursor()
+
+		var (
+			k []byte
+			v []byte
+		)
+		if from == -1 {
+			k, v = c.Last()
+			if k == nil {
+				return errPageNotExists
+			}
+		} else {
+			idb := make([]byte, 8)
+			binary.BigEndian.PutUint64(idb, uint64(from))
+			k, v = c.Seek(idb)
+			if bytes.Compare(k, idb) != 0 {
+				return errPageNotExists
+			}
+		}
+		i := 0
+		for ; k != nil; k, v = c.Prev() {
+			// first iteration's k, v come from outside of this loop.
+			if i >= n {
+				break
+			}
+			p := &Page{}
+			if err := fromBytes(v, p); err != nil {
+				return revisionCorrupted(title, binary.BigEndian.Uint64(k), err)
+			}
+			revs = append(revs, Revision{Num: int(binary.BigEndian.Uint64(k)), Created: p.Created, Author: p.Author, Summary: p.Summary, Provenance: p.Provenance})
+			i++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// revisionCorrupted returns an error for a revision which could not be decoded.
+// It is logged too, as operators should restore it from a backup.
+func revisionCorrupted(title string, id uint64, err error) error {
+	slog.Error("corrupted revision", "page", title, "rev", id, "err", err)
+	return newError(ErrCorrupted, "revision %d of %s is corrupted. please tell it to the administrator", id, title)
+}
+
+func (boltStore) Delete(ctx context.Context, title string) error {
+	return updateTx(ctx, func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("history")).DeleteBucket([]byte(title))
+		if err == bolt.ErrBucketNotFound {
+			return errPageNotExists
+		}
+		if err != nil {
+			return err
+		}
+		titles := tx.Bucket([]byte("titles"))
+		head := &PageHead{}
+		if bs := titles.Get([]byte(title)); bs != nil && fromBytes(bs, head) == nil {
+			// links to the page are still there, but not it's links.
+			if err := updateBacklinks(tx, title, head.Links, nil); err != nil {
+				return err
+			}
+		}
+		return titles.Delete([]byte(title))
+	})
+}
+
+// titlesIndexVersion is the version of the titles index.
+// The index is built again when it's older, as for authors and tags of version 2,
+// and links and creation times of version 3.
+const titlesIndexVersion = 3
+
+// indexTitles builds the titles index from the history,
+// for a database written before the index, or before the version of it.
+func indexTitles() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		settings := tx.Bucket([]byte("settings"))
+		if v, _ := strconv.Atoi(string(settings.Get([]byte("titles-indexed")))); v >= titlesIndexVersion {
+			return nil
+		}
+		n, err := rebuildTitles(tx)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			slog.Info("indexed pages", "pages", n)
+		}
+		return settings.Put([]byte("titles-indexed"), []byte(strconv.Itoa(titlesIndexVersion)))
+	})
+}
+
+// rebuildTitles makes the titles index again from the latest revisions of pages,
+// and returns the number of the pages.
+func rebuildTitles(tx *bolt.Tx) (int, error) {
+	err := tx.DeleteBucket([]byte("titles"))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return 0, err
+	}
+	titles, err := tx.CreateBucket([]byte("titles"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = tx.Bucket([]byte("history")).ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		last, bs := tx.Bucket([]byte("history")).Bucket(k).Cursor().Last()
+		if last == nil {
+			return nil
+		}
+		rev := binary.BigEndian.Uint64(last)
+		head, err := pageHead(rev, bs)
+		if err != nil {
+			// the page is still there, even if it's revision is broken.
+			slog.Error("corrupted revision", "page", string(k), "rev", rev, "err", err)
+			head = &PageHead{Rev: rev}
+		}
+		if first, bs := tx.Bucket([]byte("history")).Bucket(k).Cursor().First(); head.Created.IsZero() && first != nil {
+			p := &Page{}
+			if decodeRecord(bs, p) == nil {
+				head.Created = p.Created
+			}
+		}
+		n++
+		return putRecord(titles, k, head)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, rebuildBacklinks(tx)
+}
+
+// pageHead returns the head of the page from it's latest revision.
+func pageHead(rev uint64, bs []byte) (*PageHead, error) {
+	p := &Page{}
+	if err := decodeRecord(bs, p); err != nil {
+		return nil, err
+	}
+	return newPageHead(p, rev), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the search box in the header suggests titles starting with what is typed.
+
+// maxSuggestions is the number of titles suggested at once.
+const maxSuggestions = 10
+
+// APISuggest is the titles suggested for a query.
+type APISuggest struct {
+	Query  string   `json:"query"`
+	Titles []string `json:"titles"`
+	// Exists is true when there is a page of the query as it's title.
+	// the page can be created when it's not.
+	Exists bool `json:"exists"`
+}
+
+// TitlesWithPrefix returns at most n titles starting with the prefix, in order.
+// It seeks the titles index, instead of listing all the pages.
+func (boltStore) TitlesWithPrefix(ctx context.Context, prefix string, n int) ([]string, error) {
+	titles := []string{}
+	err := viewTx(ctx, func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("titles")).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p) && len(titles) < n; k, _ = c.Next() {
+			titles = append(titles, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// titlesWithPrefix returns at most n titles starting with the prefix.
+// titles are case sensitive, so is the prefix.
+func titlesWithPrefix(ctx context.Context, prefix string, n int) ([]string, error) {
+	if s, ok := store.(interface {
+		TitlesWithPrefix(ctx context.Context, prefix string, n int) ([]string, error)
+	}); ok {
+		return s.TitlesWithPrefix(ctx, prefix, n)
+	}
+	all, err := listTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	titles := []string{}
+	for _, t := range all {
+		if strings.HasPrefix(t, prefix) && len(titles) < n {
+			titles = append(titles, t)
+		}
+	}
+	return titles, nil
+}
+
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	s := &APISuggest{Query: q, Titles: []string{}}
+	if q != "" {
+		var err error
+		s.Titles, err = titlesWithPrefix(r.Context(), q, maxSuggestions)
+		if err != nil {
+			apiError(w, err)
+			return
+		}
+		s.Exists = len(s.Titles) != 0 && s.Titles[0] == q
+	}
+	// suggestions are asked on every key, they are cached for a while.
+	w.Header().Set("Cache-Control", "private, max-age=10")
+	writeJSON(w, http.StatusOK, s)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sync exchanges revisions of pages between two wikis,
+// like a laptop wiki used offline and the office wiki.
+//
+//	whisky sync [-pull] [-push] [-conflict skip|local|remote] -url <remote> -token <admin token>
+//
+// it runs in the local wiki directory while the local wiki is stopped,
+// and talks to the remote wiki through the api.
+//
+//	GET  /api/v1/sync          latest revision numbers of all pages
+//	POST /api/v1/sync/<title>  add revisions to the page (admins only)
+//
+// the local wiki remembers the revision numbers of both sides at the last sync
+// of each page. a side has changes when it has newer revisions than those.
+// when both sides have changes, the page is in conflict, and it is resolved by
+// the conflict policy. skip leaves the page as is, local sends the local
+// revisions on top of the remote ones, and remote does the opposite.
+// either way, the revisions of the losing side are kept in it's own history.
+//
+// deleting a page is not synced.
+
+type APISyncPage struct {
+	Title    string `json:"title"`
+	Revision uint64 `json:"revision"`
+}
+
+type APISyncPages struct {
+	Pages []APISyncPage `json:"pages"`
+}
+
+// APISyncPush is a request body for adding revisions to a page.
+// Base should be the latest revision of the page in the wiki,
+// or the request is refused as a conflict. It is 0 for a new page.
+type APISyncPush struct {
+	Base      uint64     `json:"base"`
+	Revisions []*APIPage `json:"revisions"`
+}
+
+// SyncState is revision numbers of a page at the last sync with a remote.
+type SyncState struct {
+	Local  uint64
+	Remote uint64
+}
+
+func syncKey(remote, title string) []byte {
+	return []byte(remote + "\x00" + title)
+}
+
+func loadSyncState(remote, title string) (*SyncState, error) {
+	st := &SyncState{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("sync")).Get(syncKey(remote, title))
+		if bs == nil {
+			return nil
+		}
+		return fromBytes(bs, st)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func saveSyncState(remote, title string, st *SyncState) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("sync")), syncKey(remote, title), st)
+	})
+}
+
+// maxSyncPush is the size of a push request, which has many revisions of a page.
+// the client pushes more revisions than it in several requests.
+const maxSyncPush = maxRequestBody
+
+func apiSyncHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/sync"), "/")
+	switch {
+	case r.Method == "GET" && title == "":
+		apiSyncPages(w, r)
+	case r.Method == "POST" && title != "":
+		apiSyncPush(w, r, title)
+	default:
+		apiError(w, newError(ErrNotFound, "unknown api: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func apiSyncPages(w http.ResponseWriter, r *http.Request) {
+	titles, err := listTitles(r.Context())
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	res := &APISyncPages{Pages: []APISyncPage{}}
+	for _, t := range titles {
+		_, rev, err := loadRevision(r.Context(), t, 0)
+		if err != nil {
+			apiError(w, err)
+			return
+		}
+		res.Pages = append(res.Pages, APISyncPage{Title: t, Revision: rev})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// apiSyncPush saves the revisions with their authors and times.
+// Only admins can do it, as it can write revisions as anyone.
+func apiSyncPush(w http.ResponseWriter, r *http.Request, title string) {
+	u := apiUser(w, r)
+	if u == nil {
+		return
+	}
+	if !u.Admin {
+		apiError(w, newError(ErrForbidden, "only admins can sync pages"))
+		return
+	}
+	data, err := readBody(r, maxSyncPush)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	push := &APISyncPush{}
+	err = json.Unmarshal(data, push)
+	if err != nil {
+		apiError(w, newError(ErrInvalid, "invalid json: %v", err))
+		return
+	}
+	ps := make([]*Page, len(push.Revisions))
+	for i, ap := range push.Revisions {
+		ps[i] = fromAPIPage(ap)
+		ps[i].Title = title
+		if len(ps[i].Body) > maxPageSize {
+			apiError(w, pageTooLarge())
+			return
+		}
+	}
+	// the base is checked with saving them, not to miss an edit saved in the meantime.
+	err = savePagesOn(r.Context(), ps, push.Base)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	p, rev, err := loadRevision(r.Context(), title, 0)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIPage(p, rev))
+}
+
+func fromAPIPage(ap *APIPage) *Page {
+	return &Page{
+		Title:       ap.Title,
+		Body:        []byte(ap.Body),
+		Created:     ap.Created,
+		Author:      ap.Author,
+		Summary:     ap.Summary,
+		Attribution: ap.Attribution,
+		Provenance:  ap.Provenance,
+	}
+}
+
+// syncCommand syncs the local wiki with the remote wiki.
+func syncCommand(fs *flag.FlagSet, args []string) (err error) {
+	c := &apiClient{}
+	fs.StringVar(&c.url, "url", os.Getenv("WHISKY_URL"), "address of the remote wiki")
+	fs.StringVar(&c.token, "token", os.Getenv("WHISKY_TOKEN"), "api token of an admin of the remote wiki")
+	pull := fs.Bool("pull", false, "only get revisions from the remote")
+	push := fs.Bool("push", false, "only send revisions to the remote")
+	conflict := fs.String("conflict", "skip", "what to do when both wikis changed a page.\nskip it, or let local or remote revisions be the latest")
+	parseArgs(fs, args, "[flags]", -1)
+	if c.url == "" {
+		return fmt.Errorf("-url of the remote wiki is needed")
+	}
+	if *conflict != "skip" && *conflict != "local" && *conflict != "remote" {
+		return fmt.Errorf("unknown conflict policy: %s", *conflict)
+	}
+	if !*pull && !*push {
+		*pull, *push = true, true
+	}
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx := context.Background()
+	remote := strings.TrimSuffix(c.url, "/")
+	rpages := &APISyncPages{}
+	err = c.do("GET", "/api/v1/sync", nil, rpages)
+	if err != nil {
+		return err
+	}
+	remoteRevs := make(map[string]uint64)
+	titles := []string{}
+	for _, p := range rpages.Pages {
+		remoteRevs[p.Title] = p.Revision
+		titles = append(titles, p.Title)
+	}
+	locals, err := listTitles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range locals {
+		if _, ok := remoteRevs[t]; !ok {
+			titles = append(titles, t)
+		}
+	}
+	var pulled, pushed, conflicts int
+	for _, t := range titles {
+		st, err := loadSyncState(remote, t)
+		if err != nil {
+			return err
+		}
+		_, local, err := loadRevision(ctx, t, 0)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		rrev := remoteRevs[t]
+		if local < st.Local || rrev < st.Remote {
+			fmt.Fprintf(os.Stderr, "skip %s: the page was deleted after the last sync\n", t)
+			conflicts++
+			continue
+		}
+		localChanged, remoteChanged := local > st.Local, rrev > st.Remote
+		if localChanged && remoteChanged {
+			switch *conflict {
+			case "skip":
+				fmt.Fprintf(os.Stderr, "conflict %s: both wikis changed the page\n", t)
+				conflicts++
+				continue
+			case "local":
+				remoteChanged = false
+			case "remote":
+				localChanged = false
+			}
+		}
+		if remoteChanged && *pull {
+			for n := st.Remote + 1; n <= rrev; n++ {
+				ap := &APIPage{}
+				err = c.do("GET", pagePath(t)+"?rev="+strconv.FormatUint(n, 10), nil, ap)
+				if err != nil {
+					return fmt.Errorf("%s: %v", t, err)
+				}
+				p := fromAPIPage(ap)
+				p.Title = t
+				local, err = store.Save(ctx, p)
+				if err != nil {
+					return err
+				}
+				if !storeKeepsChanges() {
+					err = addChange(ctx, p, local)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			st = &SyncState{Local: local, Remote: rrev}
+			fmt.Fprintf(os.Stderr, "pull %s: revision %d\n", t, local)
+			pulled++
+		}
+		if localChanged && *push {
+			st, err = pushRevisions(ctx, c, remote, t, st, rrev, local)
+			if err != nil {
+				return fmt.Errorf("%s: %v", t, err)
+			}
+			fmt.Fprintf(os.Stderr, "push %s: revision %d\n", t, st.Remote)
+			pushed++
+		}
+		err = saveSyncState(remote, t, st)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "pulled %d pages, pushed %d pages, skipped %d pages\n", pulled, pushed, conflicts)
+	return nil
+}
+
+// pushRevisions pushes the local revisions after the state to the remote, which has rrev.
+// they are pushed in requests smaller than maxSyncPush, each on the revision of the last one.
+// the state is saved after each request, so a failed push can be continued.
+func pushRevisions(ctx context.Context, c *apiClient, remote, title string, st *SyncState, rrev, local uint64) (*SyncState, error) {
+	sp := &APISyncPush{Base: rrev}
+	size := 0
+	send := func(last uint64) error {
+		ap := &APIPage{}
+		err := c.do("POST", "/api/v1/sync/"+strings.TrimPrefix(pagePath(title), "/api/v1/pages/"), sp, ap)
+		if err != nil {
+			return err
+		}
+		st = &SyncState{Local: last, Remote: ap.Revision}
+		sp = &APISyncPush{Base: ap.Revision}
+		size = 0
+		return saveSyncState(remote, title, st)
+	}
+	for n := st.Local + 1; n <= local; n++ {
+		p, rev, err := loadRevision(ctx, title, n)
+		if err != nil {
+			return nil, err
+		}
+		ap := toAPIPage(p, rev)
+		bs, err := json.Marshal(ap)
+		if err != nil {
+			return nil, err
+		}
+		// the rest of the request is small, as maxFormOverhead.
+		if len(sp.Revisions) != 0 && size+len(bs) > maxSyncPush-maxFormOverhead {
+			if err := send(n - 1); err != nil {
+				return nil, err
+			}
+		}
+		sp.Revisions = append(sp.Revisions, ap)
+		size += len(bs)
+	}
+	if err := send(local); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
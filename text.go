@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// PlainText returns the page as plain text in reading order,
+// for text to speech and other accessibility tools.
+//
+// code blocks, tables and raw html are dropped as they are just noises
+// when read aloud. links and images are replaced with their texts.
+func (p *Page) PlainText() string {
+	buf := &bytes.Buffer{}
+	md := blackfriday.New(blackfriday.WithExtensions(markdownExtensions))
+	md.Parse(p.Body).Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch n.Type {
+		case blackfriday.CodeBlock, blackfriday.Table, blackfriday.HTMLBlock, blackfriday.HTMLSpan:
+			return blackfriday.SkipChildren
+		case blackfriday.Text, blackfriday.Code:
+			// line breaks in a paragraph are not meaningful for reading.
+			buf.Write(bytes.Replace(n.Literal, []byte("\n"), []byte(" "), -1))
+		case blackfriday.Softbreak:
+			buf.WriteByte(' ')
+		case blackfriday.Hardbreak:
+			buf.WriteByte('\n')
+		case blackfriday.Heading, blackfriday.Paragraph, blackfriday.Item, blackfriday.HorizontalRule:
+			if !entering || n.Type == blackfriday.HorizontalRule {
+				// paragraphs in a list item are not separated with blank lines.
+				if n.Type == blackfriday.Paragraph && n.Parent != nil && n.Parent.Type == blackfriday.Item {
+					break
+				}
+				buf.WriteString("\n\n")
+			}
+		}
+		return blackfriday.GoToNext
+	})
+	return strings.TrimSpace(collapseBlankLines(buf.String())) + "\n"
+}
+
+// wordsPerMinute is how fast people read, for the reading time of pages.
+const wordsPerMinute = 200
+
+// Reading is how long the page is to read.
+type Reading struct {
+	Words int
+	// Minutes is the estimated reading time, at least a minute for a page with words.
+	Minutes int
+}
+
+// Reading counts words of the page as it's read, without code blocks and tables like PlainText.
+// Chinese and Japanese are written without spaces, so each of their characters is counted as a word.
+func (p *Page) Reading() *Reading {
+	words := 0
+	for _, f := range strings.Fields(p.PlainText()) {
+		inWord := false
+		for _, r := range f {
+			switch {
+			case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+				words++
+				inWord = false
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				if !inWord {
+					words++
+				}
+				inWord = true
+			}
+		}
+	}
+	return &Reading{Words: words, Minutes: (words + wordsPerMinute - 1) / wordsPerMinute}
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+func collapseBlankLines(s string) string {
+	return blankLines.ReplaceAllString(s, "\n\n")
+}
+
+// loadRequestedPage loads the page, or it's revision with rev query.
+// It returns the number of the revision too.
+func loadRequestedPage(r *http.Request, title string) (*Page, uint64, error) {
+	var id uint64
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		var err error
+		id, err = strconv.ParseUint(rev, 10, 64)
+		if err != nil {
+			return nil, 0, newError(ErrInvalid, "invalid revision: %s", rev)
+		}
+	}
+	return loadRevision(r.Context(), title, id)
+}
+
+// textHandler serves the page as plain text.
+// with download query, browsers will save it as a .txt file.
+func textHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, rev, err := loadRequestedPage(r, title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if !checkRevision(w, r, p, rev) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Get("download") != "" {
+		fname := path.Base(title) + ".txt"
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fname}))
+	}
+	w.Write([]byte(p.Title + "\n\n" + p.PlainText()))
+}
+
+// rawHandler serves the stored markdown of the page as is,
+// for backups and other tools. (ex. curl /raw/Home | pandoc -f markdown)
+// with download query, browsers will save it as a .md file.
+func rawHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, rev, err := loadRequestedPage(r, title)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if !checkRevision(w, r, p, rev) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Get("download") != "" {
+		fname := path.Base(title) + ".md"
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fname}))
+	}
+	w.Write(p.Body)
+}
@@ -0,0 +1,97 @@
+// autosave saves the editor content as a draft periodically,
+// so it can be restored after a crash or an accidental navigation.
+(function() {
+    var form = document.getElementById("edit-form");
+    if (!form) {
+        return;
+    }
+    var url = form.getAttribute("data-draft");
+    if (!url) {
+        // sections are not saved as drafts.
+        return;
+    }
+    var last = new FormData(form).get("body");
+    var saving = false;
+    setInterval(function() {
+        var data = new FormData(form);
+        if (saving || data.get("body") == last) {
+            return;
+        }
+        saving = true;
+        var body = data.get("body");
+        fetch(url, {method: "POST", body: new URLSearchParams(data), credentials: "same-origin"}).then(function(resp) {
+            if (resp.ok) {
+                last = body;
+            }
+        }).finally(function() {
+            saving = false;
+        });
+    }, 10000);
+})();
+
+// preview renders the editor content next to it while typing,
+// and keeps the editor and the preview scrolled to the same part of the page.
+(function() {
+    var form = document.getElementById("edit-form");
+    var preview = document.getElementById("preview");
+    if (!form || !preview) {
+        return;
+    }
+    var url = preview.getAttribute("data-preview");
+    var text = form.elements["body"];
+    var last = text.value;
+    var timer = null;
+    // seq is the number of the last render, not to show an older one coming late.
+    var seq = 0;
+    function render() {
+        var body = text.value;
+        if (body == last) {
+            return;
+        }
+        var n = ++seq;
+        fetch(url, {method: "POST", body: new URLSearchParams({body: body, csrf: form.elements["csrf"].value}), credentials: "same-origin"}).then(function(resp) {
+            if (!resp.ok) {
+                throw new Error(resp.statusText);
+            }
+            return resp.text();
+        }).then(function(html) {
+            if (n != seq) {
+                return;
+            }
+            last = body;
+            preview.innerHTML = html;
+            scroll(text, preview);
+        }).catch(function() {
+            // the preview is a bit behind, until the next change.
+        });
+    }
+    text.addEventListener("input", function() {
+        clearTimeout(timer);
+        timer = setTimeout(render, 300);
+    });
+
+    // ignore is the pane scrolled by the other one,
+    // not to scroll the other one back by it's scroll event.
+    var ignore = null;
+    function scroll(from, to) {
+        var max = from.scrollHeight - from.clientHeight;
+        var ratio = max > 0 ? from.scrollTop / max : 0;
+        var top = Math.round(ratio * (to.scrollHeight - to.clientHeight));
+        if (Math.abs(to.scrollTop - top) < 1) {
+            return;
+        }
+        ignore = to;
+        to.scrollTop = top;
+    }
+    function follow(from, to) {
+        return function() {
+            if (ignore == from) {
+                ignore = null;
+                return;
+            }
+            scroll(from, to);
+        };
+    }
+    text.addEventListener("scroll", follow(text, preview));
+    preview.addEventListener("scroll", follow(preview, text));
+})();
@@ -0,0 +1,271 @@
+// editor turns the textarea of the edit page into a markdown editor,
+// with highlighting, a toolbar for formatting, and completion of brackets and lists.
+//
+// the textarea is still the editor, over the highlighted text behind it,
+// so the form, drafts and the preview work with it as before.
+// changes are made with execCommand when the browser supports it, to keep undo working.
+(function() {
+    var form = document.getElementById("edit-form");
+    if (!form) {
+        return;
+    }
+    var text = form.elements["body"];
+
+    var editor = document.createElement("div");
+    editor.className = "md-editor " + text.className;
+    text.className = "";
+    var toolbar = document.createElement("div");
+    toolbar.className = "md-toolbar";
+    var input = document.createElement("div");
+    input.className = "md-input";
+    var hl = document.createElement("pre");
+    hl.className = "md-highlight";
+    hl.setAttribute("aria-hidden", "true");
+    text.parentNode.insertBefore(editor, text);
+    editor.appendChild(toolbar);
+    editor.appendChild(input);
+    input.appendChild(hl);
+    input.appendChild(text);
+    text.spellcheck = false;
+
+    function escape(s) {
+        return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+    }
+    function span(cls, s) {
+        return '<span class="' + cls + '">' + escape(s) + "</span>";
+    }
+
+    // spans only change colors, so the text behind takes the same space as the textarea.
+    var inlineRe = /(`+)[^`]+?\1|(\*\*|__)(?=\S)[^\n]*?\S\2|(\*|_)(?=\S)[^\n]*?\S\3|!?\[[^\]\n]*\]\([^)\n]*\)|\[\[[^\]\n]+\]\]|<[^>\n]+>/g;
+    function inline(line) {
+        var out = "";
+        var last = 0;
+        line.replace(inlineRe, function(m, code, strong, em, i) {
+            out += escape(line.slice(last, i));
+            var cls = "md-link";
+            if (code) {
+                cls = "md-code";
+            } else if (strong) {
+                cls = "md-strong";
+            } else if (em) {
+                cls = "md-em";
+            } else if (m[0] == "<") {
+                cls = "md-html";
+            }
+            out += span(cls, m);
+            last = i + m.length;
+            return m;
+        });
+        return out + escape(line.slice(last));
+    }
+
+    function highlight(src) {
+        var out = [];
+        // fence is the fence of the code block the line is in.
+        var fence = "";
+        src.split("\n").forEach(function(line) {
+            var m;
+            if (fence) {
+                out.push(span("md-code", line));
+                if (line.trim().indexOf(fence) == 0) {
+                    fence = "";
+                }
+            } else if ((m = /^\s*(```|~~~)/.exec(line))) {
+                fence = m[1];
+                out.push(span("md-code", line));
+            } else if (/^ {0,3}#{1,6}(\s|$)/.test(line)) {
+                out.push(span("md-heading", line));
+            } else if (/^ {0,3}([-*_])(\s*\1){2,}\s*$/.test(line)) {
+                out.push(span("md-marker", line));
+            } else if (/^( {4}|\t)/.test(line) && !/^\s*([-*+]|\d+[.)])\s/.test(line)) {
+                out.push(span("md-code", line));
+            } else if ((m = /^(\s*(?:>\s?)+)/.exec(line))) {
+                out.push(span("md-quote", m[1]) + '<span class="md-quote">' + inline(line.slice(m[1].length)) + "</span>");
+            } else if ((m = /^(\s*(?:[-*+]|\d+[.)])\s+(?:\[[ xX]\]\s+)?)/.exec(line))) {
+                out.push(span("md-marker", m[1]) + inline(line.slice(m[1].length)));
+            } else {
+                out.push(inline(line));
+            }
+        });
+        // the last empty line takes it's height too.
+        return out.join("\n") + "\n";
+    }
+
+    function update() {
+        hl.innerHTML = highlight(text.value);
+        hl.scrollTop = text.scrollTop;
+    }
+    text.addEventListener("input", update);
+    text.addEventListener("scroll", function() {
+        hl.scrollTop = text.scrollTop;
+    });
+    update();
+
+    // replace replaces text from start to end with s, and selects from selStart to selEnd.
+    function replace(start, end, s, selStart, selEnd) {
+        text.focus();
+        text.setSelectionRange(start, end);
+        if (!document.execCommand(s ? "insertText" : "delete", false, s)) {
+            text.setRangeText(s, start, end, "end");
+            text.dispatchEvent(new Event("input", {bubbles: true}));
+        }
+        text.setSelectionRange(selStart, selEnd === undefined ? selStart : selEnd);
+    }
+
+    // wrap wraps the selection with the marks, or unwraps it when it's wrapped already.
+    function wrap(open, close, placeholder) {
+        var v = text.value, start = text.selectionStart, end = text.selectionEnd;
+        if (v.slice(start - open.length, start) == open && v.slice(end, end + close.length) == close) {
+            replace(start - open.length, end + close.length, v.slice(start, end), start - open.length, end - open.length);
+            return;
+        }
+        var sel = v.slice(start, end) || placeholder;
+        replace(start, end, open + sel + close, start + open.length, start + open.length + sel.length);
+    }
+
+    // selectedLines returns where the lines of the selection start and end.
+    function selectedLines() {
+        var v = text.value;
+        var start = v.lastIndexOf("\n", text.selectionStart - 1) + 1;
+        var end = v.indexOf("\n", text.selectionEnd);
+        if (end < 0) {
+            end = v.length;
+        }
+        return {start: start, end: end, lines: v.slice(start, end).split("\n")};
+    }
+
+    // prefix adds the prefix made by fn to the selected lines,
+    // or removes prefixes matched by re when all of them have one.
+    function prefix(re, fn) {
+        var sel = selectedLines();
+        var all = sel.lines.every(function(l) { return re.test(l); });
+        var lines = sel.lines.map(function(l, i) {
+            return all ? l.replace(re, "") : fn(i) + l;
+        });
+        var s = lines.join("\n");
+        replace(sel.start, sel.end, s, sel.start, sel.start + s.length);
+    }
+
+    function heading() {
+        var sel = selectedLines();
+        var line = sel.lines[0];
+        var m = /^(#{1,6})(\s+|$)/.exec(line);
+        // no heading -> # -> ## -> ### -> no heading
+        var level = m ? m[1].length + 1 : 1;
+        var rest = m ? line.slice(m[0].length) : line;
+        var s = (level > 3 ? "" : "#".repeat(level) + " ") + rest;
+        var end = sel.start + line.length;
+        replace(sel.start, end, s, sel.start + s.length);
+    }
+
+    function link() {
+        var v = text.value, start = text.selectionStart, end = text.selectionEnd;
+        var label = v.slice(start, end) || "text";
+        var s = "[" + label + "](url)";
+        // the url is selected to type it.
+        var at = start + label.length + 3;
+        replace(start, end, s, at, at + 3);
+    }
+
+    var actions = [
+        {label: "B", title: "bold (ctrl+b)", key: "b", run: function() { wrap("**", "**", "bold"); }},
+        {label: "I", title: "italic (ctrl+i)", key: "i", run: function() { wrap("*", "*", "italic"); }},
+        {label: "H", title: "heading", run: heading},
+        {label: "link", title: "link (ctrl+k)", key: "k", run: link},
+        {label: "code", title: "code", run: function() { wrap("`", "`", "code"); }},
+        {label: "quote", title: "quote", run: function() { prefix(/^>\s?/, function() { return "> "; }); }},
+        {label: "- list", title: "bulleted list", run: function() { prefix(/^\s*[-*+]\s+/, function() { return "- "; }); }},
+        {label: "1. list", title: "numbered list", run: function() { prefix(/^\s*\d+[.)]\s+/, function(i) { return (i + 1) + ". "; }); }}
+    ];
+    var shortcuts = {};
+    actions.forEach(function(a) {
+        var b = document.createElement("button");
+        b.type = "button";
+        b.textContent = a.label;
+        b.title = a.title;
+        b.addEventListener("click", a.run);
+        toolbar.appendChild(b);
+        if (a.key) {
+            shortcuts[a.key] = a.run;
+        }
+    });
+
+    var pairs = {"(": ")", "[": "]", "{": "}", "`": "`"};
+    var closers = {")": true, "]": true, "}": true, "`": true};
+
+    // continueList starts the next item of the list or quote the caret is in,
+    // or ends it on an empty item. It returns false when the caret is not in one.
+    function continueList() {
+        var v = text.value, at = text.selectionStart;
+        var start = v.lastIndexOf("\n", at - 1) + 1;
+        var line = v.slice(start, at);
+        var m = /^(\s*)(?:([-*+])|(\d+)([.)]))(\s+)(\[[ xX]\]\s+)?/.exec(line) || /^(\s*(?:>\s?)+)/.exec(line);
+        if (!m) {
+            return false;
+        }
+        if (line.length == m[0].length) {
+            // an empty item ends the list.
+            replace(start, at, "", start);
+            return true;
+        }
+        var next = m[0];
+        if (m[5] !== undefined) {
+            // the next number, and an unchecked task.
+            next = m[1] + (m[3] ? (parseInt(m[3], 10) + 1) + m[4] : m[2]) + m[5] + (m[6] ? "[ ] " : "");
+        }
+        var s = "\n" + next;
+        replace(at, text.selectionEnd, s, at + s.length);
+        return true;
+    }
+
+    // indent indents (or outdents) the selected lines of a list.
+    function indent(out) {
+        var sel = selectedLines();
+        if (!sel.lines.every(function(l) { return /^\s*([-*+]|\d+[.)])\s/.test(l); })) {
+            return false;
+        }
+        var lines = sel.lines.map(function(l) {
+            return out ? l.replace(/^( {1,4}|\t)/, "") : "    " + l;
+        });
+        var s = lines.join("\n");
+        replace(sel.start, sel.end, s, sel.start, sel.start + s.length);
+        return true;
+    }
+
+    text.addEventListener("keydown", function(e) {
+        if (e.isComposing) {
+            return;
+        }
+        var v = text.value, start = text.selectionStart, end = text.selectionEnd;
+        var handled = false;
+        if ((e.ctrlKey || e.metaKey) && !e.altKey && shortcuts[e.key]) {
+            shortcuts[e.key]();
+            handled = true;
+        } else if (e.ctrlKey || e.metaKey || e.altKey) {
+            return;
+        } else if (e.key == "Enter" && !e.shiftKey && start == end) {
+            handled = continueList();
+        } else if (e.key == "Tab") {
+            // tab moves the focus out of the editor, except in a list.
+            handled = indent(e.shiftKey);
+        } else if (e.key == "Backspace" && start == end && pairs[v[start - 1]] && pairs[v[start - 1]] == v[start]) {
+            replace(start - 1, start + 1, "", start - 1);
+            handled = true;
+        } else if (closers[e.key] && start == end && v[start] == e.key) {
+            text.setSelectionRange(start + 1, start + 1);
+            handled = true;
+        } else if (pairs[e.key]) {
+            if (start != end) {
+                replace(start, end, e.key + v.slice(start, end) + pairs[e.key], start + 1, end + 1);
+                handled = true;
+            } else if (start == v.length || /[\s)\]}]/.test(v[start])) {
+                // brackets are completed only before a space or a closing one, not in a word.
+                replace(start, end, e.key + pairs[e.key], start + 1);
+                handled = true;
+            }
+        }
+        if (handled) {
+            e.preventDefault();
+        }
+    });
+})();
@@ -0,0 +1,76 @@
+// shortcuts are keyboard shortcuts of every page.
+// links in the header have their keys in data-key, and the key follows the link.
+// "/" focuses the search box of the search page, or the one in the header.
+// keys are not taken while typing in a form, except ctrl+enter.
+(function() {
+    var help = document.getElementById("shortcuts");
+    if (!help) {
+        return;
+    }
+    function showHelp(show) {
+        help.hidden = !show;
+    }
+    document.getElementById("shortcuts-button").addEventListener("click", function(e) {
+        e.preventDefault();
+        showHelp(help.hidden);
+    });
+    help.addEventListener("click", function(e) {
+        // a click out of the box closes it.
+        if (e.target == help) {
+            showHelp(false);
+        }
+    });
+
+    function typing(el) {
+        return el && (el.isContentEditable || /^(INPUT|TEXTAREA|SELECT)$/.test(el.tagName));
+    }
+
+    document.addEventListener("keydown", function(e) {
+        if (e.isComposing || e.defaultPrevented) {
+            return;
+        }
+        if (e.key == "Enter" && (e.ctrlKey || e.metaKey)) {
+            // the form being typed in, or the editor.
+            var form = (document.activeElement && document.activeElement.form) || document.getElementById("edit-form");
+            if (form) {
+                e.preventDefault();
+                if (form.requestSubmit) {
+                    form.requestSubmit();
+                } else {
+                    form.submit();
+                }
+            }
+            return;
+        }
+        if (e.key == "Escape" && !help.hidden) {
+            showHelp(false);
+            return;
+        }
+        if (e.ctrlKey || e.metaKey || e.altKey || typing(e.target)) {
+            return;
+        }
+        if (e.key == "?") {
+            e.preventDefault();
+            showHelp(help.hidden);
+            return;
+        }
+        if (e.key == "/") {
+            var input = document.getElementById("search-input") || document.getElementById("header-search-input");
+            if (input) {
+                e.preventDefault();
+                input.focus();
+                input.select();
+                return;
+            }
+        }
+        if (!/^[a-z\/]$/.test(e.key)) {
+            return;
+        }
+        var link = document.querySelector('#header a[data-key="' + e.key + '"]');
+        if (link) {
+            e.preventDefault();
+            showHelp(false);
+            link.click();
+        }
+    });
+})();
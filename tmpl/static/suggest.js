@@ -0,0 +1,123 @@
+// suggest shows titles starting with what is typed in the search box of the header.
+// a title opens the page, and "create" opens the editor of a new page when
+// there is no page of it. enter without a selection searches as before.
+(function() {
+    var form = document.getElementById("header-search");
+    if (!form) {
+        return;
+    }
+    var input = form.elements["q"];
+    var list = form.querySelector(".suggestions");
+    var url = form.getAttribute("data-suggest");
+    // seq drops responses of older queries, which come late.
+    var seq = 0;
+    var timer = null;
+    var selected = -1;
+
+    function pageURL(base, title) {
+        return base + encodeURIComponent(title).replace(/%2F/g, "/");
+    }
+
+    function item(href, text, cls) {
+        var li = document.createElement("li");
+        if (cls) {
+            li.className = cls;
+        }
+        var a = document.createElement("a");
+        a.href = href;
+        a.textContent = text;
+        li.appendChild(a);
+        return li;
+    }
+
+    function close() {
+        list.hidden = true;
+        list.innerHTML = "";
+        selected = -1;
+    }
+
+    function show(s) {
+        list.innerHTML = "";
+        selected = -1;
+        s.titles.forEach(function(t) {
+            list.appendChild(item(pageURL(form.getAttribute("data-view"), t), t));
+        });
+        if (!s.exists) {
+            list.appendChild(item(pageURL(form.getAttribute("data-edit"), s.query), "create page '" + s.query + "'", "create"));
+        }
+        list.hidden = list.children.length == 0;
+    }
+
+    function suggest() {
+        var q = input.value.trim();
+        var n = ++seq;
+        if (!q) {
+            close();
+            return;
+        }
+        fetch(url + "?q=" + encodeURIComponent(q), {credentials: "same-origin"}).then(function(resp) {
+            if (!resp.ok) {
+                throw new Error(resp.status);
+            }
+            return resp.json();
+        }).then(function(s) {
+            if (n == seq) {
+                show(s);
+            }
+        }).catch(function() {
+            if (n == seq) {
+                close();
+            }
+        });
+    }
+
+    function select(i) {
+        var items = list.children;
+        if (items.length == 0) {
+            return;
+        }
+        if (selected >= 0) {
+            items[selected].classList.remove("selected");
+        }
+        // it goes around, through the input itself at -1.
+        var n = items.length + 1;
+        selected = ((i + 1) % n + n) % n - 1;
+        if (selected >= 0) {
+            items[selected].classList.add("selected");
+        }
+    }
+
+    input.addEventListener("input", function() {
+        clearTimeout(timer);
+        timer = setTimeout(suggest, 150);
+    });
+    input.addEventListener("keydown", function(e) {
+        if (e.isComposing) {
+            return;
+        }
+        if (e.key == "ArrowDown") {
+            e.preventDefault();
+            select(selected + 1);
+        } else if (e.key == "ArrowUp") {
+            e.preventDefault();
+            select(selected - 1);
+        } else if (e.key == "Enter" && selected >= 0) {
+            e.preventDefault();
+            location.href = list.children[selected].firstChild.href;
+        } else if (e.key == "Escape" && !list.hidden) {
+            // not to close the shortcuts help too.
+            e.preventDefault();
+            close();
+        }
+    });
+    input.addEventListener("focus", function() {
+        if (input.value.trim() && list.hidden) {
+            suggest();
+        }
+    });
+    document.addEventListener("click", function(e) {
+        if (!form.contains(e.target)) {
+            close();
+        }
+    });
+})();
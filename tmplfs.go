@@ -0,0 +1,122 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// templates and static assets are built into the binary, so a new wiki needs
+// nothing but the database. files in -tmpl-dir (tmpl in the data directory,
+// by default) override the built-in ones of the same name, so a wiki can change
+// the look by having only the files it changed.
+
+//go:embed tmpl
+var builtinTmpl embed.FS
+
+// tmplDir is the directory of templates and assets those override the built-in ones.
+var tmplDir = "tmpl"
+
+// tmplFS returns templates and assets, as tmpl/... like in the repository.
+func tmplFS() fs.FS {
+	return &overlayFS{dir: tmplDir, base: builtinTmpl}
+}
+
+// overlayFS serves files under tmpl from dir, and from base when dir doesn't have them.
+type overlayFS struct {
+	dir  string
+	base fs.FS
+}
+
+// diskPath returns path of the file name in dir.
+// it's false when the name is not under tmpl.
+func (o *overlayFS) diskPath(name string) (string, bool) {
+	if name == "tmpl" {
+		return o.dir, true
+	}
+	rel, ok := strings.CutPrefix(name, "tmpl/")
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(o.dir, filepath.FromSlash(rel)), true
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if p, ok := o.diskPath(name); ok {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return os.Open(p)
+		}
+	}
+	return o.base.Open(name)
+}
+
+// ReadDir lists files of both, to find the templates only in dir too.
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	base, err := fs.ReadDir(o.base, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range base {
+		entries[e.Name()] = e
+	}
+	found := err == nil
+	if p, ok := o.diskPath(name); ok {
+		disk, err := os.ReadDir(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range disk {
+			entries[e.Name()] = e
+		}
+		found = found || err == nil
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// extractTemplatesCommand writes the built-in templates and assets to a directory,
+// as a start of customizing them. it doesn't overwrite files already there.
+func extractTemplatesCommand(flags *flag.FlagSet, args []string) error {
+	args = parseArgs(flags, args, "<dir>", 1)
+	dir := args[0]
+	return fs.WalkDir(builtinTmpl, "tmpl", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, "tmpl")))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if _, err := os.Stat(dst); err == nil {
+			fmt.Printf("%s exists, skipped\n", dst)
+			return nil
+		}
+		data, err := builtinTmpl.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(dst, data, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Println(dst)
+		return nil
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Token is an api token of a user, which is for scripts and external tools.
+// The token itself is not saved, only it's hash is saved as the key.
+type Token struct {
+	// ID is the first few letters of the token, to tell tokens apart.
+	ID      string
+	User    string
+	Name    string
+	Created time.Time
+}
+
+func tokenKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// createToken creates a new token for the user.
+// It returns the token which should be handed to the user as it cannot be seen again.
+func createToken(user, name string) (string, error) {
+	key := make([]byte, 24)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(key)
+	t := &Token{ID: token[:8], User: user, Name: name, Created: time.Now()}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("tokens")), tokenKey(token), t)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func loadTokens(user string) ([]*Token, error) {
+	tokens := []*Token{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("tokens")).ForEach(func(k, v []byte) error {
+			t := &Token{}
+			if err := fromBytes(v, t); err != nil {
+				return err
+			}
+			if t.User == user {
+				tokens = append(tokens, t)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func removeToken(user, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tokens"))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			t := &Token{}
+			if err := fromBytes(v, t); err != nil {
+				return err
+			}
+			if t.User == user && t.ID == id {
+				return b.Delete(k)
+			}
+		}
+		return newError(ErrNotFound, "token not exists")
+	})
+}
+
+// tokenUser returns the user of the bearer token in the request.
+// ok is false when the request does not have a token.
+func tokenUser(r *http.Request) (u *User, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, false
+	}
+	u, err := loadTokenUser(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		return nil, true
+	}
+	return u, true
+}
+
+// loadTokenUser returns the owner of the token.
+func loadTokenUser(token string) (*User, error) {
+	t := &Token{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("tokens")).Get(tokenKey(token))
+		if bs == nil {
+			return newError(ErrNotFound, "token not exists")
+		}
+		return fromBytes(bs, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	u, err := loadUser(t.User)
+	if err != nil {
+		return nil, err
+	}
+	if u.Banned {
+		return nil, errUserBanned
+	}
+	return u, nil
+}
+
+type TokensPage struct {
+	Title  string
+	Tokens []*Token
+	// NewToken is shown just after it is created.
+	NewToken string
+}
+
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		httpError(w, r, newError(ErrForbidden, "please log in to manage api tokens"))
+		return
+	}
+	newToken := ""
+	if r.Method == "POST" {
+		var err error
+		if id := r.FormValue("remove"); id != "" {
+			err = removeToken(u.Name, id)
+		} else {
+			newToken, err = createToken(u.Name, strings.TrimSpace(r.FormValue("name")))
+		}
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if newToken == "" {
+			http.Redirect(w, r, "/tokens", http.StatusFound)
+			return
+		}
+	}
+	tokens, err := loadTokens(u.Name)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "tokens", &TokensPage{Tokens: tokens, NewToken: newToken})
+}
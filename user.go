@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	Name string
+	// Password is bcrypt hash of the user's password.
+	Password []byte
+	Groups   []string
+	Admin    bool
+	// Banned users can't log in, and their sessions and api tokens don't work.
+	Banned  bool
+	Created time.Time
+}
+
+func (u *User) InGroup(groups ...string) bool {
+	for _, g := range groups {
+		for _, ug := range u.Groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Session struct {
+	User    string
+	Expires time.Time
+}
+
+const sessionCookie = "whisky_session"
+
+const sessionDuration = 30 * 24 * time.Hour
+
+var validUserName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,32}$`)
+
+var errUserNotExists = newError(ErrNotFound, "user not exists")
+
+var errUserBanned = newError(ErrForbidden, "the user is banned")
+
+func loadUser(name string) (*User, error) {
+	u := &User{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("users")).Get([]byte(name))
+		if bs == nil {
+			return errUserNotExists
+		}
+		return fromBytes(bs, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func saveUser(u *User) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("users")), []byte(u.Name), u)
+	})
+}
+
+func loadUsers() ([]*User, error) {
+	users := []*User{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).ForEach(func(k, v []byte) error {
+			u := &User{}
+			if err := fromBytes(v, u); err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// createUser creates a new user with the password.
+// The first user of the wiki will be an admin.
+func createUser(name, password string) (*User, error) {
+	if !validUserName.MatchString(name) {
+		return nil, newError(ErrInvalid, "user name should be 1-32 letters of alphabets, digits, '_', '.' or '-'")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{Name: name, Password: hash, Created: time.Now()}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b.Get([]byte(name)) != nil {
+			return newError(ErrConflict, "user already exists")
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			u.Admin = true
+		}
+		return putRecord(b, []byte(name), u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// hashPassword checks the password is long enough, and returns the hash of it.
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < 8 {
+		return nil, newError(ErrInvalid, "password should be at least 8 characters")
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func checkPassword(name, password string) (*User, error) {
+	u, err := loadUser(name)
+	if err != nil {
+		return nil, newError(ErrForbidden, "invalid user name or password")
+	}
+	err = bcrypt.CompareHashAndPassword(u.Password, []byte(password))
+	if err != nil {
+		return nil, newError(ErrForbidden, "invalid user name or password")
+	}
+	if u.Banned {
+		return nil, errUserBanned
+	}
+	return u, nil
+}
+
+func newSession(w http.ResponseWriter, r *http.Request, user string) error {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(key)
+	s := &Session{User: user, Expires: time.Now().Add(sessionDuration)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket([]byte("sessions")), []byte(token), s)
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     basePath + "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		// other sites can't post forms with it. (see checkCSRF too)
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// removeUserSessions removes sessions of the user, to log the user out everywhere.
+func removeUserSessions(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		var keys [][]byte
+		err := b.ForEach(func(k, bs []byte) error {
+			s := &Session{}
+			if err := fromBytes(bs, s); err != nil {
+				return nil
+			}
+			if s.User == name {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func removeSession(w http.ResponseWriter, r *http.Request) error {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: basePath + "/", MaxAge: -1})
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("sessions")).Delete([]byte(c.Value))
+	})
+}
+
+// currentUser returns the logged in user of the request.
+// It returns nil when the user is not logged in.
+//
+// A request with an api token is treated as it is from the owner of the token.
+func currentUser(r *http.Request) *User {
+	if u, ok := tokenUser(r); ok {
+		if u != nil {
+			noteAccessUser(r, u.Name)
+		}
+		return u
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	s := &Session{}
+	err = db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("sessions")).Get([]byte(c.Value))
+		if bs == nil {
+			return errors.New("session not exists")
+		}
+		return fromBytes(bs, s)
+	})
+	if err != nil || time.Now().After(s.Expires) {
+		return nil
+	}
+	u, err := loadUser(s.User)
+	if err != nil || u.Banned {
+		return nil
+	}
+	noteAccessUser(r, u.Name)
+	return u
+}
+
+// authorName returns the name that will be recorded as author of changes.
+func authorName(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.Name
+	}
+	return clientAddr(r)
+}
+
+func isAdmin(r *http.Request) bool {
+	u := currentUser(r)
+	return u != nil && u.Admin
+}
+
+// csrfToken returns the token the forms of the signed in user carry, or "" without a session.
+// it's a hash of the session cookie, so other sites can't know it, and it's
+// changed whenever the user signs in again.
+func csrfToken(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte("whisky-csrf\x00" + c.Value))
+	return hex.EncodeToString(h[:16])
+}
+
+// checkCSRF checks the request is made by the wiki, not by another site through the user's browser.
+// forms have the token in the csrf field, and scripts can send it in X-CSRF-Token header.
+// requests with an api token are not from browsers, and anonymous users have nothing to be stolen.
+func checkCSRF(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := tokenUser(r); ok {
+		return nil
+	}
+	want := csrfToken(r)
+	if want == "" {
+		return nil
+	}
+	got := r.Header.Get("X-CSRF-Token")
+	if got == "" {
+		// the form is read before the handler, so it's limited like the handler would.
+		// uploads are limited by withBodyLimit.
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" {
+			if err := parsePageForm(w, r); err != nil {
+				return err
+			}
+		}
+		got = r.PostFormValue("csrf")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return newError(ErrForbidden, "the form is expired or from another site. please reload the page and try again")
+	}
+	return nil
+}
+
+// withCSRF checks every request but reads with checkCSRF.
+func withCSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if err := checkCSRF(w, r); err != nil {
+				httpError(w, r, err)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly wraps a handler which only admins can access.
+func adminOnly(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			httpError(w, r, newError(ErrForbidden, "only admins can access this page"))
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func parseGroups(s string) []string {
+	groups := []string{}
+	for _, g := range strings.Split(s, ",") {
+		g = strings.TrimSpace(g)
+		if g != "" {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+type LogInPage struct {
+	Title string
+	Error string
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method == "POST" {
+		u, err := checkPassword(r.FormValue("username"), r.FormValue("password"))
+		if err != nil {
+			renderTemplate(w, r, "login", &LogInPage{Title: title, Error: err.Error()})
+			return
+		}
+		err = newSession(w, r, u.Name)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "login", &LogInPage{Title: title})
+}
+
+func signupHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method == "POST" {
+		if r.FormValue("password") != r.FormValue("password2") {
+			renderTemplate(w, r, "signup", &LogInPage{Title: title, Error: "passwords are not matched"})
+			return
+		}
+		u, err := createUser(r.FormValue("username"), r.FormValue("password"))
+		if err != nil {
+			renderTemplate(w, r, "signup", &LogInPage{Title: title, Error: err.Error()})
+			return
+		}
+		err = newSession(w, r, u.Name)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "signup", &LogInPage{Title: title})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request, title string) {
+	err := removeSession(w, r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, r.URL.Path, http.StatusFound)
+}
+
+type UsersPage struct {
+	Title string
+	Users []*User
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		u, err := loadUser(r.FormValue("name"))
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		u.Groups = parseGroups(r.FormValue("groups"))
+		u.Admin = r.FormValue("admin") != ""
+		u.Banned = r.FormValue("banned") != ""
+		err = saveUser(u)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if u.Banned {
+			err = removeUserSessions(u.Name)
+			if err != nil {
+				httpError(w, r, err)
+				return
+			}
+		}
+		http.Redirect(w, r, "/users", http.StatusFound)
+		return
+	}
+	users, err := loadUsers()
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "users", &UsersPage{Users: users})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	srv := newTestWiki(t)
+	alice := signup(t, srv, "alice")
+
+	// forms of all kinds are checked, not only of admin pages.
+	for _, f := range []struct {
+		path string
+		form url.Values
+	}{
+		{"/save/Home", url.Values{"body": {"hello"}}},
+		{"/watch/Home", nil},
+		{"/tokens", url.Values{"name": {"cli"}}},
+		{"/preferences", url.Values{"timezone": {"UTC"}}},
+		{"/notifications", nil},
+		{"/review?id=1&approve=1", nil},
+		{"/settings", url.Values{"license": {"CC0"}}},
+		{"/talk/Home", url.Values{"body": {"hi"}}},
+	} {
+		if resp := alice.post(f.path, f.form); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("POST %s without the token: %s, want 403", f.path, resp.Status)
+		}
+		f.form = url.Values{"csrf": {"0123456789abcdef0123456789abcdef"}}
+		if resp := alice.post(f.path, f.form); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("POST %s with a wrong token: %s, want 403", f.path, resp.Status)
+		}
+	}
+	if _, body := alice.get("/tokens"); strings.Contains(body, "cli") {
+		t.Error("a token is created without the csrf token")
+	}
+
+	if resp := alice.save("Home", "hello"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not save with the token: %s", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "hello" {
+		t.Errorf("Home is %q, want %q", body, "hello")
+	}
+
+	// scripts send it in the header.
+	_, page := alice.get("/edit/Home")
+	token := csrfInput.FindStringSubmatch(page)[1]
+	req, _ := http.NewRequest("POST", srv.URL+"/preview/Home", strings.NewReader(url.Values{"body": {"*hi*"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-Token", token)
+	resp, err := alice.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("preview with the token in the header: %s", resp.Status)
+	}
+
+	// uploads are multipart forms.
+	upload := func(csrf string) int {
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		mw.WriteField("csrf", csrf)
+		fw, _ := mw.CreateFormFile("file", "a.txt")
+		fw.Write([]byte("attached"))
+		mw.Close()
+		resp, err := alice.c.Post(srv.URL+"/attach/Home", mw.FormDataContentType(), buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := upload(""); code != http.StatusForbidden {
+		t.Errorf("upload without the token: %d, want 403", code)
+	}
+	if code := upload(token); code != http.StatusFound {
+		t.Errorf("upload with the token: %d, want 302", code)
+	}
+
+	// api tokens are not sent by browsers by themselves.
+	resp = alice.submit("/tokens", url.Values{"name": {"cli"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("could not create a token: %s", resp.Status)
+	}
+	_, page = alice.get("/tokens")
+	if !strings.Contains(page, "cli") {
+		t.Fatal("the token is not created")
+	}
+}
+
+func TestCSRFAPIToken(t *testing.T) {
+	srv := newTestWiki(t)
+	alice := signup(t, srv, "alice")
+	token, err := createToken("alice", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := json.Marshal(&APIEdit{Body: "from the api"})
+	req, _ := http.NewRequest("PUT", srv.URL+"/api/v1/pages/Home", strings.NewReader(string(bs)))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("PUT with an api token: %s", resp.Status)
+	}
+
+	// but the session of the browser is.
+	req, _ = http.NewRequest("DELETE", srv.URL+"/api/v1/pages/Home", nil)
+	resp, err = alice.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE with the session: %s, want 403", resp.Status)
+	}
+	if body := pageBody(t, srv, "Home"); body != "from the api" {
+		t.Errorf("Home is %q, want %q", body, "from the api")
+	}
+}
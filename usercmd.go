@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// user commands manage users in whisky.db directly, while the wiki is stopped,
+// like making the first admin of a wiki before anyone signs up.
+//
+//	whisky user list
+//	whisky user add [-admin] [-groups a,b] <name>
+//	whisky user passwd <name>
+//	whisky user promote [-demote] <name>
+//	whisky user ban [-undo] <name>
+//
+// passwords are read from stdin, so they can be piped too.
+
+var userCommands = map[string]func(fs *flag.FlagSet, args []string) error{
+	"list":    userListCommand,
+	"add":     userAddCommand,
+	"passwd":  userPasswdCommand,
+	"promote": userPromoteCommand,
+	"ban":     userBanCommand,
+}
+
+func userCommand(fs *flag.FlagSet, args []string) (err error) {
+	if len(args) == 0 || userCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "usage: whisky user <list|add|passwd|promote|ban> [flags] [name]")
+		os.Exit(2)
+	}
+	cmd := userCommands[args[0]]
+	err = openLocalDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeLocalDB(); err == nil {
+			err = cerr
+		}
+	}()
+	return cmd(flag.NewFlagSet(fs.Name()+" "+args[0], flag.ExitOnError), args[1:])
+}
+
+// readPassword asks the password, and reads it from stdin.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("could not read the password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func userListCommand(fs *flag.FlagSet, args []string) error {
+	parseArgs(fs, args, "", -1)
+	users, err := loadUsers()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, u := range users {
+		var marks []string
+		if u.Admin {
+			marks = append(marks, "admin")
+		}
+		if u.Banned {
+			marks = append(marks, "banned")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Name, strings.Join(marks, ","), strings.Join(u.Groups, ","), u.Created.Local().Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func userAddCommand(fs *flag.FlagSet, args []string) error {
+	admin := fs.Bool("admin", false, "make the user an admin. the first user is an admin anyway")
+	groups := fs.String("groups", "", "comma separated groups of the user")
+	args = parseArgs(fs, args, "[flags] <name>", 1)
+	password, err := readPassword("password: ")
+	if err != nil {
+		return err
+	}
+	u, err := createUser(args[0], password)
+	if err != nil {
+		return err
+	}
+	u.Admin = u.Admin || *admin
+	u.Groups = parseGroups(*groups)
+	err = saveUser(u)
+	if err != nil {
+		return err
+	}
+	if u.Admin {
+		fmt.Printf("added %s as an admin\n", u.Name)
+	} else {
+		fmt.Printf("added %s\n", u.Name)
+	}
+	return nil
+}
+
+func userPasswdCommand(fs *flag.FlagSet, args []string) error {
+	args = parseArgs(fs, args, "<name>", 1)
+	u, err := loadUser(args[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword("new password: ")
+	if err != nil {
+		return err
+	}
+	u.Password, err = hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = saveUser(u)
+	if err != nil {
+		return err
+	}
+	// the one who knew the old password shouldn't stay logged in.
+	return removeUserSessions(u.Name)
+}
+
+func userPromoteCommand(fs *flag.FlagSet, args []string) error {
+	demote := fs.Bool("demote", false, "make the admin a normal user")
+	args = parseArgs(fs, args, "[flags] <name>", 1)
+	u, err := loadUser(args[0])
+	if err != nil {
+		return err
+	}
+	u.Admin = !*demote
+	return saveUser(u)
+}
+
+func userBanCommand(fs *flag.FlagSet, args []string) error {
+	undo := fs.Bool("undo", false, "let the banned user log in again")
+	args = parseArgs(fs, args, "[flags] <name>", 1)
+	u, err := loadUser(args[0])
+	if err != nil {
+		return err
+	}
+	u.Banned = !*undo
+	err = saveUser(u)
+	if err != nil {
+		return err
+	}
+	if u.Banned {
+		return removeUserSessions(u.Name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version, commit and buildDate of the binary are set by the build, like
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// those not set are taken from the build info go embeds, when it has.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+	// dirty is true when the binary was built with changes not committed.
+	dirty bool
+)
+
+func init() {
+	defer func() {
+		if version == "" {
+			version = "dev"
+		}
+	}()
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		version = bi.Main.Version
+	}
+	vcs := make(map[string]string)
+	for _, s := range bi.Settings {
+		vcs[s.Key] = s.Value
+	}
+	if commit == "" {
+		commit = vcs["vcs.revision"]
+		dirty = vcs["vcs.modified"] == "true"
+	}
+	if buildDate == "" {
+		// it's time of the commit, but better than nothing.
+		buildDate = vcs["vcs.time"]
+	}
+}
+
+// versionString returns the version for -version, like
+// whisky 1.2.0 (commit 1a2b3c4d5e6f, built 2024-05-01T10:00:00Z, go1.22.2 linux/amd64)
+func versionString() string {
+	info := []string{}
+	if commit != "" {
+		c := commit
+		if len(c) > 12 {
+			c = c[:12]
+		}
+		if dirty {
+			c += "-dirty"
+		}
+		info = append(info, "commit "+c)
+	}
+	if buildDate != "" {
+		info = append(info, "built "+buildDate)
+	}
+	info = append(info, runtime.Version()+" "+runtime.GOOS+"/"+runtime.GOARCH)
+	return fmt.Sprintf("whisky %s (%s)", version, strings.Join(info, ", "))
+}
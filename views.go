@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// views of pages are counted in memory, and written to "views" bucket
+// every viewFlushInterval, not to write the database on every view.
+// counts not written yet are lost when the wiki stops.
+
+// PageViews is view counts of a page.
+type PageViews struct {
+	Total uint64
+	// Days has counts of recent days by the date (2006-01-02), for popular pages.
+	Days map[string]uint64
+}
+
+const (
+	viewFlushInterval = 10 * time.Second
+	// viewDays is how long counts of a day are kept.
+	viewDays = 30
+	// popularDays is the period of popular pages in the stats.
+	popularDays = 7
+)
+
+var (
+	viewsMu sync.Mutex
+	// unflushedViews are counts of pages not written yet.
+	unflushedViews = make(map[string]uint64)
+)
+
+// countView counts a view of the page.
+func countView(title string) {
+	viewsMu.Lock()
+	unflushedViews[title]++
+	viewsMu.Unlock()
+}
+
+// flushViews writes the counts to the database every interval.
+func flushViews(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		viewsMu.Lock()
+		counts := unflushedViews
+		unflushedViews = make(map[string]uint64)
+		viewsMu.Unlock()
+		if len(counts) == 0 {
+			continue
+		}
+		start := time.Now()
+		err := usingDB(func() error { return addViews(counts, start) })
+		observeJob("views-flush", start, err)
+		if err != nil {
+			slog.Error("could not write view counts", "err", err)
+		}
+	}
+}
+
+// addViews adds the counts of pages to the database, as views of the day.
+func addViews(counts map[string]uint64, now time.Time) error {
+	day := now.Format("2006-01-02")
+	oldest := now.AddDate(0, 0, -viewDays).Format("2006-01-02")
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("views"))
+		for title, n := range counts {
+			v := &PageViews{}
+			if bs := b.Get([]byte(title)); bs != nil {
+				if err := fromBytes(bs, v); err != nil {
+					// counts are not worth to stop counting.
+					slog.Warn("corrupted view counts", "page", title, "err", err)
+					v = &PageViews{}
+				}
+			}
+			if v.Days == nil {
+				v.Days = make(map[string]uint64)
+			}
+			v.Total += n
+			v.Days[day] += n
+			for d := range v.Days {
+				// dates in the format are ordered as strings.
+				if d <= oldest {
+					delete(v.Days, d)
+				}
+			}
+			if err := putRecord(b, []byte(title), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadViews returns view counts of the page.
+func loadViews(title string) (*PageViews, error) {
+	v := &PageViews{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket([]byte("views")).Get([]byte(title))
+		if bs == nil {
+			return nil
+		}
+		return fromBytes(bs, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// recent returns the views since days ago.
+func (v *PageViews) recent(now time.Time, days int) uint64 {
+	since := now.AddDate(0, 0, -days).Format("2006-01-02")
+	var n uint64
+	for d, c := range v.Days {
+		if d > since {
+			n += c
+		}
+	}
+	return n
+}
+
+type PageViewCount struct {
+	Title string
+	Views uint64
+}
+
+type StatsPage struct {
+	// Title is empty, as it's not a page.
+	Title       string
+	MostViewed  []PageViewCount
+	Popular     []PageViewCount
+	PopularDays int
+}
+
+// topViews returns at most n pages with most views.
+func topViews(counts []PageViewCount, n int) []PageViewCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Views != counts[j].Views {
+			return counts[i].Views > counts[j].Views
+		}
+		return counts[i].Title < counts[j].Title
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var total, recent []PageViewCount
+	err := viewTx(r.Context(), func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("views")).ForEach(func(k, bs []byte) error {
+			v := &PageViews{}
+			if err := fromBytes(bs, v); err != nil {
+				return err
+			}
+			total = append(total, PageViewCount{Title: string(k), Views: v.Total})
+			if n := v.recent(now, popularDays); n != 0 {
+				recent = append(recent, PageViewCount{Title: string(k), Views: n})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "stats", &StatsPage{
+		MostViewed:  topViews(total, 20),
+		Popular:     topViews(recent, 20),
+		PopularDays: popularDays,
+	})
+}
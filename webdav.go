@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdav lets users mount the wiki as a filesystem at /dav/.
+//
+// pages are .md files at the path of titleFilename. (ex. Docs/Setup.md)
+// attachments of a page are in a directory next to the page with ~files suffix.
+// (ex. Docs/Setup~files/diagram.png) ~ is always escaped in page file names,
+// so the directory cannot be mistaken for a page.
+//
+// anyone can read. writing needs basic auth with the user's password or an api token.
+// saving a .md file makes a new revision of the page.
+
+const attachmentDirSuffix = "~files"
+
+var davLocks = webdav.NewMemLS()
+
+func davHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := davUser(r)
+	if err != nil || (u == nil && !davReadOnly(r.Method)) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="whisky"`)
+		http.Error(w, "please log in with your password or an api token", http.StatusUnauthorized)
+		return
+	}
+	if u != nil {
+		noteAccessUser(r, u.Name)
+	}
+	if basePath != "" {
+		// hrefs in responses and Destination headers have the base path.
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = basePath + u.Path
+		r2.URL = &u
+		r = r2
+	}
+	h := &webdav.Handler{
+		Prefix:     basePath + "/dav",
+		FileSystem: &wikiFS{user: u},
+		LockSystem: davLocks,
+	}
+	h.ServeHTTP(w, r)
+}
+
+func davReadOnly(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND":
+		return true
+	}
+	return false
+}
+
+// davUser returns the user of the basic auth. It returns nil without basic auth.
+func davUser(r *http.Request) (*User, error) {
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if u, err := loadTokenUser(pass); err == nil && u.Name == name {
+		return u, nil
+	}
+	return checkPassword(name, pass)
+}
+
+// wikiFS is a webdav.FileSystem of the wiki for a user.
+type wikiFS struct {
+	user *User
+}
+
+// davNode is what a webdav path refers to.
+type davNode struct {
+	// title is the page of a page file, attachment or attachment directory.
+	title string
+	// attachment is the attachment name, when the node is an attachment.
+	attachment string
+	// dir is true for a directory, including an attachment directory.
+	dir bool
+	// attachDir is true for an attachment directory.
+	attachDir bool
+}
+
+// parseDavPath tells what the path refers to, without checking it exists.
+func parseDavPath(name string) (*davNode, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return &davNode{dir: true}, nil
+	}
+	segs := strings.Split(name, "/")
+	for i, s := range segs {
+		if !strings.HasSuffix(s, attachmentDirSuffix) || s == attachmentDirSuffix {
+			continue
+		}
+		title, err := filenameTitle(strings.Join(append(segs[:i:i], strings.TrimSuffix(s, attachmentDirSuffix)), "/") + filenameExt)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		switch len(segs) - i {
+		case 1:
+			return &davNode{title: title, dir: true, attachDir: true}, nil
+		case 2:
+			return &davNode{title: title, attachment: segs[i+1]}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	if strings.HasSuffix(name, filenameExt) {
+		title, err := filenameTitle(name)
+		if err == nil {
+			return &davNode{title: title}, nil
+		}
+	}
+	return &davNode{title: name, dir: true}, nil
+}
+
+// davEntry is an entry of a directory listing.
+type davEntry struct {
+	name string
+	dir  bool
+}
+
+// listDir returns entries of the directory. It returns nil when it does not exist.
+// dir is a slash separated path without leading slash. "" is the root.
+func listDir(ctx context.Context, dir string) ([]davEntry, error) {
+	titles, err := listTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := attachmentTitles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, t := range titles {
+		paths = append(paths, titleFilename(t))
+	}
+	for _, t := range attached {
+		paths = append(paths, strings.TrimSuffix(titleFilename(t), filenameExt)+attachmentDirSuffix+"/")
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	seen := make(map[string]bool)
+	entries := []davEntry{}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		name, isDir := rest, false
+		if i := strings.Index(rest, "/"); i != -1 {
+			name, isDir = rest[:i], true
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		entries = append(entries, davEntry{name: name, dir: isDir})
+	}
+	if dir != "" && len(entries) == 0 {
+		return nil, nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+func (fs *wikiFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	// directories are made by pages in them.
+	return nil
+}
+
+func (fs *wikiFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (fs *wikiFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	n, err := parseDavPath(name)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base("/" + name)
+	if n.dir {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, os.ErrPermission
+		}
+		var entries []davEntry
+		if n.attachDir {
+			as, err := listAttachments(ctx, n.title)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range as {
+				entries = append(entries, davEntry{name: a.Name})
+			}
+			if len(as) == 0 {
+				if _, err := loadPage(ctx, n.title); err != nil {
+					return nil, os.ErrNotExist
+				}
+			}
+		} else {
+			entries, err = listDir(ctx, strings.TrimPrefix(path.Clean("/"+name), "/"))
+			if err != nil {
+				return nil, err
+			}
+			if entries == nil {
+				return nil, os.ErrNotExist
+			}
+		}
+		return &davDir{fs: fs, ctx: ctx, dir: strings.TrimSuffix(path.Clean("/"+name), "/"), name: base, entries: entries}, nil
+	}
+	var (
+		data    []byte
+		modTime time.Time
+		exists  = true
+	)
+	if n.attachment != "" {
+		a, err := loadAttachment(ctx, n.title, n.attachment)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			data, modTime = a.Data, a.Uploaded
+		} else {
+			exists = false
+		}
+	} else {
+		p, err := loadPage(ctx, n.title)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			data, modTime = p.Body, p.Created
+		} else {
+			exists = false
+		}
+	}
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if !exists && flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	if write {
+		if err := fs.checkWrite(ctx, n.title); err != nil {
+			return nil, err
+		}
+	}
+	orig := data
+	if flag&os.O_TRUNC != 0 {
+		data = nil
+	}
+	f := &davFile{fs: fs, ctx: ctx, node: n, name: base, modTime: modTime, write: write, orig: orig}
+	f.buf = *bytes.NewReader(data)
+	if write {
+		f.data = append([]byte{}, data...)
+	}
+	return f, nil
+}
+
+func (fs *wikiFS) checkWrite(ctx context.Context, title string) error {
+	if fs.user == nil {
+		return os.ErrPermission
+	}
+	prot, err := loadProtection(title)
+	if err != nil {
+		return err
+	}
+	if !prot.CanEdit(fs.user) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+func (fs *wikiFS) RemoveAll(ctx context.Context, name string) error {
+	n, err := parseDavPath(name)
+	if err != nil {
+		return err
+	}
+	if n.dir {
+		return os.ErrPermission
+	}
+	if n.attachment != "" {
+		if err := fs.checkWrite(ctx, n.title); err != nil {
+			return err
+		}
+		return davError(deleteAttachment(ctx, n.title, n.attachment))
+	}
+	// same with the api, only admins can delete pages.
+	if fs.user == nil || !fs.user.Admin {
+		return os.ErrPermission
+	}
+	return davError(deletePage(ctx, n.title))
+}
+
+func (fs *wikiFS) Rename(ctx context.Context, oldName, newName string) error {
+	// pages cannot be renamed yet.
+	return os.ErrPermission
+}
+
+// davError converts errors of the wiki to ones the webdav package understands.
+func davError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, ErrForbidden):
+		return os.ErrPermission
+	}
+	return err
+}
+
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *davFileInfo) Name() string       { return fi.name }
+func (fi *davFileInfo) Size() int64        { return fi.size }
+func (fi *davFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *davFileInfo) IsDir() bool        { return fi.dir }
+func (fi *davFileInfo) Sys() interface{}   { return nil }
+
+func (fi *davFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// davFile is a page or an attachment.
+// written data is saved when it is closed.
+type davFile struct {
+	fs      *wikiFS
+	ctx     context.Context
+	node    *davNode
+	name    string
+	modTime time.Time
+	buf     bytes.Reader
+	write   bool
+	orig    []byte
+	data    []byte
+	off     int64
+}
+
+func (f *davFile) Read(p []byte) (int, error) { return f.buf.Read(p) }
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.write {
+		var abs int64
+		switch whence {
+		case io.SeekStart:
+			abs = offset
+		case io.SeekCurrent:
+			abs = f.off + offset
+		case io.SeekEnd:
+			abs = int64(len(f.data)) + offset
+		}
+		if abs < 0 {
+			return 0, os.ErrInvalid
+		}
+		f.off = abs
+		return abs, nil
+	}
+	return f.buf.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if !f.write {
+		return 0, os.ErrPermission
+	}
+	end := f.off + int64(len(p))
+	if end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[f.off:], p)
+	f.off = end
+	return len(p), nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	size := int64(f.buf.Size())
+	if f.write {
+		size = int64(len(f.data))
+	}
+	return &davFileInfo{name: f.name, size: size, modTime: f.modTime}, nil
+}
+
+func (f *davFile) Close() error {
+	if !f.write || (bytes.Equal(f.data, f.orig) && !f.modTime.IsZero()) {
+		return nil
+	}
+	// the request could be done already, but the file should be saved.
+	ctx := context.Background()
+	if f.node.attachment != "" {
+		name := f.node.attachment
+		return davError(saveAttachment(ctx, f.node.title, &Attachment{
+			Name:        name,
+			ContentType: attachmentType(name, f.data),
+			Data:        f.data,
+			Uploaded:    time.Now(),
+			By:          f.fs.user.Name,
+		}))
+	}
+	p := &Page{
+		Title:   f.node.title,
+		Body:    bytes.Replace(f.data, []byte("\r\n"), []byte("\n"), -1),
+		Created: time.Now(),
+		Author:  f.fs.user.Name,
+		Summary: "edited over webdav",
+	}
+	if prev, err := loadPage(ctx, p.Title); err == nil {
+		p.Attribution = prev.Attribution
+	}
+	_, err := submitEdit(ctx, f.fs.user, p)
+	return err
+}
+
+// davDir is a directory of pages, or attachments of a page.
+type davDir struct {
+	fs      *wikiFS
+	ctx     context.Context
+	dir     string
+	name    string
+	entries []davEntry
+	read    int
+}
+
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *davDir) Close() error                                 { return nil }
+
+func (d *davDir) Stat() (os.FileInfo, error) {
+	return &davFileInfo{name: d.name, dir: true}, nil
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.entries[d.read:]
+	if count > 0 && len(rest) > count {
+		rest = rest[:count]
+	}
+	if count > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	d.read += len(rest)
+	fis := []os.FileInfo{}
+	for _, e := range rest {
+		if e.dir {
+			fis = append(fis, &davFileInfo{name: e.name, dir: true})
+			continue
+		}
+		fi, err := d.fs.Stat(d.ctx, d.dir+"/"+e.name)
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// webhooks are urls those receive a json payload when a page is saved.
+//
+// the payload is signed with the hook's secret, so receivers can check it is
+// from the wiki. the signature is in X-Whisky-Signature header.
+// (ex. sha256=<hex encoded hmac-sha256 of the body>)
+
+type Webhook struct {
+	ID      uint64
+	URL     string
+	Secret  string
+	By      string
+	Created time.Time
+}
+
+// WebhookPayload is the json body sent to webhooks.
+type WebhookPayload struct {
+	Event    string    `json:"event"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Revision uint64    `json:"revision"`
+	Summary  string    `json:"summary,omitempty"`
+	DiffURL  string    `json:"diff_url"`
+	Created  time.Time `json:"created"`
+}
+
+// Delivery is a log of sending a payload to a webhook.
+type Delivery struct {
+	ID       uint64
+	Hook     uint64
+	URL      string
+	Title    string
+	Revision uint64
+	Attempts int
+	// Status is http status of the last attempt. 0 means it could not get a response.
+	Status int
+	Error  string
+	Time   time.Time
+}
+
+func (d *Delivery) OK() bool {
+	return d.Status >= 200 && d.Status < 300
+}
+
+// webhookRetries are waits before each attempt.
+var webhookRetries = []time.Duration{0, 10 * time.Second, time.Minute, 10 * time.Minute}
+
+// maxDeliveries is number of deliveries to keep in the log.
+const maxDeliveries = 200
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func loadWebhooks() ([]*Webhook, error) {
+	hooks := []*Webhook{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("webhooks")).ForEach(func(k, v []byte) error {
+			h := &Webhook{}
+			if err := fromBytes(v, h); err != nil {
+				return err
+			}
+			hooks = append(hooks, h)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func addWebhook(h *Webhook) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("webhooks"))
+		h.ID, _ = b.NextSequence()
+		return putRecord(b, byteID(h.ID), h)
+	})
+}
+
+func removeWebhook(id uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("webhooks"))
+		if b.Get(byteID(id)) == nil {
+			return newError(ErrNotFound, "webhook not exists")
+		}
+		return b.Delete(byteID(id))
+	})
+}
+
+// saveDelivery saves the delivery, and removes old ones over maxDeliveries.
+func saveDelivery(d *Delivery) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("deliveries"))
+		if d.ID == 0 {
+			d.ID, _ = b.NextSequence()
+		}
+		err := putRecord(b, byteID(d.ID), d)
+		if err != nil {
+			return err
+		}
+		// ids are sequential, old ones are smaller than the cut.
+		if d.ID <= maxDeliveries {
+			return nil
+		}
+		cut := byteID(d.ID - maxDeliveries)
+		old := [][]byte{}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cut) <= 0; k, _ = c.Next() {
+			old = append(old, k)
+		}
+		for _, k := range old {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadDeliveries returns recent deliveries from the latest one.
+func loadDeliveries(n int) ([]*Delivery, error) {
+	ds := []*Delivery{}
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("deliveries")).Cursor()
+		for k, v := c.Last(); k != nil && len(ds) < n; k, v = c.Prev() {
+			d := &Delivery{}
+			if err := fromBytes(v, d); err != nil {
+				return err
+			}
+			ds = append(ds, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhooks sends the saved revision to all webhooks.
+// It should be called after the revision is committed.
+func deliverWebhooks(p *Page, rev uint64) {
+	var hooks []*Webhook
+	err := usingDB(func() (err error) {
+		hooks, err = loadWebhooks()
+		return err
+	})
+	if err != nil {
+		slog.Error("could not load webhooks", "err", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	payload := &WebhookPayload{
+		Event:    "page.save",
+		Title:    p.Title,
+		Author:   p.Author,
+		Revision: rev,
+		Summary:  p.Summary,
+		DiffURL:  siteSettings().URL + "/diff/" + url.PathEscape(p.Title) + "?to=" + strconv.FormatUint(rev, 10),
+		Created:  p.Created,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("could not encode webhook payload", "err", err)
+		return
+	}
+	for _, h := range hooks {
+		go deliverWebhook(h, payload, body)
+	}
+}
+
+// deliverWebhook sends the body to the hook, retrying while it fails.
+func deliverWebhook(h *Webhook, payload *WebhookPayload, body []byte) {
+	d := &Delivery{Hook: h.ID, URL: h.URL, Title: payload.Title, Revision: payload.Revision, Time: time.Now()}
+	// save it first to get an id for X-Whisky-Delivery header.
+	err := usingDB(func() error { return saveDelivery(d) })
+	if err != nil {
+		slog.Error("could not save webhook delivery", "err", err)
+	}
+	for _, wait := range webhookRetries {
+		time.Sleep(wait)
+		d.Attempts++
+		d.Time = time.Now()
+		d.Status, d.Error = postWebhook(h, d, body)
+		var derr error
+		if !d.OK() {
+			derr = errors.New(d.Error)
+		}
+		observeJob("webhook", d.Time, derr)
+		err = usingDB(func() error { return saveDelivery(d) })
+		if err != nil {
+			slog.Error("could not save webhook delivery", "err", err)
+		}
+		if d.OK() {
+			return
+		}
+	}
+	slog.Warn("could not deliver webhook", "url", h.URL, "status", d.Status, "err", d.Error)
+}
+
+func postWebhook(h *Webhook, d *Delivery, body []byte) (status int, errmsg string) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whisky-webhook")
+	req.Header.Set("X-Whisky-Event", "page.save")
+	req.Header.Set("X-Whisky-Delivery", strconv.FormatUint(d.ID, 10))
+	req.Header.Set("X-Whisky-Signature", webhookSignature(h.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, resp.Status
+	}
+	return resp.StatusCode, ""
+}
+
+type WebhooksPage struct {
+	Title      string
+	Webhooks   []*Webhook
+	Deliveries []*Delivery
+	ChatHooks  []*ChatHook
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var err error
+		if id := r.FormValue("remove"); id != "" {
+			n, perr := strconv.ParseUint(id, 10, 64)
+			if perr != nil {
+				httpError(w, r, newError(ErrInvalid, "invalid webhook: %s", id))
+				return
+			}
+			err = removeWebhook(n)
+		} else {
+			err = addWebhookFromForm(r)
+		}
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/webhooks", http.StatusFound)
+		return
+	}
+	hooks, err := loadWebhooks()
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	ds, err := loadDeliveries(50)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	chats, err := loadChatHooks()
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	renderTemplate(w, r, "webhooks", &WebhooksPage{Webhooks: hooks, Deliveries: ds, ChatHooks: chats})
+}
+
+func addWebhookFromForm(r *http.Request) error {
+	u, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newError(ErrInvalid, "webhook url should be a http or https url")
+	}
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		key := make([]byte, 20)
+		_, err := rand.Read(key)
+		if err != nil {
+			return err
+		}
+		secret = hex.EncodeToString(key)
+	}
+	return addWebhook(&Webhook{URL: u.String(), Secret: secret, By: authorName(r), Created: time.Now()})
+}
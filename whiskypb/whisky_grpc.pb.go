@@ -0,0 +1,238 @@
+// grpc api of whisky.
+//
+// it has the same features with the http api (/api/v1/pages/),
+// for services those prefer grpc.
+// writing needs an api token in "authorization" metadata. (ex. "Bearer <token>")
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: whisky.proto
+
+package whiskypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Pages_GetPage_FullMethodName       = "/whisky.v1.Pages/GetPage"
+	Pages_PutPage_FullMethodName       = "/whisky.v1.Pages/PutPage"
+	Pages_DeletePage_FullMethodName    = "/whisky.v1.Pages/DeletePage"
+	Pages_ListRevisions_FullMethodName = "/whisky.v1.Pages/ListRevisions"
+)
+
+// PagesClient is the client API for Pages service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PagesClient interface {
+	// GetPage returns the latest revision of a page, or the revision if it is set.
+	GetPage(ctx context.Context, in *GetPageRequest, opts ...grpc.CallOption) (*Page, error)
+	// PutPage saves a new revision of a page.
+	PutPage(ctx context.Context, in *PutPageRequest, opts ...grpc.CallOption) (*PutPageResponse, error)
+	// DeletePage deletes a page with it's history. only admins can delete pages.
+	DeletePage(ctx context.Context, in *DeletePageRequest, opts ...grpc.CallOption) (*DeletePageResponse, error)
+	// ListRevisions returns revisions of a page from the latest one.
+	ListRevisions(ctx context.Context, in *ListRevisionsRequest, opts ...grpc.CallOption) (*ListRevisionsResponse, error)
+}
+
+type pagesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPagesClient(cc grpc.ClientConnInterface) PagesClient {
+	return &pagesClient{cc}
+}
+
+func (c *pagesClient) GetPage(ctx context.Context, in *GetPageRequest, opts ...grpc.CallOption) (*Page, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Page)
+	err := c.cc.Invoke(ctx, Pages_GetPage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pagesClient) PutPage(ctx context.Context, in *PutPageRequest, opts ...grpc.CallOption) (*PutPageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutPageResponse)
+	err := c.cc.Invoke(ctx, Pages_PutPage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pagesClient) DeletePage(ctx context.Context, in *DeletePageRequest, opts ...grpc.CallOption) (*DeletePageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeletePageResponse)
+	err := c.cc.Invoke(ctx, Pages_DeletePage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pagesClient) ListRevisions(ctx context.Context, in *ListRevisionsRequest, opts ...grpc.CallOption) (*ListRevisionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRevisionsResponse)
+	err := c.cc.Invoke(ctx, Pages_ListRevisions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PagesServer is the server API for Pages service.
+// All implementations must embed UnimplementedPagesServer
+// for forward compatibility
+type PagesServer interface {
+	// GetPage returns the latest revision of a page, or the revision if it is set.
+	GetPage(context.Context, *GetPageRequest) (*Page, error)
+	// PutPage saves a new revision of a page.
+	PutPage(context.Context, *PutPageRequest) (*PutPageResponse, error)
+	// DeletePage deletes a page with it's history. only admins can delete pages.
+	DeletePage(context.Context, *DeletePageRequest) (*DeletePageResponse, error)
+	// ListRevisions returns revisions of a page from the latest one.
+	ListRevisions(context.Context, *ListRevisionsRequest) (*ListRevisionsResponse, error)
+	mustEmbedUnimplementedPagesServer()
+}
+
+// UnimplementedPagesServer must be embedded to have forward compatible implementations.
+type UnimplementedPagesServer struct {
+}
+
+func (UnimplementedPagesServer) GetPage(context.Context, *GetPageRequest) (*Page, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPage not implemented")
+}
+func (UnimplementedPagesServer) PutPage(context.Context, *PutPageRequest) (*PutPageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutPage not implemented")
+}
+func (UnimplementedPagesServer) DeletePage(context.Context, *DeletePageRequest) (*DeletePageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePage not implemented")
+}
+func (UnimplementedPagesServer) ListRevisions(context.Context, *ListRevisionsRequest) (*ListRevisionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRevisions not implemented")
+}
+func (UnimplementedPagesServer) mustEmbedUnimplementedPagesServer() {}
+
+// UnsafePagesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PagesServer will
+// result in compilation errors.
+type UnsafePagesServer interface {
+	mustEmbedUnimplementedPagesServer()
+}
+
+func RegisterPagesServer(s grpc.ServiceRegistrar, srv PagesServer) {
+	s.RegisterService(&Pages_ServiceDesc, srv)
+}
+
+func _Pages_GetPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PagesServer).GetPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pages_GetPage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PagesServer).GetPage(ctx, req.(*GetPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pages_PutPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PagesServer).PutPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pages_PutPage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PagesServer).PutPage(ctx, req.(*PutPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pages_DeletePage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PagesServer).DeletePage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pages_DeletePage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PagesServer).DeletePage(ctx, req.(*DeletePageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pages_ListRevisions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRevisionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PagesServer).ListRevisions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pages_ListRevisions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PagesServer).ListRevisions(ctx, req.(*ListRevisionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Pages_ServiceDesc is the grpc.ServiceDesc for Pages service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Pages_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "whisky.v1.Pages",
+	HandlerType: (*PagesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPage",
+			Handler:    _Pages_GetPage_Handler,
+		},
+		{
+			MethodName: "PutPage",
+			Handler:    _Pages_PutPage_Handler,
+		},
+		{
+			MethodName: "DeletePage",
+			Handler:    _Pages_DeletePage_Handler,
+		},
+		{
+			MethodName: "ListRevisions",
+			Handler:    _Pages_ListRevisions_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "whisky.proto",
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// newTestWiki serves a new wiki in a temporary directory for the test.
+func newTestWiki(t *testing.T) *httptest.Server {
+	t.Helper()
+	oldPath := dbPath
+	dbPath = filepath.Join(t.TempDir(), "whisky.db")
+	if err := openDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newApp("Home"))
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+		dbPath = oldPath
+		pages.purge()
+	})
+	return srv
+}
+
+// testUser is a browser of a user signed up to the test wiki.
+type testUser struct {
+	t   *testing.T
+	srv *httptest.Server
+	c   *http.Client
+}
+
+// signup signs up the user with a browser, the first one is an admin.
+func signup(t *testing.T, srv *httptest.Server, name string) *testUser {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	u := &testUser{t: t, srv: srv, c: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+	resp := u.post("/view/Home?signup=1", url.Values{"username": {name}, "password": {"password1"}, "password2": {"password1"}})
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("could not sign up %s: %s", name, resp.Status)
+	}
+	return u
+}
+
+// get gets the page, and returns the response with it's body read.
+func (u *testUser) get(path string) (*http.Response, string) {
+	u.t.Helper()
+	resp, err := u.c.Get(u.srv.URL + path)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	return resp, string(bs)
+}
+
+// post posts the form as it is, without the csrf token.
+func (u *testUser) post(path string, form url.Values) *http.Response {
+	u.t.Helper()
+	resp, err := u.c.PostForm(u.srv.URL+path, form)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf" value="([^"]*)"`)
+
+// submit posts the form with the csrf token of the user's forms, like a browser does.
+func (u *testUser) submit(path string, form url.Values) *http.Response {
+	u.t.Helper()
+	_, body := u.get("/preferences")
+	m := csrfInput.FindStringSubmatch(body)
+	if m == nil || m[1] == "" {
+		u.t.Fatal("no csrf token in the form")
+	}
+	f := url.Values{"csrf": {m[1]}}
+	for k, v := range form {
+		f[k] = v
+	}
+	return u.post(path, f)
+}
+
+// save saves the page with the editor.
+func (u *testUser) save(title, body string) *http.Response {
+	u.t.Helper()
+	return u.submit("/save/"+title, url.Values{"body": {body}})
+}
+
+// pageBody returns the markdown of the latest revision of the page.
+func pageBody(t *testing.T, srv *httptest.Server, title string) string {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/raw/" + title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("could not get %s: %s", title, resp.Status)
+	}
+	return string(bs)
+}